@@ -0,0 +1,86 @@
+// Command libsql-dump dumps a libSQL database as an SQL script, or restores a database from such a
+// script. It accepts every URL that libsql.NewConnector accepts:
+//
+//	libsql-dump [-auth-token TOKEN] URL [FILE]
+//	libsql-dump [-auth-token TOKEN] -restore URL [FILE]
+//
+// The dump is written to FILE, or to the standard output if FILE is not given. With -restore the
+// script is read from FILE or the standard input and executed in a single transaction.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/tursodatabase/libsql-client-go/libsql"
+)
+
+func main() {
+	authToken := flag.String("auth-token", os.Getenv("LIBSQL_AUTH_TOKEN"), "auth token of the database, defaults to $LIBSQL_AUTH_TOKEN")
+	restore := flag.Bool("restore", false, "restore the database from a dump instead of dumping it")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] URL [FILE]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(flag.Arg(0), *authToken, *restore, flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(url string, authToken string, restore bool, args []string) error {
+	var opts []libsql.Option
+	if authToken != "" {
+		opts = append(opts, libsql.WithAuthToken(authToken))
+	}
+	connector, err := libsql.NewConnector(url, opts...)
+	if err != nil {
+		return err
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if restore {
+		var in io.Reader = os.Stdin
+		if len(args) > 0 {
+			file, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			in = file
+		}
+		return libsql.Restore(ctx, conn, in)
+	}
+
+	if len(args) == 0 {
+		return libsql.Dump(ctx, conn, os.Stdout)
+	}
+	file, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	if err := libsql.Dump(ctx, conn, file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
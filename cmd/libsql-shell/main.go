@@ -0,0 +1,82 @@
+// Command libsql-shell is an interactive SQL shell for libSQL databases. It accepts every URL that
+// libsql.NewConnector accepts:
+//
+//	libsql-shell [-auth-token TOKEN] [-mode table|csv|json] URL [SQL]
+//
+// Statements can span several lines and are executed once they are complete. If SQL is given, it is
+// executed instead of reading statements from the standard input.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/tursodatabase/libsql-client-go/libsql"
+)
+
+func main() {
+	authToken := flag.String("auth-token", os.Getenv("LIBSQL_AUTH_TOKEN"), "auth token of the database, defaults to $LIBSQL_AUTH_TOKEN")
+	mode := flag.String("mode", "table", "output mode: table, csv or json")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] URL [SQL]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(flag.Arg(0), *authToken, *mode, flag.Args()[1:]); err != nil {
+		if err != errFailed {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// errFailed is returned by run when statements read from a script or the command line failed. Their
+// errors were already printed.
+var errFailed = errors.New("statements failed")
+
+func run(url string, authToken string, mode string, args []string) error {
+	var opts []libsql.Option
+	if authToken != "" {
+		opts = append(opts, libsql.WithAuthToken(authToken))
+	}
+	connector, err := libsql.NewConnector(url, opts...)
+	if err != nil {
+		return err
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	sh := newShell(conn, os.Stdout, os.Stderr)
+	if err := sh.setMode(mode); err != nil {
+		return err
+	}
+	var in io.Reader = os.Stdin
+	if len(args) > 0 {
+		in = strings.NewReader(args[0])
+	} else if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		sh.interactive = true
+	}
+	if failed := sh.run(ctx, in); failed && !sh.interactive {
+		return errFailed
+	}
+	return nil
+}
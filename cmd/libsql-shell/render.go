@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// renderer writes a result set to out.
+type renderer func(out io.Writer, cols []string, rows [][]any) error
+
+var renderers = map[string]renderer{
+	"table": renderTable,
+	"csv":   renderCSV,
+	"json":  renderJSON,
+}
+
+// formatValue formats a value for the table and CSV modes. Blobs are written as SQL literals.
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return "X'" + strings.ToUpper(hex.EncodeToString(v)) + "'"
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+func renderTable(out io.Writer, cols []string, rows [][]any) error {
+	cells := make([][]string, len(rows))
+	widths := make([]int, len(cols))
+	for idx, col := range cols {
+		widths[idx] = utf8.RuneCountInString(col)
+	}
+	for rowIdx, row := range rows {
+		cells[rowIdx] = make([]string, len(row))
+		for idx, v := range row {
+			cells[rowIdx][idx] = formatValue(v)
+			if width := utf8.RuneCountInString(cells[rowIdx][idx]); width > widths[idx] {
+				widths[idx] = width
+			}
+		}
+	}
+
+	var b strings.Builder
+	separator := func() {
+		for _, width := range widths {
+			b.WriteString("+")
+			b.WriteString(strings.Repeat("-", width+2))
+		}
+		b.WriteString("+\n")
+	}
+	line := func(values []string) {
+		for idx, v := range values {
+			b.WriteString("| ")
+			b.WriteString(v)
+			b.WriteString(strings.Repeat(" ", widths[idx]-utf8.RuneCountInString(v)+1))
+		}
+		b.WriteString("|\n")
+	}
+	separator()
+	line(cols)
+	separator()
+	for _, row := range cells {
+		line(row)
+	}
+	if len(cells) > 0 {
+		separator()
+	}
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+// renderCSV writes the column names as header followed by the rows. NULL is written as an empty
+// field.
+func renderCSV(out io.Writer, cols []string, rows [][]any) error {
+	w := csv.NewWriter(out)
+	if err := w.Write(cols); err != nil {
+		return err
+	}
+	record := make([]string, len(cols))
+	for _, row := range rows {
+		for idx, v := range row {
+			record[idx] = ""
+			if v != nil {
+				record[idx] = formatValue(v)
+			}
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// renderJSON writes the rows as an array of objects whose keys are in column order. Blobs are
+// base64 encoded.
+func renderJSON(out io.Writer, cols []string, rows [][]any) error {
+	keys := make([][]byte, len(cols))
+	for idx, col := range cols {
+		key, err := json.Marshal(col)
+		if err != nil {
+			return err
+		}
+		keys[idx] = key
+	}
+	var b strings.Builder
+	b.WriteString("[")
+	for rowIdx, row := range rows {
+		if rowIdx > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n  {")
+		for idx, v := range row {
+			if idx > 0 {
+				b.WriteString(", ")
+			}
+			value, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			b.Write(keys[idx])
+			b.WriteString(": ")
+			b.Write(value)
+		}
+		b.WriteString("}")
+	}
+	if len(rows) > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString("]\n")
+	_, err := io.WriteString(out, b.String())
+	return err
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/tursodatabase/libsql-client-go/sqliteparser"
+	"github.com/tursodatabase/libsql-client-go/sqliteparserutils"
+)
+
+const (
+	prompt             = "libsql> "
+	continuationPrompt = "   ...> "
+)
+
+// shell executes the statements and dot-commands it reads on a single connection, so that
+// transactions can span several statements.
+type shell struct {
+	conn   *sql.Conn
+	out    io.Writer
+	errOut io.Writer
+	render renderer
+	timer  bool
+	// interactive enables prompts.
+	interactive bool
+}
+
+func newShell(conn *sql.Conn, out io.Writer, errOut io.Writer) *shell {
+	return &shell{conn: conn, out: out, errOut: errOut, render: renderTable}
+}
+
+// run reads input until its end or until .quit and reports whether any statement or command failed.
+func (sh *shell) run(ctx context.Context, in io.Reader) (failed bool) {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var input strings.Builder
+	sh.prompt(prompt)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if input.Len() == 0 && strings.HasPrefix(strings.TrimSpace(line), ".") {
+			quit, err := sh.command(ctx, strings.TrimSpace(line))
+			if err != nil {
+				sh.printError(err)
+				failed = true
+			}
+			if quit {
+				return failed
+			}
+			sh.prompt(prompt)
+			continue
+		}
+		input.WriteString(line)
+		input.WriteString("\n")
+		statements, complete := splitInput(input.String())
+		if !complete {
+			sh.prompt(continuationPrompt)
+			continue
+		}
+		input.Reset()
+		for _, stmt := range statements {
+			if err := sh.execute(ctx, stmt); err != nil {
+				sh.printError(err)
+				failed = true
+			}
+		}
+		sh.prompt(prompt)
+	}
+	if err := scanner.Err(); err != nil {
+		sh.printError(err)
+		return true
+	}
+	// Input that ends without a semicolon is executed anyway, like the sqlite3 shell does.
+	if statements, _ := sqliteparserutils.SplitStatement(input.String()); len(statements) > 0 {
+		for _, stmt := range statements {
+			if err := sh.execute(ctx, stmt); err != nil {
+				sh.printError(err)
+				failed = true
+			}
+		}
+	}
+	if sh.interactive {
+		fmt.Fprintln(sh.out)
+	}
+	return failed
+}
+
+// splitInput splits input into statements and reports whether the last of them is complete, which
+// means that it ends with a semicolon outside of a trigger body and of a comment. Input that holds
+// no statement at all counts as complete.
+func splitInput(input string) ([]string, bool) {
+	statements, info := sqliteparserutils.SplitStatement(input)
+	if info.IncompleteCreateTriggerStatement || info.IncompleteMultilineComment {
+		return nil, false
+	}
+	if len(statements) > 0 && info.LastTokenType != sqliteparser.SQLiteLexerSCOL {
+		return nil, false
+	}
+	return statements, true
+}
+
+func (sh *shell) prompt(p string) {
+	if sh.interactive {
+		fmt.Fprint(sh.out, p)
+	}
+}
+
+func (sh *shell) printError(err error) {
+	fmt.Fprintf(sh.errOut, "Error: %s\n", err)
+}
+
+// execute executes stmt and renders its rows. Statements that return no columns print nothing.
+func (sh *shell) execute(ctx context.Context, stmt string) error {
+	start := time.Now()
+	rows, err := sh.conn.QueryContext(ctx, stmt)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	var values [][]any
+	for rows.Next() {
+		row := make([]any, len(cols))
+		dest := make([]any, len(cols))
+		for idx := range row {
+			dest[idx] = &row[idx]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		values = append(values, row)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(cols) > 0 {
+		if err := sh.render(sh.out, cols, values); err != nil {
+			return err
+		}
+	}
+	if sh.timer {
+		fmt.Fprintf(sh.out, "Run Time: %.3fs\n", time.Since(start).Seconds())
+	}
+	return nil
+}
+
+const helpText = `.help                  Show this message
+.mode table|csv|json   Set the output mode
+.quit                  Exit the shell
+.schema ?PATTERN?      Show the CREATE statements of tables matching PATTERN
+.tables ?PATTERN?      List the tables and views matching PATTERN
+.timer on|off          Show the time every statement takes
+`
+
+// command executes a dot-command and reports whether the shell should exit.
+func (sh *shell) command(ctx context.Context, line string) (quit bool, err error) {
+	fields := strings.Fields(line)
+	name, args := fields[0], fields[1:]
+	switch name {
+	case ".quit", ".exit":
+		return true, nil
+	case ".help":
+		fmt.Fprint(sh.out, helpText)
+	case ".mode":
+		if len(args) != 1 {
+			return false, fmt.Errorf("usage: .mode table|csv|json")
+		}
+		return false, sh.setMode(args[0])
+	case ".timer":
+		if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+			return false, fmt.Errorf("usage: .timer on|off")
+		}
+		sh.timer = args[0] == "on"
+	case ".tables":
+		if len(args) > 1 {
+			return false, fmt.Errorf("usage: .tables ?PATTERN?")
+		}
+		return false, sh.tables(ctx, args)
+	case ".schema":
+		if len(args) > 1 {
+			return false, fmt.Errorf("usage: .schema ?PATTERN?")
+		}
+		return false, sh.schema(ctx, args)
+	default:
+		return false, fmt.Errorf("unknown command %s, enter .help for the list of commands", name)
+	}
+	return false, nil
+}
+
+func (sh *shell) setMode(mode string) error {
+	render, ok := renderers[mode]
+	if !ok {
+		return fmt.Errorf("unknown mode %q, expected table, csv or json", mode)
+	}
+	sh.render = render
+	return nil
+}
+
+// patternArgs returns the LIKE pattern given to .tables and .schema, which matches everything if
+// none was given.
+func patternArgs(args []string) string {
+	if len(args) == 0 {
+		return "%"
+	}
+	return args[0]
+}
+
+func (sh *shell) tables(ctx context.Context, args []string) error {
+	names, err := sh.queryStrings(ctx, "SELECT name FROM sqlite_master WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite_%' AND name LIKE ? ORDER BY name", patternArgs(args))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fmt.Fprintln(sh.out, name)
+	}
+	return nil
+}
+
+func (sh *shell) schema(ctx context.Context, args []string) error {
+	sqls, err := sh.queryStrings(ctx, "SELECT sql FROM sqlite_master WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%' AND tbl_name LIKE ? ORDER BY tbl_name, rowid", patternArgs(args))
+	if err != nil {
+		return err
+	}
+	for _, sql := range sqls {
+		fmt.Fprintf(sh.out, "%s;\n", sql)
+	}
+	return nil
+}
+
+func (sh *shell) queryStrings(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := sh.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/tursodatabase/libsql-client-go/libsql"
+	"github.com/tursodatabase/libsql-client-go/libsql/libsqltest"
+)
+
+func TestSplitInput(t *testing.T) {
+	tests := []struct {
+		input      string
+		statements []string
+		complete   bool
+	}{
+		{input: "", complete: true},
+		{input: "-- just a comment\n", complete: true},
+		{input: "SELECT 1", complete: false},
+		{input: "SELECT 1;", statements: []string{"SELECT 1"}, complete: true},
+		{input: "SELECT 1; SELECT\n2;", statements: []string{"SELECT 1", "SELECT\n2"}, complete: true},
+		{input: "SELECT 1; /* open", complete: false},
+		{input: "CREATE TRIGGER t AFTER INSERT ON a BEGIN\nSELECT 1;", complete: false},
+		{input: "CREATE TRIGGER t AFTER INSERT ON a BEGIN\nSELECT 1;\nEND;", statements: []string{"CREATE TRIGGER t AFTER INSERT ON a BEGIN\nSELECT 1;\nEND"}, complete: true},
+	}
+	for _, tt := range tests {
+		statements, complete := splitInput(tt.input)
+		if complete != tt.complete || strings.Join(statements, "|") != strings.Join(tt.statements, "|") {
+			t.Errorf("splitInput(%q) = %q, %v, want %q, %v", tt.input, statements, complete, tt.statements, tt.complete)
+		}
+	}
+}
+
+func TestShell(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	srv := libsqltest.NewServer(db)
+	defer srv.Close()
+	connector, err := libsql.NewConnector(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := sql.OpenDB(connector)
+	defer client.Close()
+	ctx := context.Background()
+	conn, err := client.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	input := `CREATE TABLE t (a INTEGER, b TEXT);
+INSERT INTO t VALUES
+  (1, 'x'), (2, NULL);
+CREATE TRIGGER tr AFTER DELETE ON t BEGIN
+  SELECT 1;
+END;
+SELECT * FROM t;
+.mode csv
+SELECT * FROM t;
+.mode json
+SELECT * FROM t WHERE a = 1;
+.tables
+.schema t
+SELECT * FROM missing;
+.quit
+SELECT 'not executed';
+`
+	var out, errOut bytes.Buffer
+	sh := newShell(conn, &out, &errOut)
+	if failed := sh.run(ctx, strings.NewReader(input)); !failed {
+		t.Error("expected the shell to report the failing statement")
+	}
+	want := `+---+------+
+| a | b    |
++---+------+
+| 1 | x    |
+| 2 | NULL |
++---+------+
+a,b
+1,x
+2,
+[
+  {"a": 1, "b": "x"}
+]
+t
+CREATE TABLE t (a INTEGER, b TEXT);
+CREATE TRIGGER tr AFTER DELETE ON t BEGIN
+  SELECT 1;
+END;
+`
+	if out.String() != want {
+		t.Errorf("got output\n%s\nwant\n%s", out.String(), want)
+	}
+	if !strings.Contains(errOut.String(), "no such table: missing") {
+		t.Errorf("got errors %q, want the missing table to be reported", errOut.String())
+	}
+}
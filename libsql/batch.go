@@ -0,0 +1,256 @@
+package libsql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/hrana"
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
+)
+
+// Batch is a list of statements that are executed in a single round trip by ExecuteBatch. Steps
+// can be made conditional on the outcome of earlier steps, for example to update a row only if
+// inserting it failed:
+//
+//	var b libsql.Batch
+//	insert := b.Add("INSERT INTO users (id, name) VALUES (?, ?)", id, name)
+//	b.AddIf(insert.Failed(), "UPDATE users SET name = ? WHERE id = ?", name, id)
+type Batch struct {
+	steps []batchStep
+	err   error
+}
+
+// batchStep is a step of a Batch. Its arguments are converted only when the batch is executed,
+// because the conversion depends on the connection.
+type batchStep struct {
+	query string
+	args  []any
+	cond  *hrana.BatchCondition
+}
+
+// BatchStep refers to a step of a Batch.
+type BatchStep int
+
+// BatchCondition decides whether a step of a Batch is executed.
+type BatchCondition struct {
+	cond hrana.BatchCondition
+	// maxStep is the highest step the condition refers to, or -1 if it refers to none.
+	maxStep BatchStep
+	// err is set if the condition is invalid. It is reported by AddIf.
+	err error
+}
+
+// Ok holds if the step was executed and succeeded.
+func (s BatchStep) Ok() BatchCondition {
+	step := int32(s)
+	return BatchCondition{cond: hrana.BatchCondition{Type: "ok", Step: &step}, maxStep: s}
+}
+
+// Failed holds if the step was executed and failed.
+func (s BatchStep) Failed() BatchCondition {
+	step := int32(s)
+	return BatchCondition{cond: hrana.BatchCondition{Type: "error", Step: &step}, maxStep: s}
+}
+
+// Not holds if c doesn't hold.
+func (c BatchCondition) Not() BatchCondition {
+	cond := c.cond
+	return BatchCondition{cond: hrana.BatchCondition{Type: "not", Cond: &cond}, maxStep: c.maxStep, err: c.err}
+}
+
+// BatchAnd holds if all of conds hold. conds must not be empty.
+func BatchAnd(conds ...BatchCondition) BatchCondition {
+	return combineConditions("and", conds)
+}
+
+// BatchOr holds if any of conds holds. conds must not be empty.
+func BatchOr(conds ...BatchCondition) BatchCondition {
+	return combineConditions("or", conds)
+}
+
+// BatchIsAutocommit holds if the connection is in autocommit mode, which means that no transaction
+// is open. It requires a server that speaks Hrana 3.
+func BatchIsAutocommit() BatchCondition {
+	return BatchCondition{cond: hrana.BatchCondition{Type: "is_autocommit"}, maxStep: -1}
+}
+
+func combineConditions(condType string, conds []BatchCondition) BatchCondition {
+	res := BatchCondition{cond: hrana.BatchCondition{Type: condType, Conds: make([]hrana.BatchCondition, len(conds))}, maxStep: -1}
+	if len(conds) == 0 {
+		// An empty list would be sent without the conds field, which servers reject.
+		res.err = fmt.Errorf("%q condition needs at least one condition", condType)
+	}
+	for idx, c := range conds {
+		res.cond.Conds[idx] = c.cond
+		if c.maxStep > res.maxStep {
+			res.maxStep = c.maxStep
+		}
+		if res.err == nil {
+			res.err = c.err
+		}
+	}
+	return res
+}
+
+// Add appends a statement that is always executed. Arguments are passed like to sql.DB.Exec.
+func (b *Batch) Add(query string, args ...any) BatchStep {
+	return b.add(nil, query, args)
+}
+
+// AddIf appends a statement that is executed only if cond holds. cond can only refer to steps that
+// were added before.
+func (b *Batch) AddIf(cond BatchCondition, query string, args ...any) BatchStep {
+	step := BatchStep(len(b.steps))
+	if cond.err != nil && b.err == nil {
+		b.err = fmt.Errorf("invalid condition of step %d: %w", step, cond.err)
+	}
+	if cond.maxStep >= step && b.err == nil {
+		b.err = fmt.Errorf("condition of step %d refers to step %d, which is not before it", step, cond.maxStep)
+	}
+	c := cond.cond
+	return b.add(&c, query, args)
+}
+
+func (b *Batch) add(cond *hrana.BatchCondition, query string, args []any) BatchStep {
+	b.steps = append(b.steps, batchStep{query: query, args: args, cond: cond})
+	return BatchStep(len(b.steps) - 1)
+}
+
+func batchStmt(query string, args []any, checker driver.NamedValueChecker) (hrana.Stmt, error) {
+	namedArgs := make([]driver.NamedValue, len(args))
+	for idx, arg := range args {
+		namedArgs[idx].Ordinal = idx + 1
+		if named, ok := arg.(sql.NamedArg); ok {
+			namedArgs[idx].Name = named.Name
+			arg = named.Value
+		}
+		namedArgs[idx].Value = arg
+		if err := checker.CheckNamedValue(&namedArgs[idx]); err != nil {
+			return hrana.Stmt{}, err
+		}
+	}
+	stmts, params, err := shared.ParseStatementAndArgs(query, namedArgs)
+	if err != nil {
+		return hrana.Stmt{}, err
+	}
+	if len(stmts) != 1 {
+		return hrana.Stmt{}, fmt.Errorf("a step must be a single statement, got %d", len(stmts))
+	}
+	stmt := hrana.Stmt{Sql: &stmts[0], WantRows: true}
+	if len(params) > 0 {
+		if err := stmt.AddArgs(params[0]); err != nil {
+			return hrana.Stmt{}, err
+		}
+	}
+	return stmt, nil
+}
+
+// BatchResult holds the outcome of every step of a Batch.
+type BatchResult struct {
+	Steps []BatchStepResult
+}
+
+// BatchStepResult is the outcome of a single step of a Batch.
+type BatchStepResult struct {
+	// Executed is false if the step was skipped because its condition didn't hold.
+	Executed bool
+	// Err is the error the step failed with. It is an *Error whose StmtIndex is the step.
+	Err          error
+	Columns      []string
+	Rows         [][]driver.Value
+	RowsAffected int64
+	LastInsertId int64
+}
+
+// transactionBatch returns a batch that executes stmts in a transaction. Every statement only runs
+// if the step before it succeeded. The statements are steps 1 to len(stmts), the COMMIT is step
+// len(stmts)+1, and it is followed by a ROLLBACK that runs unless the commit succeeded.
+func transactionBatch(stmts []hrana.Stmt) *hrana.Batch {
+	begin, commit, rollback := "BEGIN", "COMMIT", "ROLLBACK"
+	batch := &hrana.Batch{Steps: make([]hrana.BatchStep, 0, len(stmts)+3)}
+	batch.Add(hrana.Stmt{Sql: &begin}, nil)
+	for _, stmt := range stmts {
+		prev := int32(len(batch.Steps) - 1)
+		batch.Add(stmt, &hrana.BatchCondition{Type: "ok", Step: &prev})
+	}
+	last := int32(len(batch.Steps) - 1)
+	batch.Add(hrana.Stmt{Sql: &commit}, &hrana.BatchCondition{Type: "ok", Step: &last})
+	commitStep := int32(len(batch.Steps) - 1)
+	committed := hrana.BatchCondition{Type: "ok", Step: &commitStep}
+	batch.Add(hrana.Stmt{Sql: &rollback}, &hrana.BatchCondition{Type: "not", Cond: &committed})
+	return batch
+}
+
+type batchExecutor interface {
+	driver.NamedValueChecker
+	ExecuteBatch(ctx context.Context, batch *hrana.Batch) (*hrana.BatchResult, error)
+	TimeEncoding() shared.TimeEncoding
+}
+
+// ExecuteBatch executes batch on conn in a single round trip. Failing steps don't make
+// ExecuteBatch fail; their errors are reported in the result. Batches are only supported on
+// remote databases.
+func ExecuteBatch(ctx context.Context, conn *sql.Conn, batch *Batch) (*BatchResult, error) {
+	if batch.err != nil {
+		return nil, batch.err
+	}
+	var res *hrana.BatchResult
+	var times shared.TimeEncoding
+	err := conn.Raw(func(driverConn any) error {
+		executor, ok := driverConn.(batchExecutor)
+		if !ok {
+			return fmt.Errorf("batches are not supported by %T", driverConn)
+		}
+		steps := make([]hrana.BatchStep, len(batch.steps))
+		for idx, step := range batch.steps {
+			stmt, err := batchStmt(step.query, step.args, executor)
+			if err != nil {
+				return fmt.Errorf("invalid step %d: %w", idx, err)
+			}
+			steps[idx] = hrana.BatchStep{Stmt: stmt, Condition: step.cond}
+		}
+		times = executor.TimeEncoding()
+		var err error
+		res, err = executor.ExecuteBatch(ctx, &hrana.Batch{Steps: steps})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BatchResult{Steps: make([]BatchStepResult, len(batch.steps))}
+	for idx := range result.Steps {
+		step := &result.Steps[idx]
+		if step.Err = res.StepError(idx); step.Err != nil {
+			step.Executed = true
+			continue
+		}
+		if idx >= len(res.StepResults) || res.StepResults[idx] == nil {
+			continue
+		}
+		stmtResult := res.StepResults[idx]
+		step.Executed = true
+		step.Columns = make([]string, len(stmtResult.Cols))
+		for colIdx, col := range stmtResult.Cols {
+			if col.Name != nil {
+				step.Columns[colIdx] = *col.Name
+			}
+		}
+		step.Rows = make([][]driver.Value, len(stmtResult.Rows))
+		for rowIdx, row := range stmtResult.Rows {
+			step.Rows[rowIdx] = make([]driver.Value, len(row))
+			for colIdx := range row {
+				var colType *string
+				if colIdx < len(stmtResult.Cols) {
+					colType = stmtResult.Cols[colIdx].Type
+				}
+				step.Rows[rowIdx][colIdx] = row[colIdx].Decode(colType, times)
+			}
+		}
+		step.RowsAffected = int64(stmtResult.AffectedRowCount)
+		step.LastInsertId = stmtResult.GetLastInsertRowId()
+	}
+	return result, nil
+}
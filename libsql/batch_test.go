@@ -0,0 +1,105 @@
+package libsql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExecuteBatch(t *testing.T) {
+	urls := testURLs(t)
+	for _, name := range []string{"http", "ws"} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			conn := openConn(t, urls[name])
+			table := "batch_" + name
+
+			var b Batch
+			b.Add("CREATE TABLE " + table + " (id INTEGER PRIMARY KEY, name TEXT)")
+			insert := b.Add("INSERT INTO "+table+" VALUES (?, ?)", 1, "a")
+			duplicate := b.Add("INSERT INTO "+table+" VALUES (?, ?)", 1, "b")
+			b.AddIf(duplicate.Failed(), "UPDATE "+table+" SET name = ? WHERE id = ?", "b", 1)
+			b.AddIf(duplicate.Ok(), "INSERT INTO "+table+" VALUES (2, 'skipped')")
+			b.AddIf(duplicate.Ok().Not(), "SELECT id, name FROM "+table)
+			b.AddIf(BatchAnd(insert.Ok(), duplicate.Ok()), "SELECT 'and skipped'")
+			b.AddIf(BatchAnd(insert.Ok(), duplicate.Failed()), "SELECT 'and'")
+			b.AddIf(BatchOr(insert.Failed(), duplicate.Ok()), "SELECT 'or skipped'")
+			b.AddIf(BatchOr(insert.Failed(), duplicate.Failed()), "SELECT 'or'")
+			b.AddIf(BatchIsAutocommit(), "SELECT 'autocommit'")
+			b.AddIf(BatchIsAutocommit().Not(), "SELECT 'in a transaction'")
+			res, err := ExecuteBatch(ctx, conn, &b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var executed []bool
+			for _, step := range res.Steps {
+				executed = append(executed, step.Executed)
+			}
+			if want := []bool{true, true, true, true, false, true, false, true, false, true, true, false}; !reflect.DeepEqual(executed, want) {
+				t.Errorf("got executed steps %v, want %v", executed, want)
+			}
+			for idx, step := range res.Steps {
+				if (step.Err != nil) != (idx == 2) {
+					t.Errorf("got error %v in step %d", step.Err, idx)
+				}
+			}
+			var stepErr *Error
+			if !errors.As(res.Steps[2].Err, &stepErr) || stepErr.StmtIndex != 2 || !IsConstraintViolation(stepErr) {
+				t.Errorf("got %#v, want a constraint violation in step 2", res.Steps[2].Err)
+			}
+			if step := res.Steps[1]; step.RowsAffected != 1 || step.LastInsertId != 1 {
+				t.Errorf("got %d affected rows and last insert id %d from the insert", step.RowsAffected, step.LastInsertId)
+			}
+			if step := res.Steps[3]; step.RowsAffected != 1 {
+				t.Errorf("got %d affected rows from the update", step.RowsAffected)
+			}
+			if step := res.Steps[5]; !reflect.DeepEqual(step.Columns, []string{"id", "name"}) || !reflect.DeepEqual(step.Rows, [][]driver.Value{{int64(1), "b"}}) {
+				t.Errorf("got columns %q and rows %v from the select", step.Columns, step.Rows)
+			}
+			for idx, want := range map[int]string{7: "and", 9: "or", 10: "autocommit"} {
+				if rows := res.Steps[idx].Rows; len(rows) != 1 || rows[0][0] != want {
+					t.Errorf("got rows %v in step %d, want %q", rows, idx, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExecuteBatchInvalid(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		batch func(b *Batch)
+		err   string
+	}{
+		{"empty and", func(b *Batch) { b.AddIf(BatchAnd(), "SELECT 1") }, "at least one condition"},
+		{"empty or", func(b *Batch) {
+			first := b.Add("SELECT 1")
+			b.AddIf(BatchAnd(first.Ok(), BatchOr().Not()), "SELECT 2")
+		}, "at least one condition"},
+		{"later step", func(b *Batch) { b.AddIf(BatchStep(0).Ok(), "SELECT 1") }, "not before it"},
+		{"multiple statements", func(b *Batch) { b.Add("SELECT 1; SELECT 2") }, "single statement"},
+	}
+	conn := openConn(t, newTestServer(t).URL)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b Batch
+			tt.batch(&b)
+			if _, err := ExecuteBatch(ctx, conn, &b); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+
+	local := openConn(t, "file:"+filepath.Join(t.TempDir(), "local.db"))
+	var b Batch
+	b.Add("SELECT 1")
+	if _, err := ExecuteBatch(ctx, local, &b); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("got %v, want batches to be unsupported on local databases", err)
+	}
+}
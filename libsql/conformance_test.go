@@ -0,0 +1,127 @@
+package libsql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type namedString string
+
+// TestConformance runs the same round trips over every transport, which must encode arguments and
+// decode results the same way.
+func TestConformance(t *testing.T) {
+	tm := time.Date(2024, 2, 3, 4, 5, 6, 7_000_000, time.UTC)
+	var nilInt *int64
+	tests := []struct {
+		name     string
+		declType string
+		opts     []Option
+		arg      any
+		wire     any
+		want     any
+	}{
+		{name: "null", declType: "INTEGER", arg: nil, wire: nil, want: nil},
+		{name: "nil pointer", declType: "INTEGER", arg: nilInt, wire: nil, want: nil},
+		{name: "int64", declType: "INTEGER", arg: int64(42), wire: int64(42), want: int64(42)},
+		{name: "int32", declType: "INTEGER", arg: int32(-7), wire: int64(-7), want: int64(-7)},
+		{name: "uint8", declType: "INTEGER", arg: uint8(200), wire: int64(200), want: int64(200)},
+		{name: "bool", declType: "BOOLEAN", arg: true, wire: int64(1), want: int64(1)},
+		{name: "float64", declType: "REAL", arg: 1.5, wire: 1.5, want: 1.5},
+		{name: "float32", declType: "REAL", arg: float32(0.25), wire: 0.25, want: 0.25},
+		{name: "string", declType: "TEXT", arg: "héllo", wire: "héllo", want: "héllo"},
+		{name: "named string", declType: "TEXT", arg: namedString("x"), wire: "x", want: "x"},
+		{name: "blob", declType: "BLOB", arg: []byte{0, 1, 255}, wire: []byte{0, 1, 255}, want: []byte{0, 1, 255}},
+		{name: "time in timestamp column", declType: "TIMESTAMP", arg: tm, wire: "2024-02-03 04:05:06.007+00:00", want: tm},
+		{name: "time in text column", declType: "TEXT", arg: tm, wire: "2024-02-03 04:05:06.007+00:00", want: "2024-02-03 04:05:06.007+00:00"},
+		{
+			name:     "time as unix millis",
+			declType: "TIMESTAMP",
+			opts:     []Option{WithTimeEncoding(TimeEncoding{Format: TimeFormatUnixMillis})},
+			arg:      tm,
+			wire:     int64(1706933106007),
+			want:     tm,
+		},
+	}
+
+	// The round trips go through a database, which checks that SQLite stores the arguments with the
+	// storage class they were sent with, and that values read from columns decode the same way.
+	srv := newTestServer(t)
+	transports := map[string]string{
+		"http": srv.URL,
+		"ws":   srv.WebSocketURL(),
+	}
+	ctx := context.Background()
+	for transport, url := range transports {
+		for idx, tt := range tests {
+			t.Run(transport+"/"+tt.name, func(t *testing.T) {
+				connector, err := NewConnector(url, tt.opts...)
+				if err != nil {
+					t.Fatalf("NewConnector() error = %v", err)
+				}
+				db := sql.OpenDB(connector)
+				defer db.Close()
+
+				table := fmt.Sprintf("conformance_%s_%d", transport, idx)
+				if _, err := db.ExecContext(ctx, "CREATE TABLE "+table+" (v "+tt.declType+")"); err != nil {
+					t.Fatal(err)
+				}
+				srv.Requests()
+				insert := "INSERT INTO " + table + " VALUES (?)"
+				if _, err := db.ExecContext(ctx, insert, tt.arg); err != nil {
+					t.Fatal(err)
+				}
+				var wire []any
+				for _, req := range srv.Requests() {
+					if req.SQL == insert {
+						wire = req.Args
+					}
+				}
+				if len(wire) != 1 || !reflect.DeepEqual(wire[0], tt.wire) {
+					t.Errorf("sent %#v, want %#v", wire, tt.wire)
+				}
+				var storage string
+				if err := db.QueryRowContext(ctx, "SELECT typeof(v) FROM "+table).Scan(&storage); err != nil {
+					t.Fatalf("Scan() error = %v", err)
+				}
+				if want := storageClass(tt.wire); storage != want {
+					t.Errorf("stored %s, want %s", storage, want)
+				}
+				var got any
+				if err := db.QueryRowContext(ctx, "SELECT v FROM "+table).Scan(&got); err != nil {
+					t.Fatalf("Scan() error = %v", err)
+				}
+				checkConformanceValue(t, got, tt.want)
+			})
+		}
+	}
+}
+
+// storageClass returns the name typeof() gives to the storage class of a value sent as wire.
+func storageClass(wire any) string {
+	switch wire.(type) {
+	case int64:
+		return "integer"
+	case float64:
+		return "real"
+	case string:
+		return "text"
+	case []byte:
+		return "blob"
+	}
+	return "null"
+}
+
+func checkConformanceValue(t *testing.T, got any, want any) {
+	t.Helper()
+	if gotTime, ok := got.(time.Time); ok {
+		if wantTime, ok := want.(time.Time); !ok || !gotTime.Equal(wantTime) {
+			t.Errorf("got %#v, want %#v", got, want)
+		}
+	} else if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
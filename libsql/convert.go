@@ -0,0 +1,10 @@
+package libsql
+
+import (
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
+)
+
+// ValueConverter converts arguments of a type that the driver doesn't support into nil, int64,
+// float64, bool, []byte, string or time.Time. It returns driver.ErrSkip for values it doesn't
+// handle. Register converters with WithValueConverter.
+type ValueConverter = shared.ValueConverter
@@ -0,0 +1,71 @@
+package libsql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/hrana"
+)
+
+// Description is what the server reports about a statement without executing it.
+type Description struct {
+	// Params holds the names of the parameters including their prefix, such as ":name". Positional
+	// parameters have an empty name.
+	Params []string
+	// Columns holds the result columns, which is empty for statements that return no rows.
+	Columns []DescribedColumn
+	// IsExplain is true for EXPLAIN statements.
+	IsExplain bool
+	// IsReadOnly is true if the statement doesn't write to the database.
+	IsReadOnly bool
+}
+
+// DescribedColumn is a result column of a described statement.
+type DescribedColumn struct {
+	Name string
+	// DeclType is the declared type of the column, or empty if the column is an expression.
+	DeclType string
+}
+
+type describer interface {
+	Describe(ctx context.Context, query string) (*hrana.DescribeResult, error)
+}
+
+// Describe compiles query on the server and reports its parameters and result columns without
+// executing it. It fails if query doesn't compile against the current schema, which makes it
+// useful to check queries in tests. Describe is only supported on remote databases.
+func Describe(ctx context.Context, conn *sql.Conn, query string) (*Description, error) {
+	var res *hrana.DescribeResult
+	err := conn.Raw(func(driverConn any) error {
+		d, ok := driverConn.(describer)
+		if !ok {
+			return fmt.Errorf("describe is not supported by %T", driverConn)
+		}
+		var err error
+		res, err = d.Describe(ctx, query)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	desc := &Description{
+		Params:     make([]string, len(res.Params)),
+		Columns:    make([]DescribedColumn, len(res.Cols)),
+		IsExplain:  res.IsExplain,
+		IsReadOnly: res.IsReadonly,
+	}
+	for idx, param := range res.Params {
+		if param.Name != nil {
+			desc.Params[idx] = *param.Name
+		}
+	}
+	for idx, col := range res.Cols {
+		desc.Columns[idx].Name = col.Name
+		if col.Decltype != nil {
+			desc.Columns[idx].DeclType = *col.Decltype
+		}
+	}
+	return desc, nil
+}
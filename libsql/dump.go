@@ -0,0 +1,352 @@
+package libsql
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Dump writes the schema and the contents of the database that conn is connected to as an SQL
+// script, which Restore executes to recreate the database. The database is read in a single
+// read-only transaction, so the dump is consistent even if other connections write to it meanwhile.
+//
+// Tables are created in an order in which every table comes after the tables its foreign keys
+// refer to, and filled right after they are created. Indexes, views and triggers follow once all
+// tables are filled, so that triggers don't fire while the data is inserted. Internal tables other
+// than sqlite_sequence are skipped, and so are the shadow tables of virtual tables, whose contents
+// are inserted into the virtual tables instead.
+func Dump(ctx context.Context, conn *sql.Conn, w io.Writer) error {
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to begin dump transaction:\n%w", err)
+	}
+	// The transaction only reads, so rolling it back loses nothing.
+	defer tx.Rollback()
+
+	objects, err := dumpObjects(ctx, tx)
+	if err != nil {
+		return err
+	}
+	tables, err := orderTables(ctx, tx, objects)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(w)
+	out.WriteString("PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n")
+	for _, table := range tables {
+		fmt.Fprintf(out, "%s;\n", table.sql)
+		if err := dumpRows(ctx, tx, out, table.name); err != nil {
+			return err
+		}
+	}
+	for _, object := range objects {
+		if object.typ == "table" && object.name == "sqlite_sequence" {
+			out.WriteString("DELETE FROM sqlite_sequence;\n")
+			if err := dumpRows(ctx, tx, out, object.name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, object := range objects {
+		if object.typ != "table" {
+			fmt.Fprintf(out, "%s;\n", object.sql)
+		}
+	}
+	out.WriteString("COMMIT;\n")
+	return out.Flush()
+}
+
+// Restore executes a script written by Dump on conn. It is ExecScript with the default options, so
+// the script is streamed from r and executed in a single transaction. Foreign keys are not enforced
+// while the script runs, since tables that refer to each other can't be filled in any order that
+// satisfies them, and are enforced again afterwards if they were before.
+func Restore(ctx context.Context, conn *sql.Conn, r io.Reader) error {
+	// The PRAGMA foreign_keys=OFF at the start of the dump has no effect inside the transaction of
+	// ExecScript, so foreign keys are turned off before it begins.
+	var foreignKeys int
+	if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+		return fmt.Errorf("failed to read foreign keys setting:\n%w", err)
+	}
+	if foreignKeys == 0 {
+		return ExecScript(ctx, conn, r, nil)
+	}
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys=OFF"); err != nil {
+		return fmt.Errorf("failed to turn off foreign keys:\n%w", err)
+	}
+	err := ExecScript(ctx, conn, r, nil)
+	// Foreign keys must be turned on again even if ctx is the reason of the failure.
+	if _, fkErr := conn.ExecContext(context.Background(), "PRAGMA foreign_keys=ON"); fkErr != nil {
+		return errors.Join(err, fmt.Errorf("failed to turn on foreign keys:\n%w", fkErr))
+	}
+	return err
+}
+
+// schemaObject is an entry of sqlite_master.
+type schemaObject struct {
+	typ  string
+	name string
+	sql  string
+}
+
+func dumpObjects(ctx context.Context, tx *sql.Tx) ([]schemaObject, error) {
+	shadowTables, err := dumpShadowTables(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT type, name, sql FROM sqlite_master WHERE sql IS NOT NULL ORDER BY rowid")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema:\n%w", err)
+	}
+	defer rows.Close()
+	var objects []schemaObject
+	for rows.Next() {
+		var object schemaObject
+		if err := rows.Scan(&object.typ, &object.name, &object.sql); err != nil {
+			return nil, fmt.Errorf("failed to read schema:\n%w", err)
+		}
+		if isInternalObject(object.name) && object.name != "sqlite_sequence" {
+			continue
+		}
+		if object.typ == "table" && shadowTables[object.name] {
+			continue
+		}
+		objects = append(objects, object)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read schema:\n%w", err)
+	}
+	return objects, nil
+}
+
+func isInternalObject(name string) bool {
+	return strings.HasPrefix(strings.ToLower(name), "sqlite_")
+}
+
+// dumpShadowTables returns the names of the tables in which virtual tables store their contents.
+func dumpShadowTables(ctx context.Context, tx *sql.Tx) (map[string]bool, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT name FROM pragma_table_list WHERE schema = 'main' AND type = 'shadow'")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shadow tables:\n%w", err)
+	}
+	defer rows.Close()
+	res := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to read shadow tables:\n%w", err)
+		}
+		res[name] = true
+	}
+	return res, rows.Err()
+}
+
+// orderTables returns the tables among objects, ordered so that every table comes after the tables
+// its foreign keys refer to. Tables that refer to each other keep their order in the schema.
+func orderTables(ctx context.Context, tx *sql.Tx, objects []schemaObject) ([]schemaObject, error) {
+	var tables []schemaObject
+	byName := make(map[string]int)
+	for _, object := range objects {
+		if object.typ == "table" && !isInternalObject(object.name) {
+			byName[strings.ToLower(object.name)] = len(tables)
+			tables = append(tables, object)
+		}
+	}
+	references := make([][]int, len(tables))
+	for idx, table := range tables {
+		parents, err := foreignKeyTables(ctx, tx, table.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, parent := range parents {
+			if parentIdx, ok := byName[strings.ToLower(parent)]; ok && parentIdx != idx {
+				references[idx] = append(references[idx], parentIdx)
+			}
+		}
+	}
+
+	res := make([]schemaObject, 0, len(tables))
+	done := make([]bool, len(tables))
+	for len(res) < len(tables) {
+		progress := false
+		for idx := range tables {
+			if done[idx] || !allDone(references[idx], done) {
+				continue
+			}
+			done[idx] = true
+			res = append(res, tables[idx])
+			progress = true
+		}
+		if !progress {
+			// The remaining tables refer to each other, so no order satisfies all of them.
+			for idx := range tables {
+				if !done[idx] {
+					done[idx] = true
+					res = append(res, tables[idx])
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+func allDone(indexes []int, done []bool) bool {
+	for _, idx := range indexes {
+		if !done[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+func foreignKeyTables(ctx context.Context, tx *sql.Tx, table string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT "table" FROM pragma_foreign_key_list(?)`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read foreign keys of %s:\n%w", table, err)
+	}
+	defer rows.Close()
+	var parents []string
+	for rows.Next() {
+		var parent string
+		if err := rows.Scan(&parent); err != nil {
+			return nil, fmt.Errorf("failed to read foreign keys of %s:\n%w", table, err)
+		}
+		parents = append(parents, parent)
+	}
+	return parents, rows.Err()
+}
+
+// dumpRows writes an INSERT statement for every row of table. Generated columns are left out, and
+// the columns are only named in the statements if the table has any.
+func dumpRows(ctx context.Context, tx *sql.Tx, out *bufio.Writer, table string) error {
+	cols, hidden, err := tableColumns(ctx, tx, table)
+	if err != nil {
+		return err
+	}
+	if len(cols) == 0 {
+		return nil
+	}
+	// The unary plus drops the declared types of the columns, which keeps drivers from converting
+	// values, for example text in TIMESTAMP columns to time.Time.
+	selected := make([]string, len(cols))
+	quoted := make([]string, len(cols))
+	for idx, col := range cols {
+		quoted[idx] = quoteIdentifier(col)
+		selected[idx] = "+" + quoted[idx]
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT "+strings.Join(selected, ", ")+" FROM "+quoteIdentifier(table))
+	if err != nil {
+		return fmt.Errorf("failed to read table %s:\n%w", table, err)
+	}
+	defer rows.Close()
+
+	insert := "INSERT INTO " + quoteIdentifier(table)
+	if hidden {
+		insert += "(" + strings.Join(quoted, ",") + ")"
+	}
+	insert += " VALUES("
+	values := make([]any, len(cols))
+	dest := make([]any, len(cols))
+	for idx := range values {
+		dest[idx] = &values[idx]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return fmt.Errorf("failed to read table %s:\n%w", table, err)
+		}
+		out.WriteString(insert)
+		for idx, v := range values {
+			if idx > 0 {
+				out.WriteByte(',')
+			}
+			writeLiteral(out, v)
+		}
+		out.WriteString(");\n")
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read table %s:\n%w", table, err)
+	}
+	return nil
+}
+
+// tableColumns returns the columns of table that hold stored values and reports whether the table
+// has other, hidden or generated, columns.
+func tableColumns(ctx context.Context, tx *sql.Tx, table string) (cols []string, hidden bool, err error) {
+	rows, err := tx.QueryContext(ctx, "SELECT name, hidden FROM pragma_table_xinfo(?)", table)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read columns of %s:\n%w", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var hiddenKind int64
+		if err := rows.Scan(&name, &hiddenKind); err != nil {
+			return nil, false, fmt.Errorf("failed to read columns of %s:\n%w", table, err)
+		}
+		if hiddenKind != 0 {
+			hidden = true
+			continue
+		}
+		cols = append(cols, name)
+	}
+	return cols, hidden, rows.Err()
+}
+
+// writeLiteral writes v as an SQL literal that evaluates to the same value with the same type.
+func writeLiteral(out *bufio.Writer, v any) {
+	switch v := v.(type) {
+	case nil:
+		out.WriteString("NULL")
+	case int64:
+		out.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		out.WriteString(realLiteral(v))
+	case string:
+		out.WriteString(quoteString(v))
+	case []byte:
+		out.WriteString("X'")
+		out.WriteString(strings.ToUpper(hex.EncodeToString(v)))
+		out.WriteString("'")
+	case bool:
+		if v {
+			out.WriteString("1")
+		} else {
+			out.WriteString("0")
+		}
+	default:
+		out.WriteString(quoteString(fmt.Sprint(v)))
+	}
+}
+
+// realLiteral formats f so that SQLite reads it back as the same REAL. Infinities are written as
+// numbers that overflow to them, because SQL has no literal for them.
+func realLiteral(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NULL"
+	case math.IsInf(f, 1):
+		return "1e999"
+	case math.IsInf(f, -1):
+		return "-1e999"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,205 @@
+package libsql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/libsqltest"
+)
+
+// newTestServer starts a libsqltest server that is backed by a new database.
+func newTestServer(t *testing.T) *libsqltest.Server {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "server.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	srv := libsqltest.NewServer(db)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testURLs returns the URLs of a database served over HTTP and WebSockets, and of a local database,
+// by the name of their transport. The http and ws URLs refer to the same database.
+func testURLs(t *testing.T) map[string]string {
+	srv := newTestServer(t)
+	return map[string]string{
+		"http": srv.URL,
+		"ws":   srv.WebSocketURL(),
+		"file": "file:" + filepath.Join(t.TempDir(), "local.db"),
+	}
+}
+
+func openConn(t *testing.T, url string) *sql.Conn {
+	connector, err := NewConnector(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	t.Cleanup(func() { db.Close() })
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestDumpRestore(t *testing.T) {
+	ctx := context.Background()
+	srv := newTestServer(t)
+	source := openConn(t, srv.URL)
+	schema := []string{
+		// child refers to parent, which is created after it.
+		"CREATE TABLE child (id INTEGER PRIMARY KEY AUTOINCREMENT, parent_id INTEGER REFERENCES parent(id), at TIMESTAMP)",
+		"CREATE TABLE parent (id INTEGER PRIMARY KEY, name TEXT, score REAL, data BLOB, flag BOOLEAN)",
+		"CREATE TABLE \"odd \"\"name\"\"\" (a, b AS (a * 2))",
+		// docs_meta is a normal table, although its name looks like one of the shadow tables of docs.
+		"CREATE VIRTUAL TABLE docs USING fts5(body)",
+		"CREATE TABLE docs_meta (doc_id INTEGER, author TEXT)",
+		"CREATE INDEX child_parent ON child (parent_id)",
+		"CREATE VIEW named AS SELECT name FROM parent",
+		"CREATE TRIGGER parent_deleted AFTER DELETE ON parent BEGIN DELETE FROM child WHERE parent_id = old.id; END",
+	}
+	for _, stmt := range schema {
+		if _, err := source.ExecContext(ctx, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inserts := []struct {
+		query string
+		args  []any
+	}{
+		{"INSERT INTO parent VALUES (?, ?, ?, ?, ?)", []any{1, "it's\na test", 0.1, []byte{0, 1, 0xff}, 1}},
+		{"INSERT INTO parent VALUES (?, ?, ?, ?, ?)", []any{2, "", 3.0, []byte{}, 0}},
+		{"INSERT INTO parent VALUES (?, ?, ?, ?, ?)", []any{3, nil, -12.5, nil, nil}},
+		{"INSERT INTO parent VALUES (?, ?, ?, ?, ?)", []any{4, "12", 1e300, "text in a blob column", 2.5}},
+		{"INSERT INTO child (parent_id, at) VALUES (?, ?)", []any{1, "2024-01-02 03:04:05"}},
+		{"INSERT INTO child (parent_id, at) VALUES (?, ?)", []any{2, 1700000000}},
+		{"INSERT INTO \"odd \"\"name\"\"\" (a) VALUES (?)", []any{21}},
+		{"INSERT INTO docs VALUES (?)", []any{"full text"}},
+		{"INSERT INTO docs_meta VALUES (?, ?)", []any{1, "someone"}},
+	}
+	for _, insert := range inserts {
+		if _, err := source.ExecContext(ctx, insert.query, insert.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv.Requests()
+	var dump bytes.Buffer
+	if err := Dump(ctx, source, &dump); err != nil {
+		t.Fatal(err)
+	}
+	if requests := srv.Requests(); len(requests) == 0 || requests[0].String() != "execute BEGIN TRANSACTION READONLY" {
+		t.Errorf("got requests %q, want the dump to begin a read-only transaction", requests)
+	}
+	text := dump.String()
+	for _, want := range []string{
+		"INSERT INTO \"parent\" VALUES(1,'it''s\na test',0.1,X'0001FF',1);\n",
+		"INSERT INTO \"parent\" VALUES(2,'',3.0,X'',0);\n",
+		"INSERT INTO \"parent\" VALUES(3,NULL,-12.5,NULL,NULL);\n",
+		"INSERT INTO \"parent\" VALUES(4,'12',1e+300,'text in a blob column',2.5);\n",
+		"INSERT INTO \"child\" VALUES(1,1,'2024-01-02 03:04:05');\n",
+		"INSERT INTO \"child\" VALUES(2,2,1700000000);\n",
+		"INSERT INTO \"odd \"\"name\"\"\"(\"a\") VALUES(21);\n",
+		"INSERT INTO \"docs\"(\"body\") VALUES('full text');\n",
+		"INSERT INTO \"docs_meta\" VALUES(1,'someone');\n",
+		"DELETE FROM sqlite_sequence;\nINSERT INTO \"sqlite_sequence\" VALUES('child',2);\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("dump doesn't contain %q:\n%s", want, text)
+		}
+	}
+	if strings.Index(text, "CREATE TABLE parent") > strings.Index(text, "CREATE TABLE child") {
+		t.Errorf("parent is created after the table that refers to it:\n%s", text)
+	}
+	if strings.Contains(text, "docs_data") || strings.Contains(text, "docs_config") {
+		t.Errorf("dump contains the shadow tables of docs:\n%s", text)
+	}
+	if strings.Index(text, "CREATE TRIGGER") < strings.LastIndex(text, "INSERT INTO") {
+		t.Errorf("trigger is created before the data is inserted:\n%s", text)
+	}
+
+	target := openConn(t, "file:"+filepath.Join(t.TempDir(), "restored.db"))
+	if err := Restore(ctx, target, strings.NewReader(text)); err != nil {
+		t.Fatal(err)
+	}
+	var again bytes.Buffer
+	if err := Dump(ctx, target, &again); err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != text {
+		t.Errorf("dump of the restored database differs:\n%s\nwant\n%s", again.String(), text)
+	}
+}
+
+func TestRestoreForeignKeys(t *testing.T) {
+	ctx := context.Background()
+	source := openConn(t, "file:"+filepath.Join(t.TempDir(), "source.db"))
+	for _, stmt := range []string{
+		// a and b refer to each other and node refers to itself, so no order of the inserts
+		// satisfies the foreign keys.
+		"CREATE TABLE a (id INTEGER PRIMARY KEY, b_id INTEGER REFERENCES b (id))",
+		"CREATE TABLE b (id INTEGER PRIMARY KEY, a_id INTEGER REFERENCES a (id))",
+		"CREATE TABLE node (id INTEGER PRIMARY KEY, next_id INTEGER REFERENCES node (id))",
+		"INSERT INTO a VALUES (1, 1)",
+		"INSERT INTO b VALUES (1, 1)",
+		"INSERT INTO node VALUES (1, 2), (2, 1)",
+	} {
+		if _, err := source.ExecContext(ctx, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var dump bytes.Buffer
+	if err := Dump(ctx, source, &dump); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"http", "ws", "file"} {
+		t.Run(name, func(t *testing.T) {
+			// The tables have the same names for every transport, so each gets a database of its own.
+			target := openConn(t, testURLs(t)[name])
+			if _, err := target.ExecContext(ctx, "PRAGMA foreign_keys=ON"); err != nil {
+				t.Fatal(err)
+			}
+			if err := Restore(ctx, target, bytes.NewReader(dump.Bytes())); err != nil {
+				t.Fatal(err)
+			}
+			var rows, foreignKeys int
+			if err := target.QueryRowContext(ctx, "SELECT (SELECT count(*) FROM a) + (SELECT count(*) FROM b) + (SELECT count(*) FROM node)").Scan(&rows); err != nil || rows != 4 {
+				t.Errorf("got %d rows, %v, want 4", rows, err)
+			}
+			if err := target.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys); err != nil || foreignKeys != 1 {
+				t.Errorf("got foreign_keys=%d, %v, want them to be enforced again", foreignKeys, err)
+			}
+		})
+	}
+}
+
+func TestRealLiteral(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{0, "0.0"},
+		{-2, "-2.0"},
+		{0.1, "0.1"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "1e999"},
+		{math.Inf(-1), "-1e999"},
+		{math.NaN(), "NULL"},
+	}
+	for _, tt := range tests {
+		if got := realLiteral(tt.value); got != tt.want {
+			t.Errorf("realLiteral(%v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package libsql
+
+import (
+	"errors"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/hrana"
+)
+
+// Error is an error reported by the server. It carries the Hrana error code, the HTTP status, the
+// index of the failing statement in a batch and the SQLite result codes when they are known. Use
+// errors.As to get it from an error returned by database/sql.
+type Error = hrana.ServerError
+
+// IsConstraintViolation reports whether err was caused by a violated constraint, such as UNIQUE or
+// NOT NULL.
+func IsConstraintViolation(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.IsConstraintViolation()
+}
+
+// IsBusy reports whether err was caused by the database being locked by another connection.
+func IsBusy(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.IsBusy()
+}
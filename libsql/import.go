@@ -0,0 +1,508 @@
+package libsql
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/hrana"
+)
+
+const defaultImportBatchSize = 1000
+
+// ImportFormat is the format of the input of Import.
+type ImportFormat int
+
+const (
+	// ImportCSV is comma-separated values as described in RFC 4180.
+	ImportCSV ImportFormat = iota
+	// ImportJSONLines is one JSON object per line. The keys of the objects name the columns.
+	ImportJSONLines
+)
+
+// ImportOptions configures Import.
+type ImportOptions struct {
+	// Format is the format of the input. It defaults to ImportCSV.
+	Format ImportFormat
+	// Comma is the field delimiter of CSV input. It defaults to ','.
+	Comma rune
+	// Columns names the fields of the input. For CSV the fields are named by their position, and if
+	// Columns is nil, the first record is a header that names them. For JSON Lines only the keys in
+	// Columns are imported, and if Columns is nil, the keys of the first object are.
+	Columns []string
+	// Rename maps the names of fields to the columns of the table they are inserted into. Fields
+	// mapped to "" are not imported. Other fields are inserted into the column of the same name.
+	Rename map[string]string
+	// CreateTable creates the table if it doesn't exist yet. The affinities of its columns are
+	// inferred from the values in the first batch.
+	CreateTable bool
+	// BatchSize is the number of rows inserted in a single transaction, which remote databases
+	// execute in a single round trip. It defaults to 1000.
+	BatchSize int
+	// SkipRows is the number of rows at the start of the input that are not imported. Pass the
+	// rows reported by the last progress of a failed import to resume it.
+	SkipRows int64
+	// Progress is called after every batch of rows is committed.
+	Progress func(ImportProgress)
+}
+
+// ImportProgress tells how far Import got.
+type ImportProgress struct {
+	// Rows is the number of rows of the input that were committed so far, including skipped rows.
+	Rows int64
+}
+
+// ImportError reports a row of the input that Import failed to read or insert.
+type ImportError struct {
+	// Row is the number of the row in the input, counting from 1. A CSV header is not counted.
+	Row int64
+	Err error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("failed to import row %d:\n%s", e.Row, e.Err)
+}
+
+func (e *ImportError) Unwrap() error {
+	return e.Err
+}
+
+// Import inserts the rows read from r into table. The rows are inserted in batches of
+// opts.BatchSize, each in its own transaction, so a failure only rolls back the batch of the row
+// that failed. The error is an *ImportError if a row couldn't be read or inserted, and the rows
+// before its batch stay committed. opts can be nil.
+//
+// Empty CSV fields are inserted as NULL, and other fields as text, which the affinity of the column
+// converts. Values in JSON Lines keep their JSON type; booleans are inserted as integers, and
+// objects and arrays as JSON text.
+func Import(ctx context.Context, conn *sql.Conn, table string, r io.Reader, opts *ImportOptions) error {
+	var options ImportOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.BatchSize < 0 {
+		return fmt.Errorf("batch size must not be negative")
+	}
+	if options.BatchSize == 0 {
+		options.BatchSize = defaultImportBatchSize
+	}
+	if options.SkipRows < 0 {
+		return fmt.Errorf("skipped rows must not be negative")
+	}
+	reader, err := newImportReader(r, options)
+	if err != nil {
+		return err
+	}
+
+	var cols []string
+	var keep []int
+	for idx, name := range reader.columns() {
+		if target, ok := options.Rename[name]; ok {
+			if target == "" {
+				continue
+			}
+			name = target
+		}
+		cols = append(cols, name)
+		keep = append(keep, idx)
+	}
+	if len(cols) == 0 {
+		return fmt.Errorf("no columns to import into %s", table)
+	}
+	quoted := make([]string, len(cols))
+	for idx, col := range cols {
+		quoted[idx] = quoteIdentifier(col)
+	}
+	insert := "INSERT INTO " + quoteIdentifier(table) + " (" + strings.Join(quoted, ", ") + ") VALUES (?" + strings.Repeat(", ?", len(cols)-1) + ")"
+
+	var remote bool
+	err = conn.Raw(func(driverConn any) error {
+		_, remote = driverConn.(batchExecutor)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var progress ImportProgress
+	for ; progress.Rows < options.SkipRows; progress.Rows++ {
+		if _, err := reader.next(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return &ImportError{Row: progress.Rows + 1, Err: err}
+		}
+	}
+	created := !options.CreateTable
+	batch := make([][]any, 0, options.BatchSize)
+	for isEOF := false; !isEOF; {
+		batch = batch[:0]
+		for len(batch) < options.BatchSize {
+			record, err := reader.next()
+			if err == io.EOF {
+				isEOF = true
+				break
+			}
+			if err != nil {
+				return &ImportError{Row: progress.Rows + int64(len(batch)) + 1, Err: err}
+			}
+			row := make([]any, len(keep))
+			for idx, fieldIdx := range keep {
+				row[idx] = record[fieldIdx]
+			}
+			batch = append(batch, row)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		if !created {
+			if err := createImportTable(ctx, conn, table, quoted, batch, options.Format == ImportCSV); err != nil {
+				return err
+			}
+			created = true
+		}
+		if remote {
+			err = importBatchRemote(ctx, conn, insert, batch, progress.Rows+1)
+		} else {
+			err = importBatchLocal(ctx, conn, insert, batch, progress.Rows+1)
+		}
+		if err != nil {
+			return err
+		}
+		progress.Rows += int64(len(batch))
+		if options.Progress != nil {
+			options.Progress(progress)
+		}
+	}
+	return nil
+}
+
+// importBatchRemote inserts rows in a transaction that begins and ends in the same batch, so it
+// takes a single round trip. firstRow is the number of the first row in the input.
+func importBatchRemote(ctx context.Context, conn *sql.Conn, insert string, rows [][]any, firstRow int64) error {
+	stmts := make([]hrana.Stmt, len(rows))
+	for idx, row := range rows {
+		stmts[idx] = hrana.Stmt{Sql: &insert}
+		if err := stmts[idx].AddPositionalArgs(row); err != nil {
+			return &ImportError{Row: firstRow + int64(idx), Err: err}
+		}
+	}
+	batch := transactionBatch(stmts)
+
+	var res *hrana.BatchResult
+	err := conn.Raw(func(driverConn any) error {
+		var err error
+		res, err = driverConn.(batchExecutor).ExecuteBatch(ctx, batch)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := res.StepError(0); err != nil {
+		return fmt.Errorf("failed to begin import transaction:\n%w", err)
+	}
+	for idx := range rows {
+		if err := res.StepError(idx + 1); err != nil {
+			return &ImportError{Row: firstRow + int64(idx), Err: err}
+		}
+	}
+	if err := res.StepError(len(rows) + 1); err != nil {
+		return fmt.Errorf("failed to commit import transaction:\n%w", err)
+	}
+	return nil
+}
+
+func importBatchLocal(ctx context.Context, conn *sql.Conn, insert string, rows [][]any, firstRow int64) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin import transaction:\n%w", err)
+	}
+	stmt, err := tx.PrepareContext(ctx, insert)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for idx, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			tx.Rollback()
+			return &ImportError{Row: firstRow + int64(idx), Err: err}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit import transaction:\n%w", err)
+	}
+	return nil
+}
+
+// createImportTable creates table with the quoted columns unless it exists. The affinity of every
+// column is inferred from its values in rows. If parseText is set, text that looks like a number
+// counts as a number.
+func createImportTable(ctx context.Context, conn *sql.Conn, table string, quoted []string, rows [][]any, parseText bool) error {
+	defs := make([]string, len(quoted))
+	for idx, col := range quoted {
+		defs[idx] = col
+		if affinity := inferAffinity(rows, idx, parseText); affinity != "" {
+			defs[idx] += " " + affinity
+		}
+	}
+	stmt := "CREATE TABLE IF NOT EXISTS " + quoteIdentifier(table) + " (" + strings.Join(defs, ", ") + ")"
+	if _, err := conn.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("failed to create table %s:\n%w", table, err)
+	}
+	return nil
+}
+
+// inferAffinity returns the affinity that fits all values in column col of rows: INTEGER if they
+// are all integers, REAL if they are all numbers, TEXT otherwise. It returns "" if all of them are
+// NULL, which leaves the column without a type.
+func inferAffinity(rows [][]any, col int, parseText bool) string {
+	const (
+		none = iota
+		integer
+		real
+		text
+	)
+	kind := none
+	for _, row := range rows {
+		valueKind := text
+		switch v := row[col].(type) {
+		case nil:
+			continue
+		case int64, bool:
+			valueKind = integer
+		case float64:
+			valueKind = real
+		case string:
+			if !parseText {
+				break
+			}
+			if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+				valueKind = integer
+			} else if _, err := strconv.ParseFloat(v, 64); err == nil {
+				valueKind = real
+			}
+		}
+		if valueKind > kind {
+			kind = valueKind
+		}
+	}
+	switch kind {
+	case integer:
+		return "INTEGER"
+	case real:
+		return "REAL"
+	case text:
+		return "TEXT"
+	}
+	return ""
+}
+
+// importReader reads the records of the input of Import.
+type importReader interface {
+	// columns returns the names of the fields of every record.
+	columns() []string
+	// next returns the next record, which has a value for every column, or io.EOF at the end of
+	// the input.
+	next() ([]any, error)
+}
+
+func newImportReader(r io.Reader, options ImportOptions) (importReader, error) {
+	switch options.Format {
+	case ImportCSV:
+		return newCSVImportReader(r, options)
+	case ImportJSONLines:
+		return newJSONLinesImportReader(r, options)
+	}
+	return nil, fmt.Errorf("unknown import format %d", options.Format)
+}
+
+type csvImportReader struct {
+	reader *csv.Reader
+	cols   []string
+}
+
+func newCSVImportReader(r io.Reader, options ImportOptions) (*csvImportReader, error) {
+	reader := csv.NewReader(r)
+	if options.Comma != 0 {
+		reader.Comma = options.Comma
+	}
+	cols := options.Columns
+	if cols == nil {
+		header, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV header:\n%w", err)
+		}
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+		cols = header
+	} else {
+		reader.FieldsPerRecord = len(cols)
+	}
+	return &csvImportReader{reader: reader, cols: cols}, nil
+}
+
+func (r *csvImportReader) columns() []string {
+	return r.cols
+}
+
+func (r *csvImportReader) next() ([]any, error) {
+	fields, err := r.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	record := make([]any, len(fields))
+	for idx, field := range fields {
+		if field != "" {
+			record[idx] = field
+		}
+	}
+	return record, nil
+}
+
+type jsonLinesImportReader struct {
+	reader *bufio.Reader
+	cols   []string
+	index  map[string]int
+	// ignored holds the keys that are not imported.
+	ignored map[string]bool
+	// first is the first object if it was read to find the columns.
+	first map[string]any
+}
+
+func newJSONLinesImportReader(r io.Reader, options ImportOptions) (*jsonLinesImportReader, error) {
+	res := &jsonLinesImportReader{reader: bufio.NewReader(r), cols: options.Columns, ignored: make(map[string]bool)}
+	for name, target := range options.Rename {
+		if target == "" {
+			res.ignored[name] = true
+		}
+	}
+	if res.cols == nil {
+		keys, values, err := res.readObject()
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, &ImportError{Row: 1, Err: err}
+		}
+		res.cols = keys
+		res.first = make(map[string]any, len(keys))
+		for idx, key := range keys {
+			res.first[key] = values[idx]
+		}
+	} else {
+		// Only the keys in Columns are imported.
+		res.ignored = nil
+	}
+	res.index = make(map[string]int, len(res.cols))
+	for idx, col := range res.cols {
+		res.index[col] = idx
+	}
+	return res, nil
+}
+
+func (r *jsonLinesImportReader) columns() []string {
+	return r.cols
+}
+
+func (r *jsonLinesImportReader) next() ([]any, error) {
+	record := make([]any, len(r.cols))
+	if r.first != nil {
+		for idx, col := range r.cols {
+			record[idx] = r.first[col]
+		}
+		r.first = nil
+		return record, nil
+	}
+	keys, values, err := r.readObject()
+	if err != nil {
+		return nil, err
+	}
+	for idx, key := range keys {
+		colIdx, ok := r.index[key]
+		if !ok {
+			if r.ignored != nil && !r.ignored[key] {
+				return nil, fmt.Errorf("unknown key %q", key)
+			}
+			continue
+		}
+		record[colIdx] = values[idx]
+	}
+	return record, nil
+}
+
+// readObject reads the next line that isn't blank and decodes it as a JSON object. The keys are
+// returned in the order they appear in.
+func (r *jsonLinesImportReader) readObject() ([]string, []any, error) {
+	var line []byte
+	for len(line) == 0 {
+		var err error
+		line, err = r.reader.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return nil, nil, err
+		}
+		line = bytes.TrimSpace(line)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	if token, err := decoder.Token(); err != nil {
+		return nil, nil, err
+	} else if token != json.Delim('{') {
+		return nil, nil, fmt.Errorf("expected a JSON object, got %s", line)
+	}
+	var keys []string
+	var values []any
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, nil, err
+		}
+		value, err := jsonImportValue(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, token.(string))
+		values = append(values, value)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, nil, errors.New("unexpected data after JSON object")
+	}
+	return keys, values, nil
+}
+
+// jsonImportValue converts raw to the value that is inserted for it.
+func jsonImportValue(raw json.RawMessage) (any, error) {
+	if raw[0] == '{' || raw[0] == '[' {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, raw); err != nil {
+			return nil, err
+		}
+		return compact.String(), nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if number, ok := value.(json.Number); ok {
+		if integer, err := number.Int64(); err == nil {
+			return integer, nil
+		}
+		return number.Float64()
+	}
+	return value, nil
+}
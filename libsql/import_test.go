@@ -0,0 +1,129 @@
+package libsql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	for name, url := range testURLs(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			conn := openConn(t, url)
+			query := func(query string) string {
+				rows, err := conn.QueryContext(ctx, query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer rows.Close()
+				var res []string
+				for rows.Next() {
+					var row string
+					if err := rows.Scan(&row); err != nil {
+						t.Fatal(err)
+					}
+					res = append(res, row)
+				}
+				return strings.Join(res, "\n")
+			}
+
+			csvTable := "csv_" + name
+			input := "id,name,score,unused\n1,\"a, b\",1.5,x\n2,,2,y\n3,c,,z\n"
+			var progress []ImportProgress
+			err := Import(ctx, conn, csvTable, strings.NewReader(input), &ImportOptions{
+				Rename:      map[string]string{"unused": "", "name": "label"},
+				CreateTable: true,
+				BatchSize:   2,
+				Progress:    func(p ImportProgress) { progress = append(progress, p) },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(progress, []ImportProgress{{Rows: 2}, {Rows: 3}}) {
+				t.Errorf("got progress %+v", progress)
+			}
+			if got, want := query("SELECT sql FROM sqlite_master WHERE name = '"+csvTable+"'"), `CREATE TABLE "`+csvTable+`" ("id" INTEGER, "label" TEXT, "score" REAL)`; got != want {
+				t.Errorf("got schema %q, want %q", got, want)
+			}
+			if got, want := query("SELECT id || typeof(id) || ':' || quote(label) || ':' || quote(score) FROM "+csvTable+" ORDER BY id"), "1integer:'a, b':1.5\n2integer:NULL:2.0\n3integer:'c':NULL"; got != want {
+				t.Errorf("got rows\n%s\nwant\n%s", got, want)
+			}
+
+			jsonTable := "json_" + name
+			if _, err := conn.ExecContext(ctx, "CREATE TABLE "+jsonTable+" (id INTEGER PRIMARY KEY, doc, flag)"); err != nil {
+				t.Fatal(err)
+			}
+			var lines strings.Builder
+			for i := 1; i <= 7; i++ {
+				id := i
+				if i == 6 {
+					// Duplicates the first row.
+					id = 1
+				}
+				fmt.Fprintf(&lines, "{\"id\": %d, \"doc\": {\"n\": [%d, 1.5]}, \"ok\": %v}\n\n", id, i, i%2 == 0)
+			}
+			err = Import(ctx, conn, jsonTable, strings.NewReader(lines.String()), &ImportOptions{
+				Format:    ImportJSONLines,
+				Rename:    map[string]string{"ok": "flag"},
+				BatchSize: 2,
+				Progress:  func(p ImportProgress) { progress = append(progress, p) },
+			})
+			var importErr *ImportError
+			if !errors.As(err, &importErr) || importErr.Row != 6 || !strings.Contains(err.Error(), "UNIQUE") {
+				t.Fatalf("got %v, want row 6 to violate the primary key", err)
+			}
+			if got := progress[len(progress)-1].Rows; got != 4 {
+				t.Errorf("got %d committed rows, want 4", got)
+			}
+			if got, want := query("SELECT group_concat(id) FROM "+jsonTable), "1,2,3,4"; got != want {
+				t.Errorf("got ids %s, want %s", got, want)
+			}
+
+			// Resume the import after fixing the duplicate.
+			fixed := strings.Replace(lines.String(), `"id": 1, "doc": {"n": [6,`, `"id": 6, "doc": {"n": [6,`, 1)
+			err = Import(ctx, conn, jsonTable, strings.NewReader(fixed), &ImportOptions{
+				Format:   ImportJSONLines,
+				Rename:   map[string]string{"ok": "flag"},
+				SkipRows: 4,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := query("SELECT id || ':' || doc || ':' || flag FROM "+jsonTable+" WHERE id > 3"), "4:{\"n\":[4,1.5]}:1\n5:{\"n\":[5,1.5]}:0\n6:{\"n\":[6,1.5]}:1\n7:{\"n\":[7,1.5]}:0"; got != want {
+				t.Errorf("got rows\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestImportReadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		options ImportOptions
+		row     int64
+	}{
+		{"csv field count", "a,b\n1,2\n3\n", ImportOptions{}, 2},
+		{"csv columns", "1,2\n3,4,5\n", ImportOptions{Columns: []string{"a", "b"}}, 2},
+		{"json syntax", "{\"a\": 1}\n{\"a\": \n", ImportOptions{Format: ImportJSONLines}, 2},
+		{"json not an object", "[1]\n", ImportOptions{Format: ImportJSONLines}, 1},
+		{"json unknown key", "{\"a\": 1}\n{\"a\": 2}\n{\"b\": 3}\n", ImportOptions{Format: ImportJSONLines}, 3},
+	}
+	ctx := context.Background()
+	conn := openConn(t, "file:"+filepath.Join(t.TempDir(), "test.db"))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.options.CreateTable = true
+			err := Import(ctx, conn, "t", strings.NewReader(tt.input), &tt.options)
+			var importErr *ImportError
+			if !errors.As(err, &importErr) || importErr.Row != tt.row {
+				t.Errorf("got %v, want an error in row %d", err, tt.row)
+			}
+		})
+	}
+}
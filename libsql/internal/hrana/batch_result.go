@@ -12,6 +12,16 @@ type BatchResult struct {
 	ReplicationIndex *uint64       `json:"replication_index"`
 }
 
+// StepError returns the error of the step at idx, or nil if the step succeeded or was skipped.
+func (b *BatchResult) StepError(idx int) error {
+	if idx >= len(b.StepErrors) || b.StepErrors[idx] == nil {
+		return nil
+	}
+	err := NewServerError(b.StepErrors[idx])
+	err.StmtIndex = idx
+	return err
+}
+
 func (b *BatchResult) UnmarshalJSON(data []byte) error {
 	type Alias BatchResult
 	aux := &struct {
@@ -0,0 +1,143 @@
+package hrana
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
+)
+
+type affinity int
+
+const (
+	affinityNone affinity = iota
+	affinityInteger
+	affinityText
+	affinityReal
+	affinityNumeric
+)
+
+// declaredAffinity follows the rules SQLite uses to determine the affinity of a column from its
+// declared type.
+func declaredAffinity(declType string) affinity {
+	t := strings.ToUpper(declType)
+	switch {
+	case strings.Contains(t, "INT"):
+		return affinityInteger
+	case strings.Contains(t, "CHAR"), strings.Contains(t, "CLOB"), strings.Contains(t, "TEXT"):
+		return affinityText
+	case t == "", strings.Contains(t, "BLOB"):
+		return affinityNone
+	case strings.Contains(t, "REAL"), strings.Contains(t, "FLOA"), strings.Contains(t, "DOUB"):
+		return affinityReal
+	default:
+		return affinityNumeric
+	}
+}
+
+var (
+	int64Type   = reflect.TypeOf(int64(0))
+	float64Type = reflect.TypeOf(float64(0))
+	stringType  = reflect.TypeOf("")
+	bytesType   = reflect.TypeOf([]byte(nil))
+	timeType    = reflect.TypeOf(time.Time{})
+)
+
+// ColumnTypes describes the columns cols of a result set. Because SQLite columns can hold values of
+// any type, the scan type is taken from the values in rows when they all have the same type, and
+// from the affinity of the declared type otherwise. Columns that times reads as times are scanned as
+// time.Time. A column is only known to be nullable if one of rows holds NULL in it; otherwise its
+// nullability is reported as unknown.
+func ColumnTypes(cols []Column, rows [][]Value, times shared.TimeEncoding) []shared.ColumnType {
+	res := make([]shared.ColumnType, len(cols))
+	for idx, col := range cols {
+		var declType string
+		if col.Type != nil {
+			declType = *col.Type
+		}
+		valueType := ""
+		mixed := false
+		sawNull := false
+		for _, row := range rows {
+			if idx >= len(row) {
+				continue
+			}
+			switch t := row[idx].Type; {
+			case t == "null":
+				sawNull = true
+			case valueType == "":
+				valueType = t
+			case valueType != t:
+				mixed = true
+			}
+		}
+		if mixed {
+			valueType = ""
+		}
+
+		// SQLite doesn't report NOT NULL constraints, and rows without NULL don't prove that the
+		// column can't hold one.
+		ct := shared.ColumnType{DatabaseTypeName: strings.ToUpper(declType), Nullable: sawNull, NullableOk: sawNull}
+		ct.ScanType = scanType(declType, valueType, mixed, times)
+		if ct.ScanType == stringType || ct.ScanType == bytesType {
+			ct.Length, ct.LengthOk = declaredLength(declType), true
+		}
+		res[idx] = ct
+	}
+	return res
+}
+
+func scanType(declType string, valueType string, mixed bool, times shared.TimeEncoding) reflect.Type {
+	if times.IsTimeColumn(declType) && !mixed {
+		switch valueType {
+		case "", "text":
+			return timeType
+		case "integer", "float":
+			if times.DecodesNumbers() {
+				return timeType
+			}
+		}
+	}
+	switch valueType {
+	case "integer":
+		return int64Type
+	case "float":
+		return float64Type
+	case "text":
+		return stringType
+	case "blob":
+		return bytesType
+	}
+	if mixed {
+		return shared.AnyType
+	}
+	switch declaredAffinity(declType) {
+	case affinityInteger:
+		return int64Type
+	case affinityText:
+		return stringType
+	case affinityReal:
+		return float64Type
+	case affinityNone:
+		if declType != "" {
+			return bytesType
+		}
+	}
+	return shared.AnyType
+}
+
+// declaredLength returns the length declared like in VARCHAR(255). SQLite doesn't enforce it, so
+// columns without one are reported as unbounded.
+func declaredLength(declType string) int64 {
+	open := strings.IndexByte(declType, '(')
+	end := strings.IndexByte(declType, ')')
+	if open >= 0 && end > open {
+		if n, err := strconv.ParseInt(strings.TrimSpace(declType[open+1:end]), 10, 64); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return math.MaxInt64
+}
@@ -0,0 +1,65 @@
+package hrana
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
+)
+
+func TestColumnTypes(t *testing.T) {
+	decl := func(s string) *string { return &s }
+	cols := []Column{
+		{Type: decl("integer")},
+		{Type: decl("VARCHAR(255)")},
+		{Type: decl("BLOB")},
+		{Type: decl("NUMERIC")},
+		{Type: decl("datetime")},
+		{},
+		{},
+		{},
+	}
+	rows := [][]Value{
+		{{Type: "integer", Value: "1"}, {Type: "text", Value: "a"}, {Type: "null"}, {Type: "float", Value: 1.5}, {Type: "text", Value: "2024-01-01"}, {Type: "text", Value: "x"}, {Type: "integer", Value: "1"}, {Type: "null"}},
+		{{Type: "text", Value: "b"}, {Type: "null"}, {Type: "null"}, {Type: "float", Value: 2.5}, {Type: "null"}, {Type: "text", Value: "y"}, {Type: "float", Value: 1.5}, {Type: "null"}},
+	}
+	want := []shared.ColumnType{
+		{DatabaseTypeName: "INTEGER", ScanType: shared.AnyType},
+		{DatabaseTypeName: "VARCHAR(255)", ScanType: stringType, Nullable: true, NullableOk: true, Length: 255, LengthOk: true},
+		{DatabaseTypeName: "BLOB", ScanType: bytesType, Nullable: true, NullableOk: true, Length: math.MaxInt64, LengthOk: true},
+		{DatabaseTypeName: "NUMERIC", ScanType: float64Type},
+		{DatabaseTypeName: "DATETIME", ScanType: timeType, Nullable: true, NullableOk: true},
+		{ScanType: stringType, Length: math.MaxInt64, LengthOk: true},
+		{ScanType: shared.AnyType},
+		{ScanType: shared.AnyType, Nullable: true, NullableOk: true},
+	}
+	got := ColumnTypes(cols, rows, shared.TimeEncoding{})
+	for idx := range want {
+		if !reflect.DeepEqual(got[idx], want[idx]) {
+			t.Errorf("column %d: got %+v, want %+v", idx, got[idx], want[idx])
+		}
+	}
+}
+
+func TestColumnTypesWithoutRows(t *testing.T) {
+	tests := []struct {
+		declType string
+		want     reflect.Type
+	}{
+		{declType: "BIGINT", want: int64Type},
+		{declType: "TEXT", want: stringType},
+		{declType: "CLOB", want: stringType},
+		{declType: "DOUBLE PRECISION", want: float64Type},
+		{declType: "BLOB", want: bytesType},
+		{declType: "TIMESTAMP", want: timeType},
+		{declType: "DECIMAL(10,5)", want: shared.AnyType},
+		{declType: "", want: shared.AnyType},
+	}
+	for _, tt := range tests {
+		got := ColumnTypes([]Column{{Type: &tt.declType}}, nil, shared.TimeEncoding{})[0]
+		if got.ScanType != tt.want {
+			t.Errorf("scan type of %q = %v, want %v", tt.declType, got.ScanType, tt.want)
+		}
+	}
+}
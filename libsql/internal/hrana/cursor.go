@@ -0,0 +1,21 @@
+package hrana
+
+type CursorRequest struct {
+	Baton string `json:"baton,omitempty"`
+	Batch *Batch `json:"batch"`
+}
+
+type CursorResponse struct {
+	Baton   string `json:"baton,omitempty"`
+	BaseUrl string `json:"base_url,omitempty"`
+}
+
+type CursorEntry struct {
+	Type             string   `json:"type"`
+	Step             int32    `json:"step,omitempty"`
+	Cols             []Column `json:"cols,omitempty"`
+	Row              []Value  `json:"row,omitempty"`
+	AffectedRowCount int32    `json:"affected_row_count,omitempty"`
+	LastInsertRowId  *string  `json:"last_insert_rowid,omitempty"`
+	Error            *Error   `json:"error,omitempty"`
+}
@@ -0,0 +1,17 @@
+package hrana
+
+type DescribeResult struct {
+	Params     []DescribeParam `json:"params"`
+	Cols       []DescribeCol   `json:"cols"`
+	IsExplain  bool            `json:"is_explain"`
+	IsReadonly bool            `json:"is_readonly"`
+}
+
+type DescribeParam struct {
+	Name *string `json:"name"`
+}
+
+type DescribeCol struct {
+	Name     string  `json:"name"`
+	Decltype *string `json:"decltype"`
+}
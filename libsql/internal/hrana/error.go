@@ -0,0 +1,159 @@
+package hrana
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+// ServerError is an error reported by the server.
+type ServerError struct {
+	// Message is the error message sent by the server.
+	Message string
+	// Code is the Hrana error code, such as "SQLITE_CONSTRAINT" or "STREAM_EXPIRED". It is empty if
+	// the server didn't send one.
+	Code string
+	// HTTPStatus is the status of the HTTP response that carried the error. It is 0 if the error
+	// was sent in a successful response or over a WebSocket.
+	HTTPStatus int
+	// StmtIndex is the index of the failing statement in a batch, or -1 if the error doesn't belong
+	// to a statement of a batch.
+	StmtIndex int
+	// ResultCode is the SQLite primary result code, or 0 if the error doesn't come from SQLite.
+	ResultCode int
+	// ExtendedResultCode is the SQLite extended result code, or 0 if it is not known.
+	ExtendedResultCode int
+
+	// badConn is set when the connection can't be used anymore after the error.
+	badConn bool
+}
+
+// NewServerError converts an error received from the server.
+func NewServerError(e *Error) *ServerError {
+	if e == nil {
+		return &ServerError{Message: "unknown error", StmtIndex: -1}
+	}
+	err := &ServerError{Message: e.Message, StmtIndex: -1}
+	if e.Code != nil {
+		err.setCode(*e.Code)
+	}
+	return err
+}
+
+// NewHTTPError creates an error carried by an HTTP response with the given status.
+func NewHTTPError(status int, message string, code string) *ServerError {
+	err := &ServerError{Message: message, HTTPStatus: status, StmtIndex: -1}
+	err.setCode(code)
+	// An expired stream can't be used anymore, so database/sql should retry with a new connection.
+	err.badConn = code == "STREAM_EXPIRED"
+	return err
+}
+
+func (e *ServerError) setCode(code string) {
+	e.Code = code
+	if extended, ok := sqliteExtendedResultCodes[code]; ok {
+		e.ResultCode = extended & 0xff
+		e.ExtendedResultCode = extended
+		return
+	}
+	for name := code; strings.HasPrefix(name, "SQLITE_"); name = name[:strings.LastIndexByte(name, '_')] {
+		if primary, ok := sqliteResultCodes[name]; ok {
+			e.ResultCode = primary
+			if name == code {
+				e.ExtendedResultCode = primary
+			}
+			return
+		}
+	}
+}
+
+func (e *ServerError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("error code %s: %s", e.Code, e.Message)
+	}
+	if e.HTTPStatus != 0 {
+		return fmt.Sprintf("error code %d: %s", e.HTTPStatus, e.Message)
+	}
+	return e.Message
+}
+
+func (e *ServerError) Unwrap() error {
+	if e.badConn {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// IsConstraintViolation reports whether a constraint, such as UNIQUE or NOT NULL, was violated.
+func (e *ServerError) IsConstraintViolation() bool {
+	return e.ResultCode == sqliteConstraint
+}
+
+// IsBusy reports whether the database was locked by another connection.
+func (e *ServerError) IsBusy() bool {
+	return e.ResultCode == sqliteBusy
+}
+
+const (
+	sqliteBusy       = 5
+	sqliteConstraint = 19
+)
+
+var sqliteResultCodes = map[string]int{
+	"SQLITE_ERROR":      1,
+	"SQLITE_INTERNAL":   2,
+	"SQLITE_PERM":       3,
+	"SQLITE_ABORT":      4,
+	"SQLITE_BUSY":       sqliteBusy,
+	"SQLITE_LOCKED":     6,
+	"SQLITE_NOMEM":      7,
+	"SQLITE_READONLY":   8,
+	"SQLITE_INTERRUPT":  9,
+	"SQLITE_IOERR":      10,
+	"SQLITE_CORRUPT":    11,
+	"SQLITE_NOTFOUND":   12,
+	"SQLITE_FULL":       13,
+	"SQLITE_CANTOPEN":   14,
+	"SQLITE_PROTOCOL":   15,
+	"SQLITE_EMPTY":      16,
+	"SQLITE_SCHEMA":     17,
+	"SQLITE_TOOBIG":     18,
+	"SQLITE_CONSTRAINT": sqliteConstraint,
+	"SQLITE_MISMATCH":   20,
+	"SQLITE_MISUSE":     21,
+	"SQLITE_NOLFS":      22,
+	"SQLITE_AUTH":       23,
+	"SQLITE_FORMAT":     24,
+	"SQLITE_RANGE":      25,
+	"SQLITE_NOTADB":     26,
+}
+
+var sqliteExtendedResultCodes = map[string]int{
+	"SQLITE_ERROR_MISSING_COLLSEQ": 1 | 1<<8,
+	"SQLITE_ERROR_RETRY":           1 | 2<<8,
+	"SQLITE_ERROR_SNAPSHOT":        1 | 3<<8,
+	"SQLITE_ABORT_ROLLBACK":        4 | 2<<8,
+	"SQLITE_BUSY_RECOVERY":         sqliteBusy | 1<<8,
+	"SQLITE_BUSY_SNAPSHOT":         sqliteBusy | 2<<8,
+	"SQLITE_BUSY_TIMEOUT":          sqliteBusy | 3<<8,
+	"SQLITE_LOCKED_SHAREDCACHE":    6 | 1<<8,
+	"SQLITE_LOCKED_VTAB":           6 | 2<<8,
+	"SQLITE_READONLY_RECOVERY":     8 | 1<<8,
+	"SQLITE_READONLY_CANTLOCK":     8 | 2<<8,
+	"SQLITE_READONLY_ROLLBACK":     8 | 3<<8,
+	"SQLITE_READONLY_DBMOVED":      8 | 4<<8,
+	"SQLITE_READONLY_CANTINIT":     8 | 5<<8,
+	"SQLITE_READONLY_DIRECTORY":    8 | 6<<8,
+	"SQLITE_CONSTRAINT_CHECK":      sqliteConstraint | 1<<8,
+	"SQLITE_CONSTRAINT_COMMITHOOK": sqliteConstraint | 2<<8,
+	"SQLITE_CONSTRAINT_FOREIGNKEY": sqliteConstraint | 3<<8,
+	"SQLITE_CONSTRAINT_FUNCTION":   sqliteConstraint | 4<<8,
+	"SQLITE_CONSTRAINT_NOTNULL":    sqliteConstraint | 5<<8,
+	"SQLITE_CONSTRAINT_PRIMARYKEY": sqliteConstraint | 6<<8,
+	"SQLITE_CONSTRAINT_TRIGGER":    sqliteConstraint | 7<<8,
+	"SQLITE_CONSTRAINT_UNIQUE":     sqliteConstraint | 8<<8,
+	"SQLITE_CONSTRAINT_VTAB":       sqliteConstraint | 9<<8,
+	"SQLITE_CONSTRAINT_ROWID":      sqliteConstraint | 10<<8,
+	"SQLITE_CONSTRAINT_PINNED":     sqliteConstraint | 11<<8,
+	"SQLITE_CONSTRAINT_DATATYPE":   sqliteConstraint | 12<<8,
+}
@@ -0,0 +1,65 @@
+package hrana
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+func TestServerErrorCodes(t *testing.T) {
+	tests := []struct {
+		code         string
+		wantPrimary  int
+		wantExtended int
+		constraint   bool
+		busy         bool
+	}{
+		{code: "SQLITE_CONSTRAINT", wantPrimary: 19, wantExtended: 19, constraint: true},
+		{code: "SQLITE_CONSTRAINT_UNIQUE", wantPrimary: 19, wantExtended: 2067, constraint: true},
+		{code: "SQLITE_BUSY", wantPrimary: 5, wantExtended: 5, busy: true},
+		{code: "SQLITE_BUSY_TIMEOUT", wantPrimary: 5, wantExtended: 773, busy: true},
+		{code: "SQLITE_IOERR_SHORT_READ", wantPrimary: 10},
+		{code: "STREAM_EXPIRED"},
+		{code: "SQLITE_UNKNOWN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			err := NewServerError(&Error{Message: "boom", Code: &tt.code})
+			if err.ResultCode != tt.wantPrimary || err.ExtendedResultCode != tt.wantExtended {
+				t.Errorf("result codes = %d, %d, want %d, %d", err.ResultCode, err.ExtendedResultCode, tt.wantPrimary, tt.wantExtended)
+			}
+			if err.IsConstraintViolation() != tt.constraint || err.IsBusy() != tt.busy {
+				t.Errorf("IsConstraintViolation() = %v, IsBusy() = %v", err.IsConstraintViolation(), err.IsBusy())
+			}
+			if err.Error() != "error code "+tt.code+": boom" {
+				t.Errorf("Error() = %q", err.Error())
+			}
+		})
+	}
+}
+
+func TestHTTPError(t *testing.T) {
+	err := NewHTTPError(400, "stream expired", "STREAM_EXPIRED")
+	if !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("expired stream error is not driver.ErrBadConn")
+	}
+	err = NewHTTPError(500, "internal error", "")
+	if errors.Is(err, driver.ErrBadConn) || err.Error() != "error code 500: internal error" || err.StmtIndex != -1 {
+		t.Errorf("NewHTTPError() = %#v", err)
+	}
+}
+
+func TestBatchResultStepError(t *testing.T) {
+	resp := StreamResponse{
+		Type:   "batch",
+		Result: []byte(`{"step_results":[{"cols":[],"rows":[],"affected_row_count":1},null],"step_errors":[null,{"message":"UNIQUE constraint failed: t.id","code":"SQLITE_CONSTRAINT_PRIMARYKEY"}]}`),
+	}
+	_, err := resp.BatchResult()
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) {
+		t.Fatalf("BatchResult() error = %v, want a ServerError", err)
+	}
+	if serverErr.StmtIndex != 1 || !serverErr.IsConstraintViolation() || serverErr.Message != "UNIQUE constraint failed: t.id" {
+		t.Errorf("BatchResult() error = %#v", serverErr)
+	}
+}
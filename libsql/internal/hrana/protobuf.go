@@ -0,0 +1,896 @@
+package hrana
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// This file implements the protobuf encoding of Hrana 3 messages. The protocol only needs a small
+// subset of protobuf, so messages are encoded and decoded by hand on top of the wire format instead
+// of depending on generated code.
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+type protoWriter struct {
+	buf []byte
+}
+
+func (w *protoWriter) tag(field int, wireType int) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(field)<<3|uint64(wireType))
+}
+
+func (w *protoWriter) uint64(field int, v uint64) {
+	w.tag(field, wireVarint)
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *protoWriter) int32(field int, v int32) {
+	// Negative int32 values are sign extended to 64 bits on the wire.
+	w.uint64(field, uint64(int64(v)))
+}
+
+func (w *protoWriter) sint64(field int, v int64) {
+	w.tag(field, wireVarint)
+	w.buf = binary.AppendVarint(w.buf, v)
+}
+
+func (w *protoWriter) bool(field int, v bool) {
+	var i uint64
+	if v {
+		i = 1
+	}
+	w.uint64(field, i)
+}
+
+func (w *protoWriter) double(field int, v float64) {
+	w.tag(field, wireFixed64)
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, math.Float64bits(v))
+}
+
+func (w *protoWriter) bytes(field int, v []byte) {
+	w.tag(field, wireBytes)
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+func (w *protoWriter) string(field int, v string) {
+	w.tag(field, wireBytes)
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+func (w *protoWriter) message(field int, encode func(w *protoWriter) error) error {
+	inner := protoWriter{}
+	if err := encode(&inner); err != nil {
+		return err
+	}
+	w.bytes(field, inner.buf)
+	return nil
+}
+
+func (w *protoWriter) emptyMessage(field int) {
+	w.bytes(field, nil)
+}
+
+type protoReader struct {
+	buf []byte
+	pos int
+}
+
+var errProtoTruncated = errors.New("protobuf message is truncated")
+
+func (r *protoReader) done() bool {
+	return r.pos >= len(r.buf)
+}
+
+func (r *protoReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		return 0, errProtoTruncated
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *protoReader) next() (field int, wireType int, err error) {
+	tag, err := r.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(tag >> 3), int(tag & 7), nil
+}
+
+func (r *protoReader) sint64() (int64, error) {
+	v, err := r.varint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+func (r *protoReader) double() (float64, error) {
+	if len(r.buf)-r.pos < 8 {
+		return 0, errProtoTruncated
+	}
+	v := binary.LittleEndian.Uint64(r.buf[r.pos:])
+	r.pos += 8
+	return math.Float64frombits(v), nil
+}
+
+func (r *protoReader) bytes() ([]byte, error) {
+	n, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(r.buf)-r.pos) < n {
+		return nil, errProtoTruncated
+	}
+	v := r.buf[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return v, nil
+}
+
+func (r *protoReader) string() (string, error) {
+	v, err := r.bytes()
+	return string(v), err
+}
+
+func (r *protoReader) message() (*protoReader, error) {
+	v, err := r.bytes()
+	if err != nil {
+		return nil, err
+	}
+	return &protoReader{buf: v}, nil
+}
+
+func (r *protoReader) skip(wireType int) error {
+	var err error
+	switch wireType {
+	case wireVarint:
+		_, err = r.varint()
+	case wireFixed64:
+		_, err = r.double()
+	case wireBytes:
+		_, err = r.bytes()
+	case wireFixed32:
+		if len(r.buf)-r.pos < 4 {
+			return errProtoTruncated
+		}
+		r.pos += 4
+	default:
+		err = fmt.Errorf("unsupported protobuf wire type %d", wireType)
+	}
+	return err
+}
+
+// fields calls decode for each field of the message. Fields that decode does not handle must be
+// skipped by returning skipField.
+func (r *protoReader) fields(decode func(r *protoReader, field int, wireType int) error) error {
+	for !r.done() {
+		field, wireType, err := r.next()
+		if err != nil {
+			return err
+		}
+		err = decode(r, field, wireType)
+		if err == errSkipField {
+			err = r.skip(wireType)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var errSkipField = errors.New("skip field")
+
+// ReadProtoMessage reads a single length-delimited message from a stream of messages.
+func ReadProtoMessage(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (v Value) encodeProto(w *protoWriter) error {
+	switch v.Type {
+	case "null":
+		w.emptyMessage(1)
+	case "integer":
+		switch value := v.Value.(type) {
+		case int64:
+			w.sint64(2, value)
+		case string:
+			integer, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return err
+			}
+			w.sint64(2, integer)
+		default:
+			return fmt.Errorf("invalid integer value: %v", v.Value)
+		}
+	case "float":
+		float, ok := v.Value.(float64)
+		if !ok {
+			return fmt.Errorf("invalid float value: %v", v.Value)
+		}
+		w.double(3, float)
+	case "text":
+		text, ok := v.Value.(string)
+		if !ok {
+			return fmt.Errorf("invalid text value: %v", v.Value)
+		}
+		w.string(4, text)
+	case "blob":
+		blob, ok := v.Value.([]byte)
+		if !ok && v.Base64 != nil {
+			var err error
+			if blob, err = base64.StdEncoding.WithPadding(base64.NoPadding).DecodeString(*v.Base64); err != nil {
+				return err
+			}
+		}
+		w.bytes(5, blob)
+	default:
+		return fmt.Errorf("unsupported value type: %s", v.Type)
+	}
+	return nil
+}
+
+func (v *Value) decodeProto(r *protoReader) error {
+	v.Type = "null"
+	return r.fields(func(r *protoReader, field int, wireType int) (err error) {
+		switch field {
+		case 1:
+			v.Type, v.Value = "null", nil
+			return errSkipField
+		case 2:
+			v.Type = "integer"
+			v.Value, err = r.sint64()
+		case 3:
+			v.Type = "float"
+			v.Value, err = r.double()
+		case 4:
+			v.Type = "text"
+			v.Value, err = r.string()
+		case 5:
+			var blob []byte
+			if blob, err = r.bytes(); err == nil {
+				v.Type = "blob"
+				v.Value = append([]byte{}, blob...)
+			}
+		default:
+			return errSkipField
+		}
+		return err
+	})
+}
+
+func (s *Stmt) encodeProto(w *protoWriter) error {
+	if s.Sql != nil {
+		w.string(1, *s.Sql)
+	}
+	if s.SqlId != nil {
+		w.int32(2, *s.SqlId)
+	}
+	for _, arg := range s.Args {
+		if err := w.message(3, arg.encodeProto); err != nil {
+			return err
+		}
+	}
+	for _, arg := range s.NamedArgs {
+		arg := arg
+		err := w.message(4, func(w *protoWriter) error {
+			w.string(1, arg.Name)
+			return w.message(2, arg.Value.encodeProto)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.bool(5, s.WantRows)
+	if s.ReplicationIndex != nil {
+		w.uint64(6, *s.ReplicationIndex)
+	}
+	return nil
+}
+
+func (c *BatchCondition) encodeProto(w *protoWriter) error {
+	switch c.Type {
+	case "ok", "error":
+		if c.Step == nil {
+			return fmt.Errorf("batch condition %s requires a step", c.Type)
+		}
+		field := 1
+		if c.Type == "error" {
+			field = 2
+		}
+		w.uint64(field, uint64(*c.Step))
+	case "not":
+		if c.Cond == nil {
+			return fmt.Errorf("batch condition not requires a condition")
+		}
+		return w.message(3, c.Cond.encodeProto)
+	case "and", "or":
+		field := 4
+		if c.Type == "or" {
+			field = 5
+		}
+		return w.message(field, func(w *protoWriter) error {
+			for idx := range c.Conds {
+				if err := w.message(1, c.Conds[idx].encodeProto); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case "is_autocommit":
+		w.emptyMessage(6)
+	default:
+		return fmt.Errorf("unsupported batch condition type: %s", c.Type)
+	}
+	return nil
+}
+
+func (b *Batch) encodeProto(w *protoWriter) error {
+	for idx := range b.Steps {
+		step := &b.Steps[idx]
+		err := w.message(1, func(w *protoWriter) error {
+			if step.Condition != nil {
+				if err := w.message(1, step.Condition.encodeProto); err != nil {
+					return err
+				}
+			}
+			return w.message(2, step.Stmt.encodeProto)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if b.ReplicationIndex != nil {
+		w.uint64(2, *b.ReplicationIndex)
+	}
+	return nil
+}
+
+func (r *StreamRequest) encodeProto(w *protoWriter) error {
+	switch r.Type {
+	case "close":
+		w.emptyMessage(1)
+	case "execute":
+		if r.Stmt == nil {
+			return fmt.Errorf("execute request requires a statement")
+		}
+		return w.message(2, func(w *protoWriter) error {
+			return w.message(1, r.Stmt.encodeProto)
+		})
+	case "batch":
+		if r.Batch == nil {
+			return fmt.Errorf("batch request requires a batch")
+		}
+		return w.message(3, func(w *protoWriter) error {
+			return w.message(1, r.Batch.encodeProto)
+		})
+	case "sequence", "describe":
+		if r.Sql == nil && r.SqlId == nil {
+			return fmt.Errorf("%s request requires sql or sql_id", r.Type)
+		}
+		field := 4
+		if r.Type == "describe" {
+			field = 5
+		}
+		return w.message(field, func(w *protoWriter) error {
+			if r.Sql != nil {
+				w.string(1, *r.Sql)
+			}
+			if r.SqlId != nil {
+				w.int32(2, *r.SqlId)
+			}
+			return nil
+		})
+	case "store_sql":
+		if r.SqlId == nil || r.Sql == nil {
+			return fmt.Errorf("store_sql request requires sql and sql_id")
+		}
+		return w.message(6, func(w *protoWriter) error {
+			w.int32(1, *r.SqlId)
+			w.string(2, *r.Sql)
+			return nil
+		})
+	case "close_sql":
+		if r.SqlId == nil {
+			return fmt.Errorf("close_sql request requires sql_id")
+		}
+		return w.message(7, func(w *protoWriter) error {
+			w.int32(1, *r.SqlId)
+			return nil
+		})
+	case "get_autocommit":
+		w.emptyMessage(8)
+	default:
+		return fmt.Errorf("unsupported request type: %s", r.Type)
+	}
+	return nil
+}
+
+// MarshalProto encodes the request as a protobuf PipelineReqBody.
+func (pr *PipelineRequest) MarshalProto() ([]byte, error) {
+	w := protoWriter{}
+	if pr.Baton != "" {
+		w.string(1, pr.Baton)
+	}
+	for idx := range pr.Requests {
+		if err := w.message(2, pr.Requests[idx].encodeProto); err != nil {
+			return nil, err
+		}
+	}
+	return w.buf, nil
+}
+
+// MarshalProto encodes the request as a protobuf CursorReqBody.
+func (cr *CursorRequest) MarshalProto() ([]byte, error) {
+	w := protoWriter{}
+	if cr.Baton != "" {
+		w.string(1, cr.Baton)
+	}
+	if cr.Batch != nil {
+		if err := w.message(2, cr.Batch.encodeProto); err != nil {
+			return nil, err
+		}
+	}
+	return w.buf, nil
+}
+
+func (e *Error) decodeProto(r *protoReader) error {
+	return r.fields(func(r *protoReader, field int, wireType int) (err error) {
+		switch field {
+		case 1:
+			e.Message, err = r.string()
+		case 2:
+			var code string
+			if code, err = r.string(); err == nil {
+				e.Code = &code
+			}
+		default:
+			return errSkipField
+		}
+		return err
+	})
+}
+
+func (c *Column) decodeProto(r *protoReader) error {
+	return r.fields(func(r *protoReader, field int, wireType int) error {
+		switch field {
+		case 1, 2:
+			v, err := r.string()
+			if err != nil {
+				return err
+			}
+			if field == 1 {
+				c.Name = &v
+			} else {
+				c.Type = &v
+			}
+			return nil
+		default:
+			return errSkipField
+		}
+	})
+}
+
+func decodeProtoRow(r *protoReader) ([]Value, error) {
+	row := make([]Value, 0)
+	err := r.fields(func(r *protoReader, field int, wireType int) error {
+		if field != 1 {
+			return errSkipField
+		}
+		m, err := r.message()
+		if err != nil {
+			return err
+		}
+		var v Value
+		if err := v.decodeProto(m); err != nil {
+			return err
+		}
+		row = append(row, v)
+		return nil
+	})
+	return row, err
+}
+
+func decodeProtoColumn(r *protoReader) (Column, error) {
+	var c Column
+	m, err := r.message()
+	if err != nil {
+		return c, err
+	}
+	return c, c.decodeProto(m)
+}
+
+func (s *StmtResult) decodeProto(r *protoReader) error {
+	s.Cols = make([]Column, 0)
+	s.Rows = make([][]Value, 0)
+	return r.fields(func(r *protoReader, field int, wireType int) error {
+		switch field {
+		case 1:
+			c, err := decodeProtoColumn(r)
+			if err != nil {
+				return err
+			}
+			s.Cols = append(s.Cols, c)
+		case 2:
+			m, err := r.message()
+			if err != nil {
+				return err
+			}
+			row, err := decodeProtoRow(m)
+			if err != nil {
+				return err
+			}
+			s.Rows = append(s.Rows, row)
+		case 3:
+			v, err := r.varint()
+			if err != nil {
+				return err
+			}
+			s.AffectedRowCount = int32(v)
+		case 4:
+			v, err := r.sint64()
+			if err != nil {
+				return err
+			}
+			rowId := strconv.FormatInt(v, 10)
+			s.LastInsertRowId = &rowId
+		case 5:
+			v, err := r.varint()
+			if err != nil {
+				return err
+			}
+			s.ReplicationIndex = &v
+		default:
+			return errSkipField
+		}
+		return nil
+	})
+}
+
+// decodeProto decodes a protobuf BatchResult. Steps that were skipped are absent from the encoded
+// maps, so stepsCount gives the number of steps in the batch.
+func (b *BatchResult) decodeProto(r *protoReader, stepsCount int) error {
+	b.StepResults = make([]*StmtResult, stepsCount)
+	b.StepErrors = make([]*Error, stepsCount)
+	return r.fields(func(r *protoReader, field int, wireType int) error {
+		switch field {
+		case 1, 2:
+			entry, err := r.message()
+			if err != nil {
+				return err
+			}
+			var step uint64
+			var value *protoReader
+			err = entry.fields(func(r *protoReader, field int, wireType int) (err error) {
+				switch field {
+				case 1:
+					step, err = r.varint()
+				case 2:
+					value, err = r.message()
+				default:
+					return errSkipField
+				}
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			if step >= uint64(stepsCount) {
+				return fmt.Errorf("batch result for step %d, but the batch has only %d steps", step, stepsCount)
+			}
+			if value == nil {
+				value = &protoReader{}
+			}
+			if field == 1 {
+				b.StepResults[step] = &StmtResult{}
+				return b.StepResults[step].decodeProto(value)
+			}
+			b.StepErrors[step] = &Error{}
+			return b.StepErrors[step].decodeProto(value)
+		case 3:
+			v, err := r.varint()
+			if err != nil {
+				return err
+			}
+			b.ReplicationIndex = &v
+			return nil
+		default:
+			return errSkipField
+		}
+	})
+}
+
+func (d *DescribeResult) decodeProto(r *protoReader) error {
+	d.Params = make([]DescribeParam, 0)
+	d.Cols = make([]DescribeCol, 0)
+	return r.fields(func(r *protoReader, field int, wireType int) error {
+		switch field {
+		case 1:
+			m, err := r.message()
+			if err != nil {
+				return err
+			}
+			var param DescribeParam
+			err = m.fields(func(r *protoReader, field int, wireType int) error {
+				if field != 1 {
+					return errSkipField
+				}
+				name, err := r.string()
+				param.Name = &name
+				return err
+			})
+			d.Params = append(d.Params, param)
+			return err
+		case 2:
+			m, err := r.message()
+			if err != nil {
+				return err
+			}
+			var col DescribeCol
+			err = m.fields(func(r *protoReader, field int, wireType int) (err error) {
+				switch field {
+				case 1:
+					col.Name, err = r.string()
+				case 2:
+					var decltype string
+					decltype, err = r.string()
+					col.Decltype = &decltype
+				default:
+					return errSkipField
+				}
+				return err
+			})
+			d.Cols = append(d.Cols, col)
+			return err
+		case 3, 4:
+			v, err := r.varint()
+			if field == 3 {
+				d.IsExplain = v != 0
+			} else {
+				d.IsReadonly = v != 0
+			}
+			return err
+		default:
+			return errSkipField
+		}
+	})
+}
+
+var protoResponseTypes = map[int]string{
+	1: "close",
+	2: "execute",
+	3: "batch",
+	4: "sequence",
+	5: "describe",
+	6: "store_sql",
+	7: "close_sql",
+	8: "get_autocommit",
+}
+
+func (sr *StreamResponse) decodeProto(r *protoReader, req *StreamRequest) error {
+	return r.fields(func(r *protoReader, field int, wireType int) error {
+		responseType, ok := protoResponseTypes[field]
+		if !ok {
+			return errSkipField
+		}
+		sr.Type = responseType
+		m, err := r.message()
+		if err != nil {
+			return err
+		}
+		switch responseType {
+		case "execute":
+			sr.stmtResult = &StmtResult{}
+			return m.fields(func(r *protoReader, field int, wireType int) error {
+				if field != 1 {
+					return errSkipField
+				}
+				m, err := r.message()
+				if err != nil {
+					return err
+				}
+				return sr.stmtResult.decodeProto(m)
+			})
+		case "batch":
+			stepsCount := 0
+			if req != nil && req.Batch != nil {
+				stepsCount = len(req.Batch.Steps)
+			}
+			sr.batchResult = &BatchResult{}
+			found := false
+			err := m.fields(func(r *protoReader, field int, wireType int) error {
+				if field != 1 {
+					return errSkipField
+				}
+				m, err := r.message()
+				if err != nil {
+					return err
+				}
+				found = true
+				return sr.batchResult.decodeProto(m, stepsCount)
+			})
+			if err == nil && !found {
+				err = sr.batchResult.decodeProto(&protoReader{}, stepsCount)
+			}
+			return err
+		case "describe":
+			sr.describeResult = &DescribeResult{}
+			return m.fields(func(r *protoReader, field int, wireType int) error {
+				if field != 1 {
+					return errSkipField
+				}
+				m, err := r.message()
+				if err != nil {
+					return err
+				}
+				return sr.describeResult.decodeProto(m)
+			})
+		case "get_autocommit":
+			isAutocommit := false
+			sr.IsAutocommit = &isAutocommit
+			return m.fields(func(r *protoReader, field int, wireType int) error {
+				if field != 1 {
+					return errSkipField
+				}
+				v, err := r.varint()
+				isAutocommit = v != 0
+				return err
+			})
+		}
+		return nil
+	})
+}
+
+// UnmarshalProto decodes a protobuf PipelineRespBody. The request that produced the response is
+// needed to know how many steps each batch had.
+func (pr *PipelineResponse) UnmarshalProto(data []byte, req *PipelineRequest) error {
+	pr.Results = make([]StreamResult, 0, len(req.Requests))
+	return (&protoReader{buf: data}).fields(func(r *protoReader, field int, wireType int) (err error) {
+		switch field {
+		case 1:
+			pr.Baton, err = r.string()
+		case 2:
+			pr.BaseUrl, err = r.string()
+		case 3:
+			var m *protoReader
+			if m, err = r.message(); err != nil {
+				return err
+			}
+			var streamReq *StreamRequest
+			if idx := len(pr.Results); idx < len(req.Requests) {
+				streamReq = &req.Requests[idx]
+			}
+			result := StreamResult{}
+			err = m.fields(func(r *protoReader, field int, wireType int) error {
+				if field != 1 && field != 2 {
+					return errSkipField
+				}
+				m, err := r.message()
+				if err != nil {
+					return err
+				}
+				if field == 1 {
+					result.Type = "ok"
+					result.Response = &StreamResponse{}
+					return result.Response.decodeProto(m, streamReq)
+				}
+				result.Type = "error"
+				result.Error = &Error{}
+				return result.Error.decodeProto(m)
+			})
+			pr.Results = append(pr.Results, result)
+		default:
+			return errSkipField
+		}
+		return err
+	})
+}
+
+// UnmarshalProto decodes a protobuf CursorRespBody.
+func (cr *CursorResponse) UnmarshalProto(data []byte) error {
+	return (&protoReader{buf: data}).fields(func(r *protoReader, field int, wireType int) (err error) {
+		switch field {
+		case 1:
+			cr.Baton, err = r.string()
+		case 2:
+			cr.BaseUrl, err = r.string()
+		default:
+			return errSkipField
+		}
+		return err
+	})
+}
+
+// UnmarshalProto decodes a protobuf CursorEntry.
+func (ce *CursorEntry) UnmarshalProto(data []byte) error {
+	return (&protoReader{buf: data}).fields(func(r *protoReader, field int, wireType int) error {
+		if field < 1 || field > 5 {
+			return errSkipField
+		}
+		m, err := r.message()
+		if err != nil {
+			return err
+		}
+		switch field {
+		case 1:
+			ce.Type = "step_begin"
+			return m.fields(func(r *protoReader, field int, wireType int) error {
+				switch field {
+				case 1:
+					step, err := r.varint()
+					ce.Step = int32(step)
+					return err
+				case 2:
+					c, err := decodeProtoColumn(r)
+					ce.Cols = append(ce.Cols, c)
+					return err
+				}
+				return errSkipField
+			})
+		case 2:
+			ce.Type = "step_end"
+			return m.fields(func(r *protoReader, field int, wireType int) error {
+				switch field {
+				case 1:
+					count, err := r.varint()
+					ce.AffectedRowCount = int32(count)
+					return err
+				case 2:
+					rowId, err := r.sint64()
+					id := strconv.FormatInt(rowId, 10)
+					ce.LastInsertRowId = &id
+					return err
+				}
+				return errSkipField
+			})
+		case 3:
+			ce.Type = "step_error"
+			return m.fields(func(r *protoReader, field int, wireType int) error {
+				switch field {
+				case 1:
+					step, err := r.varint()
+					ce.Step = int32(step)
+					return err
+				case 2:
+					m, err := r.message()
+					if err != nil {
+						return err
+					}
+					ce.Error = &Error{}
+					return ce.Error.decodeProto(m)
+				}
+				return errSkipField
+			})
+		case 4:
+			ce.Type = "row"
+			ce.Row, err = decodeProtoRow(m)
+			return err
+		default:
+			ce.Type = "error"
+			ce.Error = &Error{}
+			return ce.Error.decodeProto(m)
+		}
+	})
+}
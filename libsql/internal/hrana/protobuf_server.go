@@ -0,0 +1,495 @@
+package hrana
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// This file implements the server side of the protobuf encoding, which decodes requests and encodes
+// responses. The driver itself doesn't need it; libsqltest uses it to serve clients that speak
+// protobuf.
+
+func decodeProtoValue(r *protoReader) (Value, error) {
+	var v Value
+	m, err := r.message()
+	if err != nil {
+		return v, err
+	}
+	return v, v.decodeProto(m)
+}
+
+func (s *Stmt) decodeProto(r *protoReader) error {
+	return r.fields(func(r *protoReader, field int, wireType int) error {
+		switch field {
+		case 1:
+			sql, err := r.string()
+			s.Sql = &sql
+			return err
+		case 2:
+			id, err := r.varint()
+			sqlId := int32(id)
+			s.SqlId = &sqlId
+			return err
+		case 3:
+			v, err := decodeProtoValue(r)
+			s.Args = append(s.Args, v)
+			return err
+		case 4:
+			m, err := r.message()
+			if err != nil {
+				return err
+			}
+			var arg NamedArg
+			err = m.fields(func(r *protoReader, field int, wireType int) (err error) {
+				switch field {
+				case 1:
+					arg.Name, err = r.string()
+				case 2:
+					arg.Value, err = decodeProtoValue(r)
+				default:
+					return errSkipField
+				}
+				return err
+			})
+			s.NamedArgs = append(s.NamedArgs, arg)
+			return err
+		case 5:
+			v, err := r.varint()
+			s.WantRows = v != 0
+			return err
+		case 6:
+			v, err := r.varint()
+			s.ReplicationIndex = &v
+			return err
+		default:
+			return errSkipField
+		}
+	})
+}
+
+func (c *BatchCondition) decodeProto(r *protoReader) error {
+	return r.fields(func(r *protoReader, field int, wireType int) error {
+		switch field {
+		case 1, 2:
+			c.Type = "ok"
+			if field == 2 {
+				c.Type = "error"
+			}
+			v, err := r.varint()
+			step := int32(v)
+			c.Step = &step
+			return err
+		case 3:
+			m, err := r.message()
+			if err != nil {
+				return err
+			}
+			c.Type = "not"
+			c.Cond = &BatchCondition{}
+			return c.Cond.decodeProto(m)
+		case 4, 5:
+			m, err := r.message()
+			if err != nil {
+				return err
+			}
+			c.Type = "and"
+			if field == 5 {
+				c.Type = "or"
+			}
+			c.Conds = make([]BatchCondition, 0)
+			return m.fields(func(r *protoReader, field int, wireType int) error {
+				if field != 1 {
+					return errSkipField
+				}
+				m, err := r.message()
+				if err != nil {
+					return err
+				}
+				var cond BatchCondition
+				err = cond.decodeProto(m)
+				c.Conds = append(c.Conds, cond)
+				return err
+			})
+		case 6:
+			c.Type = "is_autocommit"
+			return errSkipField
+		default:
+			return errSkipField
+		}
+	})
+}
+
+func (b *Batch) decodeProto(r *protoReader) error {
+	b.Steps = make([]BatchStep, 0)
+	return r.fields(func(r *protoReader, field int, wireType int) error {
+		switch field {
+		case 1:
+			m, err := r.message()
+			if err != nil {
+				return err
+			}
+			var step BatchStep
+			err = m.fields(func(r *protoReader, field int, wireType int) error {
+				if field != 1 && field != 2 {
+					return errSkipField
+				}
+				m, err := r.message()
+				if err != nil {
+					return err
+				}
+				if field == 1 {
+					step.Condition = &BatchCondition{}
+					return step.Condition.decodeProto(m)
+				}
+				return step.Stmt.decodeProto(m)
+			})
+			b.Steps = append(b.Steps, step)
+			return err
+		case 2:
+			v, err := r.varint()
+			b.ReplicationIndex = &v
+			return err
+		default:
+			return errSkipField
+		}
+	})
+}
+
+func (sr *StreamRequest) decodeProto(r *protoReader) error {
+	return r.fields(func(r *protoReader, field int, wireType int) error {
+		requestType, ok := protoResponseTypes[field]
+		if !ok {
+			return errSkipField
+		}
+		sr.Type = requestType
+		m, err := r.message()
+		if err != nil {
+			return err
+		}
+		return m.fields(func(r *protoReader, field int, wireType int) error {
+			switch {
+			case requestType == "execute" && field == 1:
+				m, err := r.message()
+				if err != nil {
+					return err
+				}
+				sr.Stmt = &Stmt{}
+				return sr.Stmt.decodeProto(m)
+			case requestType == "batch" && field == 1:
+				m, err := r.message()
+				if err != nil {
+					return err
+				}
+				sr.Batch = &Batch{}
+				return sr.Batch.decodeProto(m)
+			case (requestType == "sequence" || requestType == "describe") && field == 1,
+				requestType == "store_sql" && field == 2:
+				sql, err := r.string()
+				sr.Sql = &sql
+				return err
+			case (requestType == "sequence" || requestType == "describe") && field == 2,
+				(requestType == "store_sql" || requestType == "close_sql") && field == 1:
+				id, err := r.varint()
+				sqlId := int32(id)
+				sr.SqlId = &sqlId
+				return err
+			}
+			return errSkipField
+		})
+	})
+}
+
+// UnmarshalProto decodes a protobuf PipelineReqBody.
+func (pr *PipelineRequest) UnmarshalProto(data []byte) error {
+	pr.Requests = make([]StreamRequest, 0)
+	return (&protoReader{buf: data}).fields(func(r *protoReader, field int, wireType int) error {
+		switch field {
+		case 1:
+			baton, err := r.string()
+			pr.Baton = baton
+			return err
+		case 2:
+			m, err := r.message()
+			if err != nil {
+				return err
+			}
+			var req StreamRequest
+			err = req.decodeProto(m)
+			pr.Requests = append(pr.Requests, req)
+			return err
+		default:
+			return errSkipField
+		}
+	})
+}
+
+// UnmarshalProto decodes a protobuf CursorReqBody.
+func (cr *CursorRequest) UnmarshalProto(data []byte) error {
+	return (&protoReader{buf: data}).fields(func(r *protoReader, field int, wireType int) error {
+		switch field {
+		case 1:
+			baton, err := r.string()
+			cr.Baton = baton
+			return err
+		case 2:
+			m, err := r.message()
+			if err != nil {
+				return err
+			}
+			cr.Batch = &Batch{}
+			return cr.Batch.decodeProto(m)
+		default:
+			return errSkipField
+		}
+	})
+}
+
+func (e *Error) encodeProto(w *protoWriter) error {
+	w.string(1, e.Message)
+	if e.Code != nil {
+		w.string(2, *e.Code)
+	}
+	return nil
+}
+
+func (c *Column) encodeProto(w *protoWriter) error {
+	if c.Name != nil {
+		w.string(1, *c.Name)
+	}
+	if c.Type != nil {
+		w.string(2, *c.Type)
+	}
+	return nil
+}
+
+func encodeProtoRow(w *protoWriter, row []Value) error {
+	for _, v := range row {
+		if err := w.message(1, v.encodeProto); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeProtoRowId(w *protoWriter, field int, rowId *string) error {
+	if rowId == nil {
+		return nil
+	}
+	v, err := strconv.ParseInt(*rowId, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid last insert rowid: %s", *rowId)
+	}
+	w.sint64(field, v)
+	return nil
+}
+
+func (s *StmtResult) encodeProto(w *protoWriter) error {
+	for idx := range s.Cols {
+		if err := w.message(1, s.Cols[idx].encodeProto); err != nil {
+			return err
+		}
+	}
+	for _, row := range s.Rows {
+		row := row
+		if err := w.message(2, func(w *protoWriter) error { return encodeProtoRow(w, row) }); err != nil {
+			return err
+		}
+	}
+	w.uint64(3, uint64(s.AffectedRowCount))
+	if err := encodeProtoRowId(w, 4, s.LastInsertRowId); err != nil {
+		return err
+	}
+	if s.ReplicationIndex != nil {
+		w.uint64(5, *s.ReplicationIndex)
+	}
+	return nil
+}
+
+// encodeProto encodes a protobuf BatchResult. Only the steps that have a result or an error are
+// encoded, as entries of the step_results and step_errors maps.
+func (b *BatchResult) encodeProto(w *protoWriter) error {
+	for step, res := range b.StepResults {
+		if res == nil {
+			continue
+		}
+		step, res := step, res
+		err := w.message(1, func(w *protoWriter) error {
+			w.uint64(1, uint64(step))
+			return w.message(2, res.encodeProto)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for step, stepErr := range b.StepErrors {
+		if stepErr == nil {
+			continue
+		}
+		step, stepErr := step, stepErr
+		err := w.message(2, func(w *protoWriter) error {
+			w.uint64(1, uint64(step))
+			return w.message(2, stepErr.encodeProto)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if b.ReplicationIndex != nil {
+		w.uint64(3, *b.ReplicationIndex)
+	}
+	return nil
+}
+
+func (d *DescribeResult) encodeProto(w *protoWriter) error {
+	for _, param := range d.Params {
+		param := param
+		err := w.message(1, func(w *protoWriter) error {
+			if param.Name != nil {
+				w.string(1, *param.Name)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, col := range d.Cols {
+		col := col
+		err := w.message(2, func(w *protoWriter) error {
+			w.string(1, col.Name)
+			if col.Decltype != nil {
+				w.string(2, *col.Decltype)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.bool(3, d.IsExplain)
+	w.bool(4, d.IsReadonly)
+	return nil
+}
+
+func (sr *StreamResponse) encodeProto(w *protoWriter) error {
+	field := 0
+	for f, responseType := range protoResponseTypes {
+		if responseType == sr.Type {
+			field = f
+		}
+	}
+	if field == 0 {
+		return fmt.Errorf("unsupported response type: %s", sr.Type)
+	}
+	return w.message(field, func(w *protoWriter) error {
+		switch sr.Type {
+		case "execute":
+			res, err := sr.ExecuteResult()
+			if err != nil {
+				return err
+			}
+			return w.message(1, res.encodeProto)
+		case "batch":
+			res, err := sr.BatchStepsResult()
+			if err != nil {
+				return err
+			}
+			return w.message(1, res.encodeProto)
+		case "describe":
+			res, err := sr.DescribeResult()
+			if err != nil {
+				return err
+			}
+			return w.message(1, res.encodeProto)
+		case "get_autocommit":
+			isAutocommit, err := sr.AutocommitResult()
+			if err != nil {
+				return err
+			}
+			w.bool(1, isAutocommit)
+		}
+		return nil
+	})
+}
+
+// MarshalProto encodes the response as a protobuf PipelineRespBody.
+func (pr *PipelineResponse) MarshalProto() ([]byte, error) {
+	w := protoWriter{}
+	if pr.Baton != "" {
+		w.string(1, pr.Baton)
+	}
+	if pr.BaseUrl != "" {
+		w.string(2, pr.BaseUrl)
+	}
+	for idx := range pr.Results {
+		result := &pr.Results[idx]
+		err := w.message(3, func(w *protoWriter) error {
+			switch {
+			case result.Type == "ok" && result.Response != nil:
+				return w.message(1, result.Response.encodeProto)
+			case result.Type == "error" && result.Error != nil:
+				return w.message(2, result.Error.encodeProto)
+			}
+			return fmt.Errorf("invalid stream result of type %s", result.Type)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return w.buf, nil
+}
+
+// MarshalProto encodes the response as a protobuf CursorRespBody.
+func (cr *CursorResponse) MarshalProto() ([]byte, error) {
+	w := protoWriter{}
+	if cr.Baton != "" {
+		w.string(1, cr.Baton)
+	}
+	if cr.BaseUrl != "" {
+		w.string(2, cr.BaseUrl)
+	}
+	return w.buf, nil
+}
+
+// MarshalProto encodes the entry as a protobuf CursorEntry.
+func (ce *CursorEntry) MarshalProto() ([]byte, error) {
+	w := protoWriter{}
+	var err error
+	switch ce.Type {
+	case "step_begin":
+		err = w.message(1, func(w *protoWriter) error {
+			w.uint64(1, uint64(ce.Step))
+			for idx := range ce.Cols {
+				if err := w.message(2, ce.Cols[idx].encodeProto); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case "step_end":
+		err = w.message(2, func(w *protoWriter) error {
+			w.uint64(1, uint64(ce.AffectedRowCount))
+			return encodeProtoRowId(w, 2, ce.LastInsertRowId)
+		})
+	case "step_error", "error":
+		if ce.Error == nil {
+			return nil, fmt.Errorf("cursor entry %s requires an error", ce.Type)
+		}
+		if ce.Type == "error" {
+			err = w.message(5, ce.Error.encodeProto)
+			break
+		}
+		err = w.message(3, func(w *protoWriter) error {
+			w.uint64(1, uint64(ce.Step))
+			return w.message(2, ce.Error.encodeProto)
+		})
+	case "row":
+		err = w.message(4, func(w *protoWriter) error { return encodeProtoRow(w, ce.Row) })
+	default:
+		err = fmt.Errorf("unsupported cursor entry type: %s", ce.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return w.buf, nil
+}
@@ -0,0 +1,381 @@
+package hrana
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestValueProtoRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		value Value
+		want  Value
+	}{
+		{name: "null", value: Value{Type: "null"}, want: Value{Type: "null"}},
+		{name: "integer", value: Value{Type: "integer", Value: "-42"}, want: Value{Type: "integer", Value: int64(-42)}},
+		{name: "float", value: Value{Type: "float", Value: 3.5}, want: Value{Type: "float", Value: 3.5}},
+		{name: "text", value: Value{Type: "text", Value: "foo"}, want: Value{Type: "text", Value: "foo"}},
+		{name: "blob", value: Value{Type: "blob", Base64: toPtr("AQID")}, want: Value{Type: "blob", Value: []byte{1, 2, 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := protoWriter{}
+			if err := tt.value.encodeProto(&w); err != nil {
+				t.Fatalf("encodeProto() error = %v", err)
+			}
+			var got Value
+			if err := got.decodeProto(&protoReader{buf: w.buf}); err != nil {
+				t.Fatalf("decodeProto() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeProto() = %#v, want %#v", got, tt.want)
+			}
+			if got.ToValue(nil) == nil && tt.want.Type != "null" {
+				t.Errorf("ToValue() = nil for %s", tt.want.Type)
+			}
+		})
+	}
+}
+
+func encodeStmtResult(w *protoWriter) error {
+	if err := w.message(1, func(w *protoWriter) error {
+		w.string(1, "a")
+		w.string(2, "INTEGER")
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := w.message(2, func(w *protoWriter) error {
+		return w.message(1, func(w *protoWriter) error {
+			w.sint64(2, 7)
+			return nil
+		})
+	}); err != nil {
+		return err
+	}
+	w.uint64(3, 1)
+	w.sint64(4, 5)
+	return nil
+}
+
+func TestPipelineResponseUnmarshalProto(t *testing.T) {
+	req := &PipelineRequest{}
+	req.Add(StreamRequest{Type: "execute", Stmt: &Stmt{Sql: toPtr("SELECT 7 AS a")}})
+	req.Add(StreamRequest{Type: "batch", Batch: &Batch{Steps: make([]BatchStep, 3)}})
+	req.Add(CloseStream())
+	if _, err := req.MarshalProto(); err != nil {
+		t.Fatalf("MarshalProto() error = %v", err)
+	}
+
+	w := protoWriter{}
+	w.string(1, "baton")
+	// execute
+	_ = w.message(3, func(w *protoWriter) error {
+		return w.message(1, func(w *protoWriter) error {
+			return w.message(2, func(w *protoWriter) error {
+				return w.message(1, encodeStmtResult)
+			})
+		})
+	})
+	// batch with an error in step 0, a result in step 1 and step 2 skipped
+	_ = w.message(3, func(w *protoWriter) error {
+		return w.message(1, func(w *protoWriter) error {
+			return w.message(3, func(w *protoWriter) error {
+				return w.message(1, func(w *protoWriter) error {
+					if err := w.message(1, func(w *protoWriter) error {
+						w.uint64(1, 1)
+						return w.message(2, encodeStmtResult)
+					}); err != nil {
+						return err
+					}
+					return w.message(2, func(w *protoWriter) error {
+						w.uint64(1, 0)
+						return w.message(2, func(w *protoWriter) error {
+							w.string(1, "boom")
+							return nil
+						})
+					})
+				})
+			})
+		})
+	})
+	// error
+	_ = w.message(3, func(w *protoWriter) error {
+		return w.message(2, func(w *protoWriter) error {
+			w.string(1, "stream expired")
+			w.string(2, "STREAM_EXPIRED")
+			return nil
+		})
+	})
+
+	var resp PipelineResponse
+	if err := resp.UnmarshalProto(w.buf, req); err != nil {
+		t.Fatalf("UnmarshalProto() error = %v", err)
+	}
+	if resp.Baton != "baton" || len(resp.Results) != 3 {
+		t.Fatalf("UnmarshalProto() = %#v", resp)
+	}
+
+	wantStmt := &StmtResult{
+		Cols:             []Column{{Name: toPtr("a"), Type: toPtr("INTEGER")}},
+		Rows:             [][]Value{{{Type: "integer", Value: int64(7)}}},
+		AffectedRowCount: 1,
+		LastInsertRowId:  toPtr("5"),
+	}
+	stmt, err := resp.Results[0].Response.ExecuteResult()
+	if err != nil {
+		t.Fatalf("ExecuteResult() error = %v", err)
+	}
+	if !reflect.DeepEqual(stmt, wantStmt) {
+		t.Errorf("ExecuteResult() = %#v, want %#v", stmt, wantStmt)
+	}
+
+	if _, err := resp.Results[1].Response.BatchResult(); err == nil {
+		t.Errorf("BatchResult() expected the step error")
+	}
+	batch := resp.Results[1].Response.batchResult
+	if len(batch.StepResults) != 3 || len(batch.StepErrors) != 3 {
+		t.Fatalf("batch result has %d results and %d errors, want 3", len(batch.StepResults), len(batch.StepErrors))
+	}
+	if !reflect.DeepEqual(batch.StepResults[1], wantStmt) || batch.StepResults[0] != nil || batch.StepResults[2] != nil {
+		t.Errorf("batch step results = %#v", batch.StepResults)
+	}
+	if batch.StepErrors[0] == nil || batch.StepErrors[0].Message != "boom" {
+		t.Errorf("batch step errors = %#v", batch.StepErrors)
+	}
+
+	if resp.Results[2].Type != "error" || resp.Results[2].Error.Message != "stream expired" || *resp.Results[2].Error.Code != "STREAM_EXPIRED" {
+		t.Errorf("error result = %#v", resp.Results[2])
+	}
+}
+
+func TestCursorEntryUnmarshalProto(t *testing.T) {
+	var stream bytes.Buffer
+	writeMessage := func(encode func(w *protoWriter) error) {
+		w := protoWriter{}
+		if err := encode(&w); err != nil {
+			t.Fatal(err)
+		}
+		stream.Write(binary.AppendUvarint(nil, uint64(len(w.buf))))
+		stream.Write(w.buf)
+	}
+	writeMessage(func(w *protoWriter) error {
+		w.string(1, "baton")
+		return nil
+	})
+	writeMessage(func(w *protoWriter) error {
+		return w.message(1, func(w *protoWriter) error {
+			w.uint64(1, 0)
+			return w.message(2, func(w *protoWriter) error {
+				w.string(1, "a")
+				return nil
+			})
+		})
+	})
+	writeMessage(func(w *protoWriter) error {
+		return w.message(4, func(w *protoWriter) error {
+			return w.message(1, func(w *protoWriter) error {
+				w.bytes(5, []byte{0xff})
+				return nil
+			})
+		})
+	})
+	writeMessage(func(w *protoWriter) error {
+		return w.message(2, func(w *protoWriter) error {
+			w.uint64(1, 2)
+			return nil
+		})
+	})
+
+	reader := bufio.NewReader(&stream)
+	data, err := ReadProtoMessage(reader)
+	if err != nil {
+		t.Fatalf("ReadProtoMessage() error = %v", err)
+	}
+	var head CursorResponse
+	if err := head.UnmarshalProto(data); err != nil || head.Baton != "baton" {
+		t.Fatalf("CursorResponse.UnmarshalProto() = %#v, %v", head, err)
+	}
+	want := []CursorEntry{
+		{Type: "step_begin", Cols: []Column{{Name: toPtr("a")}}},
+		{Type: "row", Row: []Value{{Type: "blob", Value: []byte{0xff}}}},
+		{Type: "step_end", AffectedRowCount: 2},
+	}
+	for _, w := range want {
+		data, err := ReadProtoMessage(reader)
+		if err != nil {
+			t.Fatalf("ReadProtoMessage() error = %v", err)
+		}
+		var entry CursorEntry
+		if err := entry.UnmarshalProto(data); err != nil {
+			t.Fatalf("CursorEntry.UnmarshalProto() error = %v", err)
+		}
+		if !reflect.DeepEqual(entry, w) {
+			t.Errorf("CursorEntry.UnmarshalProto() = %#v, want %#v", entry, w)
+		}
+	}
+	if _, err := ReadProtoMessage(reader); err == nil {
+		t.Errorf("ReadProtoMessage() expected EOF after the last entry")
+	}
+}
+
+func TestDescribeAndAutocommitUnmarshalProto(t *testing.T) {
+	req := &PipelineRequest{}
+	req.Add(DescribeStream("SELECT :a AS a"))
+	req.Add(GetAutocommitStream())
+	if _, err := req.MarshalProto(); err != nil {
+		t.Fatalf("MarshalProto() error = %v", err)
+	}
+
+	w := protoWriter{}
+	_ = w.message(3, func(w *protoWriter) error {
+		return w.message(1, func(w *protoWriter) error {
+			return w.message(5, func(w *protoWriter) error {
+				return w.message(1, func(w *protoWriter) error {
+					if err := w.message(1, func(w *protoWriter) error {
+						w.string(1, ":a")
+						return nil
+					}); err != nil {
+						return err
+					}
+					if err := w.message(2, func(w *protoWriter) error {
+						w.string(1, "a")
+						return nil
+					}); err != nil {
+						return err
+					}
+					w.bool(4, true)
+					return nil
+				})
+			})
+		})
+	})
+	_ = w.message(3, func(w *protoWriter) error {
+		return w.message(1, func(w *protoWriter) error {
+			return w.message(8, func(w *protoWriter) error {
+				w.bool(1, true)
+				return nil
+			})
+		})
+	})
+
+	var resp PipelineResponse
+	if err := resp.UnmarshalProto(w.buf, req); err != nil {
+		t.Fatalf("UnmarshalProto() error = %v", err)
+	}
+	describe, err := resp.Results[0].Response.DescribeResult()
+	if err != nil {
+		t.Fatalf("DescribeResult() error = %v", err)
+	}
+	want := &DescribeResult{
+		Params:     []DescribeParam{{Name: toPtr(":a")}},
+		Cols:       []DescribeCol{{Name: "a"}},
+		IsReadonly: true,
+	}
+	if !reflect.DeepEqual(describe, want) {
+		t.Errorf("DescribeResult() = %#v, want %#v", describe, want)
+	}
+	if isAutocommit, err := resp.Results[1].Response.AutocommitResult(); err != nil || !isAutocommit {
+		t.Errorf("AutocommitResult() = %v, %v", isAutocommit, err)
+	}
+}
+
+func TestPipelineRequestProtoRoundTrip(t *testing.T) {
+	step := int32(0)
+	req := &PipelineRequest{Baton: "baton"}
+	req.Add(StreamRequest{Type: "execute", Stmt: &Stmt{
+		Sql:       toPtr("SELECT ?, :b"),
+		Args:      []Value{{Type: "integer", Value: int64(1)}},
+		NamedArgs: []NamedArg{{Name: ":b", Value: Value{Type: "text", Value: "b"}}},
+		WantRows:  true,
+	}})
+	req.Add(StreamRequest{Type: "batch", Batch: &Batch{Steps: []BatchStep{
+		{Stmt: Stmt{SqlId: toPtr(int32(3))}},
+		{Stmt: Stmt{Sql: toPtr("COMMIT")}, Condition: &BatchCondition{Type: "and", Conds: []BatchCondition{
+			{Type: "ok", Step: &step},
+			{Type: "not", Cond: &BatchCondition{Type: "is_autocommit"}},
+		}}},
+	}}})
+	req.Add(StreamRequest{Type: "store_sql", Sql: toPtr("SELECT 1"), SqlId: toPtr(int32(3))})
+	req.Add(GetAutocommitStream())
+	req.Add(CloseStream())
+
+	data, err := req.MarshalProto()
+	if err != nil {
+		t.Fatalf("MarshalProto() error = %v", err)
+	}
+	var got PipelineRequest
+	if err := got.UnmarshalProto(data); err != nil {
+		t.Fatalf("UnmarshalProto() error = %v", err)
+	}
+	if !reflect.DeepEqual(&got, req) {
+		t.Errorf("UnmarshalProto() = %#v, want %#v", got, *req)
+	}
+}
+
+func TestPipelineResponseProtoRoundTrip(t *testing.T) {
+	req := &PipelineRequest{}
+	req.Add(StreamRequest{Type: "execute", Stmt: &Stmt{Sql: toPtr("SELECT 7 AS a")}})
+	req.Add(StreamRequest{Type: "batch", Batch: &Batch{Steps: make([]BatchStep, 2)}})
+	req.Add(CloseStream())
+	resp := PipelineResponse{Baton: "baton", BaseUrl: "http://example.com", Results: []StreamResult{
+		{Type: "ok", Response: &StreamResponse{Type: "execute", Result: []byte(`{"cols":[{"name":"a","decltype":"INTEGER"}],"rows":[[{"type":"integer","value":"7"}]],"affected_row_count":1,"last_insert_rowid":"5"}`)}},
+		{Type: "ok", Response: &StreamResponse{Type: "batch", Result: []byte(`{"step_results":[null,{"cols":[],"rows":[],"affected_row_count":0,"last_insert_rowid":null}],"step_errors":[{"message":"boom"},null]}`)}},
+		{Type: "error", Error: &Error{Message: "stream expired", Code: toPtr("STREAM_EXPIRED")}},
+	}}
+
+	data, err := resp.MarshalProto()
+	if err != nil {
+		t.Fatalf("MarshalProto() error = %v", err)
+	}
+	var got PipelineResponse
+	if err := got.UnmarshalProto(data, req); err != nil {
+		t.Fatalf("UnmarshalProto() error = %v", err)
+	}
+	if got.Baton != resp.Baton || got.BaseUrl != resp.BaseUrl || len(got.Results) != 3 {
+		t.Fatalf("UnmarshalProto() = %#v", got)
+	}
+	stmt, err := got.Results[0].Response.ExecuteResult()
+	wantStmt := &StmtResult{
+		Cols:             []Column{{Name: toPtr("a"), Type: toPtr("INTEGER")}},
+		Rows:             [][]Value{{{Type: "integer", Value: int64(7)}}},
+		AffectedRowCount: 1,
+		LastInsertRowId:  toPtr("5"),
+	}
+	if err != nil || !reflect.DeepEqual(stmt, wantStmt) {
+		t.Errorf("ExecuteResult() = %#v, %v, want %#v", stmt, err, wantStmt)
+	}
+	batch, err := got.Results[1].Response.BatchStepsResult()
+	if err != nil || batch.StepResults[0] != nil || batch.StepResults[1] == nil || batch.StepErrors[0].Message != "boom" || batch.StepErrors[1] != nil {
+		t.Errorf("BatchStepsResult() = %#v, %v", batch, err)
+	}
+	if !reflect.DeepEqual(got.Results[2], resp.Results[2]) {
+		t.Errorf("error result = %#v, want %#v", got.Results[2], resp.Results[2])
+	}
+}
+
+func TestCursorEntryProtoRoundTrip(t *testing.T) {
+	entries := []CursorEntry{
+		{Type: "step_begin", Step: 1, Cols: []Column{{Name: toPtr("a"), Type: toPtr("TEXT")}}},
+		{Type: "row", Row: []Value{{Type: "text", Value: "x"}, {Type: "null"}}},
+		{Type: "step_end", AffectedRowCount: 2, LastInsertRowId: toPtr("-1")},
+		{Type: "step_error", Step: 2, Error: &Error{Message: "boom"}},
+		{Type: "error", Error: &Error{Message: "stream expired", Code: toPtr("STREAM_EXPIRED")}},
+	}
+	for _, entry := range entries {
+		data, err := entry.MarshalProto()
+		if err != nil {
+			t.Fatalf("MarshalProto() error = %v", err)
+		}
+		var got CursorEntry
+		if err := got.UnmarshalProto(data); err != nil {
+			t.Fatalf("UnmarshalProto() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, entry) {
+			t.Errorf("UnmarshalProto() = %#v, want %#v", got, entry)
+		}
+	}
+}
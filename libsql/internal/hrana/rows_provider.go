@@ -0,0 +1,120 @@
+package hrana
+
+import (
+	"database/sql/driver"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
+)
+
+type StmtResultRowsProvider struct {
+	r     *StmtResult
+	times shared.TimeEncoding
+}
+
+func NewStmtResultRowsProvider(r *StmtResult, times shared.TimeEncoding) *StmtResultRowsProvider {
+	return &StmtResultRowsProvider{r, times}
+}
+
+func (p *StmtResultRowsProvider) SetsCount() int {
+	return 1
+}
+
+func (p *StmtResultRowsProvider) RowsCount(setIdx int) int {
+	if setIdx != 0 {
+		return 0
+	}
+	return len(p.r.Rows)
+}
+
+func (p *StmtResultRowsProvider) Columns(setIdx int) []string {
+	if setIdx != 0 {
+		return nil
+	}
+	res := make([]string, len(p.r.Cols))
+	for i, c := range p.r.Cols {
+		if c.Name != nil {
+			res[i] = *c.Name
+		}
+	}
+	return res
+}
+
+func (p *StmtResultRowsProvider) ColumnTypes(setIdx int) []shared.ColumnType {
+	if setIdx != 0 {
+		return nil
+	}
+	return ColumnTypes(p.r.Cols, p.r.Rows, p.times)
+}
+
+func (p *StmtResultRowsProvider) FieldValue(setIdx, rowIdx, colIdx int) driver.Value {
+	if setIdx != 0 {
+		return nil
+	}
+	return p.r.Rows[rowIdx][colIdx].Decode(p.r.Cols[colIdx].Type, p.times)
+}
+
+func (p *StmtResultRowsProvider) Error(setIdx int) string {
+	return ""
+}
+
+func (p *StmtResultRowsProvider) HasResult(setIdx int) bool {
+	return setIdx == 0
+}
+
+type BatchResultRowsProvider struct {
+	r     *BatchResult
+	times shared.TimeEncoding
+}
+
+func NewBatchResultRowsProvider(r *BatchResult, times shared.TimeEncoding) *BatchResultRowsProvider {
+	return &BatchResultRowsProvider{r, times}
+}
+
+func (p *BatchResultRowsProvider) SetsCount() int {
+	return len(p.r.StepResults)
+}
+
+func (p *BatchResultRowsProvider) RowsCount(setIdx int) int {
+	if setIdx >= len(p.r.StepResults) || p.r.StepResults[setIdx] == nil {
+		return 0
+	}
+	return len(p.r.StepResults[setIdx].Rows)
+}
+
+func (p *BatchResultRowsProvider) Columns(setIdx int) []string {
+	if setIdx >= len(p.r.StepResults) || p.r.StepResults[setIdx] == nil {
+		return nil
+	}
+	res := make([]string, len(p.r.StepResults[setIdx].Cols))
+	for i, c := range p.r.StepResults[setIdx].Cols {
+		if c.Name != nil {
+			res[i] = *c.Name
+		}
+	}
+	return res
+}
+
+func (p *BatchResultRowsProvider) ColumnTypes(setIdx int) []shared.ColumnType {
+	if setIdx >= len(p.r.StepResults) || p.r.StepResults[setIdx] == nil {
+		return nil
+	}
+	return ColumnTypes(p.r.StepResults[setIdx].Cols, p.r.StepResults[setIdx].Rows, p.times)
+}
+
+func (p *BatchResultRowsProvider) FieldValue(setIdx, rowIdx, colIdx int) driver.Value {
+	if setIdx >= len(p.r.StepResults) || p.r.StepResults[setIdx] == nil {
+		return nil
+	}
+	return p.r.StepResults[setIdx].Rows[rowIdx][colIdx].Decode(p.r.StepResults[setIdx].Cols[colIdx].Type, p.times)
+}
+
+func (p *BatchResultRowsProvider) Error(setIdx int) string {
+	if setIdx >= len(p.r.StepErrors) || p.r.StepErrors[setIdx] == nil {
+		return ""
+	}
+	return p.r.StepErrors[setIdx].Message
+}
+
+func (p *BatchResultRowsProvider) HasResult(setIdx int) bool {
+	return setIdx < len(p.r.StepResults) && p.r.StepResults[setIdx] != nil
+}
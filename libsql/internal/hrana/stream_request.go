@@ -86,3 +86,11 @@ func StoreSqlStream(sql string, sqlId int32) StreamRequest {
 func CloseStoredSqlStream(sqlId int32) StreamRequest {
 	return StreamRequest{Type: "close_sql", SqlId: &sqlId}
 }
+
+func DescribeStream(sql string) StreamRequest {
+	return StreamRequest{Type: "describe", Sql: &sql}
+}
+
+func GetAutocommitStream() StreamRequest {
+	return StreamRequest{Type: "get_autocommit"}
+}
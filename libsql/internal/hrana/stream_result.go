@@ -15,12 +15,23 @@ type StreamResult struct {
 type StreamResponse struct {
 	Type   string          `json:"type"`
 	Result json.RawMessage `json:"result,omitempty"`
+	// IsAutocommit is only set in responses to get_autocommit requests.
+	IsAutocommit *bool `json:"is_autocommit,omitempty"`
+
+	// Results of responses decoded from protobuf, which are decoded eagerly instead of being kept
+	// as raw JSON in Result.
+	stmtResult     *StmtResult
+	batchResult    *BatchResult
+	describeResult *DescribeResult
 }
 
 func (r *StreamResponse) ExecuteResult() (*StmtResult, error) {
 	if r.Type != "execute" {
 		return nil, fmt.Errorf("invalid response type: %s", r.Type)
 	}
+	if r.stmtResult != nil {
+		return r.stmtResult, nil
+	}
 
 	var res StmtResult
 	if err := json.Unmarshal(r.Result, &res); err != nil {
@@ -29,23 +40,61 @@ func (r *StreamResponse) ExecuteResult() (*StmtResult, error) {
 	return &res, nil
 }
 
+// BatchResult returns the result of a batch, or the error of its first failed step.
 func (r *StreamResponse) BatchResult() (*BatchResult, error) {
+	res, err := r.BatchStepsResult()
+	if err != nil {
+		return nil, err
+	}
+	for idx := range res.StepErrors {
+		if err := res.StepError(idx); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// BatchStepsResult returns the result of a batch including the errors of its steps.
+func (r *StreamResponse) BatchStepsResult() (*BatchResult, error) {
 	if r.Type != "batch" {
 		return nil, fmt.Errorf("invalid response type: %s", r.Type)
 	}
+	if r.batchResult != nil {
+		return r.batchResult, nil
+	}
 
 	var res BatchResult
 	if err := json.Unmarshal(r.Result, &res); err != nil {
 		return nil, err
 	}
-	for _, e := range res.StepErrors {
-		if e != nil {
-			return nil, errors.New(e.Message)
-		}
+	return &res, nil
+}
+
+func (r *StreamResponse) DescribeResult() (*DescribeResult, error) {
+	if r.Type != "describe" {
+		return nil, fmt.Errorf("invalid response type: %s", r.Type)
+	}
+	if r.describeResult != nil {
+		return r.describeResult, nil
+	}
+
+	var res DescribeResult
+	if err := json.Unmarshal(r.Result, &res); err != nil {
+		return nil, err
 	}
 	return &res, nil
 }
 
+func (r *StreamResponse) AutocommitResult() (bool, error) {
+	if r.Type != "get_autocommit" {
+		return false, fmt.Errorf("invalid response type: %s", r.Type)
+	}
+	if r.IsAutocommit == nil {
+		return false, errors.New("missing is_autocommit in get_autocommit response")
+	}
+	return *r.IsAutocommit, nil
+}
+
 type Error struct {
 	Message string  `json:"message"`
 	Code    *string `json:"code,omitempty"`
@@ -4,8 +4,9 @@ import (
 	"encoding/base64"
 	"fmt"
 	"strconv"
-	"strings"
 	"time"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
 )
 
 type Value struct {
@@ -14,8 +15,27 @@ type Value struct {
 	Base64 *string `json:"base64,omitempty"`
 }
 
+// ToValue converts v to a Go value, reading times with the default TimeEncoding.
 func (v Value) ToValue(columnType *string) any {
+	return v.Decode(columnType, shared.TimeEncoding{})
+}
+
+// Decode converts v to a Go value. Values of time columns are read as times with times.
+func (v Value) Decode(columnType *string, times shared.TimeEncoding) any {
+	value := v.decode()
+	if columnType != nil && (v.Type == "text" || times.DecodesNumbers() && (v.Type == "integer" || v.Type == "float")) {
+		if t, ok := times.Decode(*columnType, value); ok {
+			return t
+		}
+	}
+	return value
+}
+
+func (v Value) decode() any {
 	if v.Type == "blob" {
+		if blob, ok := v.Value.([]byte); ok {
+			return blob
+		}
 		if v.Base64 == nil {
 			return nil
 		}
@@ -25,31 +45,15 @@ func (v Value) ToValue(columnType *string) any {
 		}
 		return bytes
 	} else if v.Type == "integer" {
+		if integer, ok := v.Value.(int64); ok {
+			return integer
+		}
 		integer, err := strconv.ParseInt(v.Value.(string), 10, 64)
 		if err != nil {
 			return nil
 		}
 		return integer
-	} else if columnType != nil {
-		if (strings.ToLower(*columnType) == "timestamp" || strings.ToLower(*columnType) == "datetime") && v.Type == "text" {
-			for _, format := range []string{
-				"2006-01-02 15:04:05.999999999-07:00",
-				"2006-01-02T15:04:05.999999999-07:00",
-				"2006-01-02 15:04:05.999999999",
-				"2006-01-02T15:04:05.999999999",
-				"2006-01-02 15:04:05",
-				"2006-01-02T15:04:05",
-				"2006-01-02 15:04",
-				"2006-01-02T15:04",
-				"2006-01-02",
-			} {
-				if t, err := time.ParseInLocation(format, v.Value.(string), time.UTC); err == nil {
-					return t
-				}
-			}
-		}
 	}
-
 	return v.Value
 }
 
@@ -83,7 +87,7 @@ func ToValue(v any) (Value, error) {
 			res.Value = "1"
 		}
 	} else {
-		return res, fmt.Errorf("unsupported value type: %s", v)
+		return res, fmt.Errorf("unsupported value type: %T", v)
 	}
 	return res, nil
 }
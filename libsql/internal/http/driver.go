@@ -6,6 +6,16 @@ import (
 	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/hranaV2"
 )
 
-func Connect(url, jwt, host string, schemaDb bool) driver.Conn {
-	return hranaV2.Connect(url, jwt, host, schemaDb)
+// EndpointCache lets the connections of a connector share the endpoint negotiated with the server.
+type EndpointCache = hranaV2.EndpointCache
+
+// Config configures the connections returned by Connect.
+type Config = hranaV2.Config
+
+func Connect(config Config) driver.Conn {
+	return hranaV2.Connect(config)
+}
+
+func IsTransient(err error) bool {
+	return hranaV2.IsTransient(err)
 }
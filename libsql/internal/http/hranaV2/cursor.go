@@ -0,0 +1,329 @@
+package hranaV2
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/hrana"
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
+)
+
+func (h *hranaV2Conn) openCursor(ctx context.Context, batch *hrana.Batch, stepsCount int) (*cursorRows, error) {
+	var rows *cursorRows
+	err := h.retry(ctx, isReadOnlyBatch(batch), func() (err error) {
+		rows, err = h.sendCursorRequest(ctx, batch, stepsCount)
+		return err
+	})
+	return rows, err
+}
+
+func (h *hranaV2Conn) sendCursorRequest(ctx context.Context, batch *hrana.Batch, stepsCount int) (*cursorRows, error) {
+	if err := h.prepareStream(ctx); err != nil {
+		return nil, err
+	}
+	h.beginRequest()
+	if h.replicationIndex > 0 && batch.ReplicationIndex == nil {
+		batch.ReplicationIndex = &h.replicationIndex
+	}
+	req := hrana.CursorRequest{Baton: h.baton, Batch: batch}
+	var reqBody []byte
+	var err error
+	if h.endpoint.protobuf {
+		reqBody, err = req.MarshalProto()
+	} else {
+		reqBody, err = json.Marshal(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp, err := sendRequest(ctx, http.MethodPost, h.url, h.endpoint.cursorPath, reqBody, h.endpoint.contentType(), h.token, h.host, h.client)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		// We need to remember that the stream is closed so we don't try to send any more requests using this connection.
+		h.streamClosed = true
+		return nil, errorFromResponse(resp)
+	}
+	var decoder cursorDecoder
+	if h.endpoint.protobuf {
+		decoder = &protoCursorDecoder{bufio.NewReader(resp.Body)}
+	} else {
+		decoder = &jsonCursorDecoder{json.NewDecoder(resp.Body)}
+	}
+	var cursorResp hrana.CursorResponse
+	if err := decoder.decodeResponse(&cursorResp); err != nil {
+		resp.Body.Close()
+		h.streamClosed = true
+		return nil, err
+	}
+	h.baton = cursorResp.Baton
+	if cursorResp.Baton == "" {
+		// We need to remember that the stream is closed so we don't try to send any more requests using this connection.
+		h.streamClosed = true
+	}
+	if cursorResp.BaseUrl != "" {
+		h.url = cursorResp.BaseUrl
+	}
+	h.cursor = &cursorRows{conn: h, body: resp.Body, decoder: decoder, stepsCount: stepsCount, stepDone: true}
+	return h.cursor, nil
+}
+
+// cursorDecoder decodes the messages of a cursor response body. The body starts with a single
+// CursorResponse followed by the entries of the cursor.
+type cursorDecoder interface {
+	decodeResponse(resp *hrana.CursorResponse) error
+	decodeEntry(entry *hrana.CursorEntry) error
+}
+
+// jsonCursorDecoder decodes cursors sent as newline-delimited JSON.
+type jsonCursorDecoder struct {
+	decoder *json.Decoder
+}
+
+func (d *jsonCursorDecoder) decodeResponse(resp *hrana.CursorResponse) error {
+	return d.decoder.Decode(resp)
+}
+
+func (d *jsonCursorDecoder) decodeEntry(entry *hrana.CursorEntry) error {
+	return d.decoder.Decode(entry)
+}
+
+// protoCursorDecoder decodes cursors sent as length-delimited protobuf messages.
+type protoCursorDecoder struct {
+	reader *bufio.Reader
+}
+
+func (d *protoCursorDecoder) decodeResponse(resp *hrana.CursorResponse) error {
+	data, err := hrana.ReadProtoMessage(d.reader)
+	if err != nil {
+		return err
+	}
+	return resp.UnmarshalProto(data)
+}
+
+func (d *protoCursorDecoder) decodeEntry(entry *hrana.CursorEntry) error {
+	data, err := hrana.ReadProtoMessage(d.reader)
+	if err != nil {
+		return err
+	}
+	return entry.UnmarshalProto(data)
+}
+
+// cursorRows reads the results of a batch from a cursor. Entries are decoded from the response body
+// only when they are needed, so rows are never buffered unless the stream has to be freed for
+// another request while the rows are still open.
+type cursorRows struct {
+	conn    *hranaV2Conn
+	body    io.ReadCloser
+	decoder cursorDecoder
+	// entries holds entries that were read ahead of the caller.
+	entries []hrana.CursorEntry
+	// err is the error that ended the reading of entries from the body.
+	err        error
+	stepsCount int
+	step       int
+	stepDone   bool
+	cols       []hrana.Column
+	// colTypes caches the types of cols.
+	colTypes []shared.ColumnType
+}
+
+func (r *cursorRows) nextEntry() (*hrana.CursorEntry, error) {
+	if len(r.entries) > 0 {
+		entry := r.entries[0]
+		r.entries = r.entries[1:]
+		return &entry, nil
+	}
+	if r.body == nil {
+		if r.err != nil {
+			return nil, r.err
+		}
+		return nil, io.EOF
+	}
+	var entry hrana.CursorEntry
+	if err := r.decoder.decodeEntry(&entry); err != nil {
+		if err != io.EOF {
+			r.err = err
+		}
+		r.release()
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// drain reads all remaining entries into memory and releases the stream.
+func (r *cursorRows) drain() {
+	for r.body != nil {
+		var entry hrana.CursorEntry
+		if err := r.decoder.decodeEntry(&entry); err != nil {
+			if err != io.EOF {
+				r.err = err
+			}
+			r.release()
+			return
+		}
+		r.entries = append(r.entries, entry)
+	}
+}
+
+// release closes the response body. If the server did not finish sending the cursor yet, this
+// abandons the rest of it.
+func (r *cursorRows) release() {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	if r.conn.cursor == r {
+		r.conn.cursor = nil
+	}
+}
+
+// beginStep reads entries up to the beginning of the current step.
+func (r *cursorRows) beginStep() error {
+	r.cols = nil
+	r.colTypes = nil
+	r.stepDone = true
+	for {
+		entry, err := r.nextEntry()
+		if err == io.EOF {
+			return fmt.Errorf("no results for statement")
+		}
+		if err != nil {
+			return err
+		}
+		switch entry.Type {
+		case "step_begin", "step_error":
+			if int(entry.Step) > r.step {
+				// The step was skipped, so keep the entry for the step it belongs to.
+				r.entries = append([]hrana.CursorEntry{*entry}, r.entries...)
+				return fmt.Errorf("no results for statement")
+			}
+			if int(entry.Step) < r.step {
+				continue
+			}
+			if entry.Type == "step_error" {
+				return entryError(entry)
+			}
+			r.cols = entry.Cols
+			r.stepDone = false
+			return nil
+		case "error":
+			return entryError(entry)
+		}
+	}
+}
+
+func entryError(entry *hrana.CursorEntry) error {
+	err := hrana.NewServerError(entry.Error)
+	if entry.Type == "step_error" {
+		err.StmtIndex = int(entry.Step)
+	}
+	return err
+}
+
+func (r *cursorRows) Columns() []string {
+	res := make([]string, len(r.cols))
+	for i, c := range r.cols {
+		if c.Name != nil {
+			res[i] = *c.Name
+		}
+	}
+	return res
+}
+
+// columnType describes a column of the current step. A cursor streams its rows, so unlike for
+// buffered results the values can't be looked at: the scan type follows the declared type of the
+// column, which is any for columns without one, and whether the column is nullable is unknown.
+func (r *cursorRows) columnType(index int) shared.ColumnType {
+	if r.colTypes == nil {
+		r.colTypes = hrana.ColumnTypes(r.cols, nil, r.conn.codec.Time)
+	}
+	if index >= len(r.colTypes) {
+		return shared.ColumnType{ScanType: shared.AnyType}
+	}
+	return r.colTypes[index]
+}
+
+func (r *cursorRows) ColumnTypeDatabaseTypeName(index int) string {
+	return r.columnType(index).DatabaseTypeName
+}
+
+func (r *cursorRows) ColumnTypeScanType(index int) reflect.Type {
+	return r.columnType(index).ScanType
+}
+
+func (r *cursorRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	ct := r.columnType(index)
+	return ct.Nullable, ct.NullableOk
+}
+
+func (r *cursorRows) ColumnTypeLength(index int) (length int64, ok bool) {
+	ct := r.columnType(index)
+	return ct.Length, ct.LengthOk
+}
+
+func (r *cursorRows) Close() error {
+	if r.body != nil && r.HasNextResultSet() {
+		// Later statements of the batch must not be cancelled, so read the cursor to its end.
+		for r.body != nil {
+			if _, err := r.nextEntry(); err != nil {
+				break
+			}
+		}
+	}
+	r.release()
+	r.entries = nil
+	return nil
+}
+
+func (r *cursorRows) Next(dest []driver.Value) error {
+	for !r.stepDone {
+		entry, err := r.nextEntry()
+		if err == io.EOF {
+			r.stepDone = true
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		switch entry.Type {
+		case "row":
+			if len(entry.Row) != len(r.cols) {
+				return fmt.Errorf("row has %d values, expected %d", len(entry.Row), len(r.cols))
+			}
+			for idx := range dest {
+				dest[idx] = entry.Row[idx].Decode(r.cols[idx].Type, r.conn.codec.Time)
+			}
+			return nil
+		case "step_end":
+			r.stepDone = true
+		case "step_error", "error":
+			r.stepDone = true
+			return entryError(entry)
+		}
+	}
+	return io.EOF
+}
+
+func (r *cursorRows) HasNextResultSet() bool {
+	return r.step < r.stepsCount-1
+}
+
+func (r *cursorRows) NextResultSet() error {
+	if !r.HasNextResultSet() {
+		return io.EOF
+	}
+	r.step++
+	if err := r.beginStep(); err != nil {
+		return fmt.Errorf("failed to execute statement\n%w", err)
+	}
+	return nil
+}
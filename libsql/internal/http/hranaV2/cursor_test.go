@@ -0,0 +1,125 @@
+package hranaV2
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
+)
+
+func newTestCursor(body string, stepsCount int) *cursorRows {
+	conn := &hranaV2Conn{}
+	reader := io.NopCloser(strings.NewReader(body))
+	conn.cursor = &cursorRows{conn: conn, body: reader, decoder: &jsonCursorDecoder{json.NewDecoder(reader)}, stepsCount: stepsCount, stepDone: true}
+	return conn.cursor
+}
+
+func readAll(t *testing.T, rows *cursorRows) [][]driver.Value {
+	var res [][]driver.Value
+	for {
+		dest := make([]driver.Value, len(rows.Columns()))
+		err := rows.Next(dest)
+		if err == io.EOF {
+			return res
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		res = append(res, dest)
+	}
+}
+
+const testCursorBody = `{"type":"step_begin","step":0,"cols":[{"name":"a","decltype":"INTEGER"}]}
+{"type":"row","row":[{"type":"integer","value":"1"}]}
+{"type":"row","row":[{"type":"integer","value":"2"}]}
+{"type":"step_end","affected_row_count":0,"last_insert_rowid":null}
+{"type":"step_begin","step":1,"cols":[{"name":"b","decltype":null},{"name":"c","decltype":null}]}
+{"type":"row","row":[{"type":"text","value":"foo"},{"type":"null"}]}
+{"type":"step_end","affected_row_count":0,"last_insert_rowid":null}
+{"type":"step_error","step":2,"error":{"message":"no such table: t"}}
+`
+
+func TestCursorRows(t *testing.T) {
+	rows := newTestCursor(testCursorBody, 4)
+	if err := rows.beginStep(); err != nil {
+		t.Fatalf("beginStep() error = %v", err)
+	}
+	if got := rows.Columns(); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("Columns() = %v", got)
+	}
+	if got := readAll(t, rows); !reflect.DeepEqual(got, [][]driver.Value{{int64(1)}, {int64(2)}}) {
+		t.Errorf("rows = %v", got)
+	}
+	if err := rows.NextResultSet(); err != nil {
+		t.Fatalf("NextResultSet() error = %v", err)
+	}
+	if got := rows.Columns(); !reflect.DeepEqual(got, []string{"b", "c"}) {
+		t.Errorf("Columns() = %v", got)
+	}
+	if got := readAll(t, rows); !reflect.DeepEqual(got, [][]driver.Value{{"foo", nil}}) {
+		t.Errorf("rows = %v", got)
+	}
+	if err := rows.NextResultSet(); err == nil || !strings.Contains(err.Error(), "no such table: t") {
+		t.Errorf("NextResultSet() error = %v, want step error", err)
+	}
+	if err := rows.NextResultSet(); err == nil || !strings.Contains(err.Error(), "no results") {
+		t.Errorf("NextResultSet() error = %v, want skipped step", err)
+	}
+	if rows.HasNextResultSet() {
+		t.Errorf("HasNextResultSet() = true after last step")
+	}
+	if rows.conn.cursor != nil {
+		t.Errorf("cursor was not released after reading all entries")
+	}
+}
+
+func TestCursorRowsDrain(t *testing.T) {
+	rows := newTestCursor(testCursorBody, 2)
+	if err := rows.beginStep(); err != nil {
+		t.Fatalf("beginStep() error = %v", err)
+	}
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+	rows.drain()
+	if rows.body != nil || rows.conn.cursor != nil {
+		t.Fatalf("drain() did not release the stream")
+	}
+	if got := readAll(t, rows); !reflect.DeepEqual(got, [][]driver.Value{{int64(2)}}) {
+		t.Errorf("rows after drain = %v", got)
+	}
+	if err := rows.NextResultSet(); err != nil {
+		t.Fatalf("NextResultSet() error = %v", err)
+	}
+	if got := readAll(t, rows); !reflect.DeepEqual(got, [][]driver.Value{{"foo", nil}}) {
+		t.Errorf("rows after drain = %v", got)
+	}
+}
+
+func TestCursorRowsColumnTypes(t *testing.T) {
+	rows := newTestCursor(testCursorBody, 2)
+	if err := rows.beginStep(); err != nil {
+		t.Fatalf("beginStep() error = %v", err)
+	}
+	if got := rows.ColumnTypeDatabaseTypeName(0); got != "INTEGER" {
+		t.Errorf("ColumnTypeDatabaseTypeName(0) = %q, want INTEGER", got)
+	}
+	if err := rows.NextResultSet(); err != nil {
+		t.Fatalf("NextResultSet() error = %v", err)
+	}
+	// The columns have no declared type, and the rows of a cursor are not looked at.
+	if got := rows.ColumnTypeScanType(0); got != shared.AnyType {
+		t.Errorf("ColumnTypeScanType(0) = %v, want any", got)
+	}
+	if nullable, ok := rows.ColumnTypeNullable(1); nullable || ok {
+		t.Errorf("ColumnTypeNullable(1) = %v, %v, want false, false", nullable, ok)
+	}
+	if got := readAll(t, rows); !reflect.DeepEqual(got, [][]driver.Value{{"foo", nil}}) {
+		t.Errorf("rows = %v", got)
+	}
+}
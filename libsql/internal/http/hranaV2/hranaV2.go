@@ -3,7 +3,6 @@ package hranaV2
 import (
 	"bytes"
 	"context"
-	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
 	"errors"
@@ -14,6 +13,7 @@ import (
 	net_url "net/url"
 	"runtime/debug"
 	"strings"
+	"sync"
 
 	"github.com/tursodatabase/libsql-client-go/libsql/internal/hrana"
 	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
@@ -36,17 +36,116 @@ func init() {
 	commitHash = "unknown"
 }
 
-func Connect(url, jwt, host string, schemaDb bool) driver.Conn {
-	return &hranaV2Conn{url, jwt, host, schemaDb, "", false, 0}
+// endpoint describes how requests are sent to a Hrana server over HTTP. Servers that speak Hrana 3
+// answer GET requests to versionPath and additionally offer the cursor endpoint, which streams the
+// rows of a batch instead of returning them all in a single response.
+type endpoint struct {
+	protobuf     bool
+	versionPath  string
+	pipelinePath string
+	cursorPath   string
 }
 
+var (
+	v2Endpoint         = &endpoint{pipelinePath: "/v2/pipeline"}
+	v3Endpoint         = &endpoint{versionPath: "/v3", pipelinePath: "/v3/pipeline", cursorPath: "/v3/cursor"}
+	v3ProtobufEndpoint = &endpoint{protobuf: true, versionPath: "/v3-protobuf", pipelinePath: "/v3-protobuf/pipeline", cursorPath: "/v3-protobuf/cursor"}
+)
+
+const protobufContentType = "application/x-protobuf"
+
+func (e *endpoint) contentType() string {
+	if e.protobuf {
+		return protobufContentType
+	}
+	return "application/json"
+}
+
+func (e *endpoint) marshalPipelineRequest(msg *hrana.PipelineRequest) ([]byte, error) {
+	if e.protobuf {
+		return msg.MarshalProto()
+	}
+	return json.Marshal(msg)
+}
+
+func (e *endpoint) unmarshalPipelineResponse(body []byte, msg *hrana.PipelineRequest) (result hrana.PipelineResponse, err error) {
+	if e.protobuf {
+		err = result.UnmarshalProto(body, msg)
+	} else {
+		err = json.Unmarshal(body, &result)
+	}
+	return result, err
+}
+
+// EndpointCache remembers the endpoint negotiated with a server, so that the connections that share
+// it probe the server only once. The zero value is ready to use.
+type EndpointCache struct {
+	mu       sync.Mutex
+	endpoint *endpoint
+}
+
+// Config configures the connections returned by Connect.
+type Config struct {
+	// URL is the URL of the server.
+	URL string
+	// Token provides the auth tokens sent with the requests.
+	Token shared.TokenProvider
+	// Host is sent as the Host header.
+	Host string
+	// SchemaDb makes the connection target a schema database.
+	SchemaDb bool
+	// Protobuf requests the protobuf encoding if the server supports it.
+	Protobuf bool
+	// Client sends the requests. It defaults to http.DefaultClient.
+	Client *http.Client
+	// RetryPolicy decides which failed requests are sent again.
+	RetryPolicy shared.RetryPolicy
+	// Describe makes PrepareContext describe statements.
+	Describe bool
+	// Codec converts arguments and results.
+	Codec shared.Codec
+	// Endpoints caches the endpoint negotiated with the server. Connections that share it negotiate
+	// the endpoint only once. It can be nil.
+	Endpoints *EndpointCache
+}
+
+// Connect returns a connection to the server configured by config.
+func Connect(config Config) driver.Conn {
+	client := config.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &hranaV2Conn{
+		url:         config.URL,
+		token:       config.Token,
+		host:        config.Host,
+		schemaDb:    config.SchemaDb,
+		protobuf:    config.Protobuf,
+		client:      client,
+		retryPolicy: config.RetryPolicy,
+		describe:    config.Describe,
+		codec:       config.Codec,
+		endpoints:   config.Endpoints,
+	}
+}
+
+// hranaV2Stmt is a prepared statement. Its SQL is stored on the stream with store_sql and then
+// executed by id, so it is sent only once per stream.
 type hranaV2Stmt struct {
 	conn     *hranaV2Conn
 	numInput int
 	sql      string
+	sqlId    int32
+	// readOnly is true if sql only reads. The requests that execute the statement by its id don't
+	// carry its SQL, so it is classified when it is prepared.
+	readOnly bool
+	// streamGeneration is the generation of the stream the SQL is stored on, or 0 if it has not been
+	// stored yet.
+	streamGeneration uint64
 }
 
 func (s *hranaV2Stmt) Close() error {
+	s.conn.releaseSql(s)
 	return nil
 }
 
@@ -74,21 +173,64 @@ func (s *hranaV2Stmt) Query(args []driver.Value) (driver.Rows, error) {
 }
 
 func (s *hranaV2Stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
-	return s.conn.ExecContext(ctx, s.sql, args)
+	res, err := s.conn.executeStored(ctx, s, args, false)
+	if err != nil {
+		return nil, err
+	}
+	return shared.NewResult(res.GetLastInsertRowId(), int64(res.AffectedRowCount)), nil
 }
 
 func (s *hranaV2Stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
-	return s.conn.QueryContext(ctx, s.sql, args)
+	if err := s.conn.prepareStream(ctx); err != nil {
+		return nil, err
+	}
+	if s.conn.endpoint.cursorPath != "" {
+		return s.conn.queryStoredCursor(ctx, s, args)
+	}
+	res, err := s.conn.executeStored(ctx, s, args, true)
+	if err != nil {
+		return nil, err
+	}
+	return shared.NewRows(hrana.NewStmtResultRowsProvider(res, s.conn.codec.Time)), nil
 }
 
 type hranaV2Conn struct {
 	url              string
-	jwt              string
+	token            shared.TokenProvider
 	host             string
+	client           *http.Client
 	schemaDb         bool
 	baton            string
 	streamClosed     bool
 	replicationIndex uint64
+	// protobuf requests the protobuf encoding if the server supports it.
+	protobuf bool
+	// endpoint is the endpoint used to talk to the server, nil until it is negotiated.
+	endpoint *endpoint
+	// cursor holds the rows of a cursor that are still being read from the stream.
+	cursor *cursorRows
+	// endpoints caches the negotiated endpoint for all connections of a connector. It is nil if the
+	// connection doesn't share it.
+	endpoints *EndpointCache
+	// retryPolicy decides which failed requests are sent again.
+	retryPolicy shared.RetryPolicy
+	// describe makes PrepareContext describe statements, so that their parameters are counted and
+	// their SQL is checked by the server.
+	describe bool
+	// codec converts arguments and results.
+	codec shared.Codec
+	// streamReadOnly is true while all requests sent on the current stream were reads, which makes
+	// it safe to abandon the stream and replay a failed request on a new one.
+	streamReadOnly bool
+	// streamGeneration is incremented whenever a request opens a new stream. SQL stored with
+	// store_sql belongs to the stream it was stored on.
+	streamGeneration uint64
+	// nextSqlId and freeSqlIds hand out the ids of prepared statements.
+	nextSqlId  int32
+	freeSqlIds []int32
+	// sqlClosesPending holds ids of SQL that was released while stored on the current stream. The
+	// close_sql requests are sent together with the next request.
+	sqlClosesPending []int32
 }
 
 func (h *hranaV2Conn) Ping() error {
@@ -100,6 +242,21 @@ func (h *hranaV2Conn) PingContext(ctx context.Context) error {
 	return err
 }
 
+func (h *hranaV2Conn) CheckNamedValue(nv *driver.NamedValue) error {
+	return h.codec.CheckNamedValue(nv)
+}
+
+// TimeEncoding returns how the connection writes and reads times.
+func (h *hranaV2Conn) TimeEncoding() shared.TimeEncoding {
+	return h.codec.Time
+}
+
+// SchemaDb reports whether the connection targets a schema database, whose changes are applied to
+// all databases that use the schema.
+func (h *hranaV2Conn) SchemaDb() bool {
+	return h.schemaDb
+}
+
 func (h *hranaV2Conn) Prepare(query string) (driver.Stmt, error) {
 	return h.PrepareContext(context.Background(), query)
 }
@@ -116,16 +273,26 @@ func (h *hranaV2Conn) PrepareContext(ctx context.Context, query string) (driver.
 	if len(paramInfos[0].NamedParameters) == 0 {
 		numInput = paramInfos[0].PositionalParametersCount
 	}
-	return &hranaV2Stmt{h, numInput, query}, nil
+	if h.describe {
+		desc, err := h.Describe(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		numInput = len(desc.Params)
+	}
+	return &hranaV2Stmt{conn: h, numInput: numInput, sql: query, sqlId: h.allocSqlId(), readOnly: isReadOnlySql(query)}, nil
 }
 
 func (h *hranaV2Conn) Close() error {
+	if h.cursor != nil {
+		h.cursor.Close()
+	}
 	if h.baton != "" {
-		go func(baton, url, jwt, host string) {
+		go func(baton, url string, endpoint *endpoint, token shared.TokenProvider, host string, client *http.Client) {
 			msg := hrana.PipelineRequest{Baton: baton}
 			msg.Add(hrana.CloseStream())
-			_, _, _ = sendPipelineRequest(context.Background(), &msg, url, jwt, host)
-		}(h.baton, h.url, h.jwt, h.host)
+			_, _, _ = sendPipelineRequest(context.Background(), &msg, url, endpoint, token, host, client)
+		}(h.baton, h.url, h.endpoint, h.token, h.host, h.client)
 	}
 	return nil
 }
@@ -149,37 +316,41 @@ func (h hranaV2Tx) Rollback() error {
 }
 
 func (h *hranaV2Conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
-	if opts.ReadOnly {
-		return nil, fmt.Errorf("read only transactions are not supported")
-	}
-	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) {
-		return nil, fmt.Errorf("isolation level %d is not supported", opts.Isolation)
-	}
-	_, err := h.ExecContext(ctx, "BEGIN", nil)
+	begin, err := shared.BeginStatement(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
+	if _, err := h.ExecContext(ctx, begin, nil); err != nil {
+		return nil, err
+	}
 	return &hranaV2Tx{h}, nil
 }
 
 func (h *hranaV2Conn) sendPipelineRequest(ctx context.Context, msg *hrana.PipelineRequest, streamClose bool) (*hrana.PipelineResponse, error) {
-	if h.streamClosed {
-		// If the stream is closed, we can't send any more requests using this connection.
-		return nil, fmt.Errorf("stream is closed: %w", driver.ErrBadConn)
-	}
-	if h.baton != "" {
-		msg.Baton = h.baton
+	if err := h.prepareStream(ctx); err != nil {
+		return nil, err
 	}
+	h.beginRequest()
+	msg.Baton = h.baton
 	if h.replicationIndex > 0 {
 		addReplicationIndex(msg, h.replicationIndex)
 	}
-	result, streamClosed, err := sendPipelineRequest(ctx, msg, h.url, h.jwt, h.host)
+	closes := len(h.sqlClosesPending)
+	if closes > 0 {
+		msg = withSqlCloses(msg, h.sqlClosesPending)
+	}
+	result, streamClosed, err := sendPipelineRequest(ctx, msg, h.url, h.endpoint, h.token, h.host, h.client)
 	if streamClosed {
 		h.streamClosed = true
 	}
 	if err != nil {
 		return nil, err
 	}
+	h.sqlClosesPending = nil
+	if len(result.Results) >= closes {
+		// Errors of the close_sql requests don't matter, the SQL is gone either way.
+		result.Results = result.Results[closes:]
+	}
 	h.baton = result.Baton
 	if result.Baton == "" && !streamClose {
 		// We need to remember that the stream is closed so we don't try to send any more requests using this connection.
@@ -194,6 +365,66 @@ func (h *hranaV2Conn) sendPipelineRequest(ctx context.Context, msg *hrana.Pipeli
 	return &result, nil
 }
 
+// prepareStream makes sure the stream can take another request: the endpoint is known and no
+// cursor is occupying the stream anymore.
+func (h *hranaV2Conn) prepareStream(ctx context.Context) error {
+	if h.streamClosed {
+		// If the stream is closed, we can't send any more requests using this connection.
+		return fmt.Errorf("stream is closed: %w", driver.ErrBadConn)
+	}
+	if h.cursor != nil {
+		// The server handles requests of a stream one at a time, so the rest of the cursor has to be
+		// read before the stream is free again.
+		h.cursor.drain()
+	}
+	if h.endpoint == nil {
+		return h.negotiateEndpoint(ctx)
+	}
+	return nil
+}
+
+// negotiateEndpoint picks the best endpoint the server advertises. Older servers only speak Hrana 2,
+// which has no version endpoint to probe. The endpoint is taken from h.endpoints if another
+// connection negotiated it already; a failed negotiation is not cached, so the next connection
+// tries again.
+func (h *hranaV2Conn) negotiateEndpoint(ctx context.Context) error {
+	if h.endpoints == nil {
+		return h.probeEndpoint(ctx)
+	}
+	h.endpoints.mu.Lock()
+	defer h.endpoints.mu.Unlock()
+	if h.endpoints.endpoint != nil {
+		h.endpoint = h.endpoints.endpoint
+		return nil
+	}
+	if err := h.probeEndpoint(ctx); err != nil {
+		return err
+	}
+	h.endpoints.endpoint = h.endpoint
+	return nil
+}
+
+func (h *hranaV2Conn) probeEndpoint(ctx context.Context) error {
+	candidates := []*endpoint{v3Endpoint}
+	if h.protobuf {
+		candidates = []*endpoint{v3ProtobufEndpoint, v3Endpoint}
+	}
+	for _, candidate := range candidates {
+		resp, err := sendRequest(ctx, http.MethodGet, h.url, candidate.versionPath, nil, "", h.token, h.host, h.client)
+		if err != nil {
+			return err
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			h.endpoint = candidate
+			return nil
+		}
+	}
+	h.endpoint = v2Endpoint
+	return nil
+}
+
 func addReplicationIndex(msg *hrana.PipelineRequest, replicationIndex uint64) {
 	for i := range msg.Requests {
 		if msg.Requests[i].Stmt != nil && msg.Requests[i].Stmt.ReplicationIndex == nil {
@@ -230,69 +461,124 @@ func getReplicationIndex(response *hrana.PipelineResponse) uint64 {
 	return replicationIndex
 }
 
-func sendPipelineRequest(ctx context.Context, msg *hrana.PipelineRequest, url string, jwt string, host string) (result hrana.PipelineResponse, streamClosed bool, err error) {
-	reqBody, err := json.Marshal(msg)
+func sendPipelineRequest(ctx context.Context, msg *hrana.PipelineRequest, url string, endpoint *endpoint, token shared.TokenProvider, host string, client *http.Client) (result hrana.PipelineResponse, streamClosed bool, err error) {
+	reqBody, err := endpoint.marshalPipelineRequest(msg)
 	if err != nil {
 		return hrana.PipelineResponse{}, false, err
 	}
-	pipelineURL, err := net_url.JoinPath(url, "/v2/pipeline")
+	resp, err := sendRequest(ctx, http.MethodPost, url, endpoint.pipelinePath, reqBody, endpoint.contentType(), token, host, client)
 	if err != nil {
 		return hrana.PipelineResponse{}, false, err
 	}
-	req, err := http.NewRequestWithContext(ctx, "POST", pipelineURL, bytes.NewReader(reqBody))
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// We need to remember that the stream is closed so we don't try to send any more requests using this connection.
+		return hrana.PipelineResponse{}, true, errorFromResponse(resp)
+	}
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return hrana.PipelineResponse{}, false, err
 	}
+	if result, err = endpoint.unmarshalPipelineResponse(body, msg); err != nil {
+		return hrana.PipelineResponse{}, false, err
+	}
+	return result, false, nil
+}
+
+// sendRequest sends a request authenticated with a token from token. If the server rejects the token,
+// a refreshed one is requested and the request is retried once with it.
+func sendRequest(ctx context.Context, method string, url string, path string, body []byte, contentType string, token shared.TokenProvider, host string, client *http.Client) (*http.Response, error) {
+	jwt, err := token(ctx, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get auth token: %w", err)
+	}
+	resp, err := doRequest(ctx, method, url, path, body, contentType, jwt, host, client)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	refreshed, err := token(ctx, true)
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to refresh auth token: %w", err)
+	}
+	if refreshed == jwt {
+		// Retrying with the same token would be rejected again.
+		return resp, nil
+	}
+	resp.Body.Close()
+	return doRequest(ctx, method, url, path, body, contentType, refreshed, host, client)
+}
+
+func doRequest(ctx context.Context, method string, url string, path string, body []byte, contentType string, jwt string, host string, client *http.Client) (*http.Response, error) {
+	reqURL, err := net_url.JoinPath(url, path)
+	if err != nil {
+		return nil, err
+	}
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, reqBody)
+	if err != nil {
+		return nil, err
+	}
 	if len(jwt) > 0 {
 		req.Header.Set("Authorization", "Bearer "+jwt)
 	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
 	req.Header.Set("x-libsql-client-version", "libsql-remote-go-"+commitHash)
 	req.Host = host
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return hrana.PipelineResponse{}, false, err
-	}
-	defer resp.Body.Close()
+	return client.Do(req)
+}
+
+func errorFromResponse(resp *http.Response) error {
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return hrana.PipelineResponse{}, false, err
+		return err
 	}
-	if resp.StatusCode != http.StatusOK {
-		// We need to remember that the stream is closed so we don't try to send any more requests using this connection.
-		var serverError struct {
-			Error string `json:"error"`
-		}
-		if err := json.Unmarshal(body, &serverError); err == nil {
-			return hrana.PipelineResponse{}, true, fmt.Errorf("error code %d: %s", resp.StatusCode, serverError.Error)
-		}
-		var errResponse hrana.Error
-		if err := json.Unmarshal(body, &errResponse); err == nil {
-			if errResponse.Code != nil {
-				if *errResponse.Code == "STREAM_EXPIRED" {
-					return hrana.PipelineResponse{}, true, fmt.Errorf("error code %s: %s\n%w", *errResponse.Code, errResponse.Message, driver.ErrBadConn)
-				} else {
-					return hrana.PipelineResponse{}, true, fmt.Errorf("error code %s: %s", *errResponse.Code, errResponse.Message)
-				}
-			}
-			return hrana.PipelineResponse{}, true, errors.New(errResponse.Message)
-		}
-		return hrana.PipelineResponse{}, true, fmt.Errorf("error code %d: %s", resp.StatusCode, string(body))
+	// Servers report errors either as {"error": ...} or as a Hrana error with a message and a code.
+	var serverError struct {
+		Error   string  `json:"error"`
+		Message string  `json:"message"`
+		Code    *string `json:"code"`
 	}
-	if err = json.Unmarshal(body, &result); err != nil {
-		return hrana.PipelineResponse{}, false, err
+	if err := json.Unmarshal(body, &serverError); err != nil || (serverError.Error == "" && serverError.Message == "") {
+		return hrana.NewHTTPError(resp.StatusCode, string(body), "")
 	}
-	return result, false, nil
+	message := serverError.Error
+	if message == "" {
+		message = serverError.Message
+	}
+	code := ""
+	if serverError.Code != nil {
+		code = *serverError.Code
+	}
+	return hrana.NewHTTPError(resp.StatusCode, message, code)
 }
 
 func (h *hranaV2Conn) executeMsg(ctx context.Context, msg *hrana.PipelineRequest) (*hrana.PipelineResponse, error) {
-	result, err := h.sendPipelineRequest(ctx, msg, false)
+	var result *hrana.PipelineResponse
+	err := h.retry(ctx, isReadOnlyPipeline(msg), func() (err error) {
+		result, err = h.sendMsg(ctx, msg)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
+	return result, nil
+}
 
+// sendMsg sends msg once and fails if any of its requests failed.
+func (h *hranaV2Conn) sendMsg(ctx context.Context, msg *hrana.PipelineRequest) (*hrana.PipelineResponse, error) {
+	result, err := h.sendPipelineRequest(ctx, msg, false)
+	if err != nil {
+		return nil, err
+	}
 	for _, r := range result.Results {
 		if r.Error != nil {
-			return nil, errors.New(r.Error.Message)
+			return nil, hrana.NewServerError(r.Error)
 		}
 		if r.Response == nil {
 			return nil, errors.New("no response received")
@@ -311,23 +597,13 @@ func newChunker(iterator *sqliteparserutils.StatementIterator, limit int) *chunk
 	return &chunker{iterator: iterator, chunk: make([]string, 0, limit), limit: limit}
 }
 
-func isTransactionStatement(stmt string) bool {
-	patterns := [][]byte{[]byte("begin"), []byte("commit"), []byte("end"), []byte("rollback")}
-	for _, p := range patterns {
-		if len(stmt) >= len(p) && bytes.Equal(bytes.ToLower([]byte(stmt[0:len(p)])), p) {
-			return true
-		}
-	}
-	return false
-}
-
 func (c *chunker) Next() (chunk []string, isEOF bool) {
 	c.chunk = c.chunk[:0]
 	var stmt string
 	for !isEOF && len(c.chunk) < c.limit {
 		stmt, _, isEOF = c.iterator.Next()
 		// We need to skip transaction statements. Chunks run in a transaction by default.
-		if stmt != "" && !isTransactionStatement(stmt) {
+		if stmt != "" && !shared.IsTransactionStatement(stmt) {
 			c.chunk = append(c.chunk, stmt)
 		}
 	}
@@ -401,9 +677,13 @@ func (h *hranaV2Conn) executeInChunks(ctx context.Context, query string, wantRow
 	return result, nil
 }
 
-func (h *hranaV2Conn) executeStmt(ctx context.Context, query string, args []driver.NamedValue, wantRows bool) (*hrana.PipelineResponse, error) {
+func (h *hranaV2Conn) useChunks(query string, args []driver.NamedValue) bool {
 	const querySizeLimitForChunking = 20 * 1024 * 1024
-	if len(args) == 0 && len(query) > querySizeLimitForChunking && !h.schemaDb {
+	return len(args) == 0 && len(query) > querySizeLimitForChunking && !h.schemaDb
+}
+
+func (h *hranaV2Conn) executeStmt(ctx context.Context, query string, args []driver.NamedValue, wantRows bool) (*hrana.PipelineResponse, error) {
+	if h.useChunks(query, args) {
 		return h.executeInChunks(ctx, query, wantRows)
 	}
 	stmts, params, err := shared.ParseStatementAndArgs(query, args)
@@ -473,96 +753,13 @@ func (h *hranaV2Conn) ExecContext(ctx context.Context, query string, args []driv
 	}
 }
 
-type StmtResultRowsProvider struct {
-	r *hrana.StmtResult
-}
-
-func (p *StmtResultRowsProvider) SetsCount() int {
-	return 1
-}
-
-func (p *StmtResultRowsProvider) RowsCount(setIdx int) int {
-	if setIdx != 0 {
-		return 0
-	}
-	return len(p.r.Rows)
-}
-
-func (p *StmtResultRowsProvider) Columns(setIdx int) []string {
-	if setIdx != 0 {
-		return nil
-	}
-	res := make([]string, len(p.r.Cols))
-	for i, c := range p.r.Cols {
-		if c.Name != nil {
-			res[i] = *c.Name
-		}
-	}
-	return res
-}
-
-func (p *StmtResultRowsProvider) FieldValue(setIdx, rowIdx, colIdx int) driver.Value {
-	if setIdx != 0 {
-		return nil
-	}
-	return p.r.Rows[rowIdx][colIdx].ToValue(p.r.Cols[colIdx].Type)
-}
-
-func (p *StmtResultRowsProvider) Error(setIdx int) string {
-	return ""
-}
-
-func (p *StmtResultRowsProvider) HasResult(setIdx int) bool {
-	return setIdx == 0
-}
-
-type BatchResultRowsProvider struct {
-	r *hrana.BatchResult
-}
-
-func (p *BatchResultRowsProvider) SetsCount() int {
-	return len(p.r.StepResults)
-}
-
-func (p *BatchResultRowsProvider) RowsCount(setIdx int) int {
-	if setIdx >= len(p.r.StepResults) || p.r.StepResults[setIdx] == nil {
-		return 0
-	}
-	return len(p.r.StepResults[setIdx].Rows)
-}
-
-func (p *BatchResultRowsProvider) Columns(setIdx int) []string {
-	if setIdx >= len(p.r.StepResults) || p.r.StepResults[setIdx] == nil {
-		return nil
-	}
-	res := make([]string, len(p.r.StepResults[setIdx].Cols))
-	for i, c := range p.r.StepResults[setIdx].Cols {
-		if c.Name != nil {
-			res[i] = *c.Name
-		}
-	}
-	return res
-}
-
-func (p *BatchResultRowsProvider) FieldValue(setIdx, rowIdx, colIdx int) driver.Value {
-	if setIdx >= len(p.r.StepResults) || p.r.StepResults[setIdx] == nil {
-		return nil
+func (h *hranaV2Conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := h.prepareStream(ctx); err != nil {
+		return nil, err
 	}
-	return p.r.StepResults[setIdx].Rows[rowIdx][colIdx].ToValue(p.r.StepResults[setIdx].Cols[colIdx].Type)
-}
-
-func (p *BatchResultRowsProvider) Error(setIdx int) string {
-	if setIdx >= len(p.r.StepErrors) || p.r.StepErrors[setIdx] == nil {
-		return ""
+	if h.endpoint.cursorPath != "" && !h.useChunks(query, args) {
+		return h.queryCursor(ctx, query, args)
 	}
-	return p.r.StepErrors[setIdx].Message
-}
-
-func (p *BatchResultRowsProvider) HasResult(setIdx int) bool {
-	return setIdx < len(p.r.StepResults) && p.r.StepResults[setIdx] != nil
-}
-
-func (h *hranaV2Conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
 	result, err := h.executeStmt(ctx, query, args, true)
 	if err != nil {
 		return nil, err
@@ -573,7 +770,7 @@ func (h *hranaV2Conn) QueryContext(ctx context.Context, query string, args []dri
 		if err != nil {
 			return nil, err
 		}
-		return shared.NewRows(&StmtResultRowsProvider{res}), nil
+		return shared.NewRows(hrana.NewStmtResultRowsProvider(res, h.codec.Time)), nil
 	case "batch":
 		res, err := result.Results[0].Response.BatchResult()
 		if err != nil {
@@ -583,19 +780,67 @@ func (h *hranaV2Conn) QueryContext(ctx context.Context, query string, args []dri
 			res.StepResults = res.StepResults[:len(res.StepResults)-1]
 			res.StepErrors = res.StepErrors[:len(res.StepErrors)-1]
 		}
-		return shared.NewRows(&BatchResultRowsProvider{res}), nil
+		return shared.NewRows(hrana.NewBatchResultRowsProvider(res, h.codec.Time)), nil
 	default:
 		return nil, fmt.Errorf("failed to execute SQL: %s\n%s", query, "unknown response type")
 	}
 }
 
+func (h *hranaV2Conn) queryCursor(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	stmts, params, err := shared.ParseStatementAndArgs(query, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL:\n%w", err)
+	}
+	batchStream, err := hrana.BatchStream(stmts, params, true, len(stmts) > 1 && !h.schemaDb)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL:\n%w", err)
+	}
+	rows, err := h.openCursor(ctx, batchStream.Batch, len(stmts))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL:\n%w", err)
+	}
+	if len(stmts) > 0 {
+		if err := rows.beginStep(); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to execute SQL:\n%w", err)
+		}
+	}
+	return rows, nil
+}
+
+// ExecuteBatch executes batch in a single request. Errors of individual steps are returned in the
+// result.
+func (h *hranaV2Conn) ExecuteBatch(ctx context.Context, batch *hrana.Batch) (*hrana.BatchResult, error) {
+	msg := &hrana.PipelineRequest{}
+	msg.Add(hrana.StreamRequest{Type: "batch", Batch: batch})
+	result, err := h.executeMsg(ctx, msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute batch:\n%w", err)
+	}
+	return result.Results[0].Response.BatchStepsResult()
+}
+
+// Describe asks the server for the parameters and result columns of query without executing it.
+func (h *hranaV2Conn) Describe(ctx context.Context, query string) (*hrana.DescribeResult, error) {
+	msg := &hrana.PipelineRequest{}
+	msg.Add(hrana.DescribeStream(query))
+	result, err := h.executeMsg(ctx, msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe SQL: %s\n%w", query, err)
+	}
+	return result.Results[0].Response.DescribeResult()
+}
+
 func (h *hranaV2Conn) closeStream() {
+	if h.cursor != nil {
+		h.cursor.Close()
+	}
 	if h.baton != "" {
-		go func(baton, url, jwt, host string) {
+		go func(baton, url string, endpoint *endpoint, token shared.TokenProvider, host string, client *http.Client) {
 			msg := hrana.PipelineRequest{Baton: baton}
 			msg.Add(hrana.CloseStream())
-			_, _, _ = sendPipelineRequest(context.Background(), &msg, url, jwt, host)
-		}(h.baton, h.url, h.jwt, h.host)
+			_, _, _ = sendPipelineRequest(context.Background(), &msg, url, endpoint, token, host, client)
+		}(h.baton, h.url, h.endpoint, h.token, h.host, h.client)
 		h.baton = ""
 	}
 }
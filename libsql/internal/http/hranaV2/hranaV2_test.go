@@ -0,0 +1,235 @@
+package hranaV2
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/hrana"
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
+	"github.com/tursodatabase/libsql-client-go/libsql/libsqltest"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// newTestServer starts a libsqltest server that is backed by a new database.
+func newTestServer(t *testing.T) *libsqltest.Server {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "server.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	srv := libsqltest.NewServer(db)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// connectTestServer connects to srv with the settings of config other than the URL, the token and
+// the host.
+func connectTestServer(srv *libsqltest.Server, config Config) *hranaV2Conn {
+	config.URL = srv.URL
+	config.Token = shared.StaticToken("")
+	config.Host = strings.TrimPrefix(srv.URL, "http://")
+	return Connect(config).(*hranaV2Conn)
+}
+
+// failRequests makes the next n stream requests to srv fail with 503. Requests that close streams
+// are sent in the background, so they are let through.
+func failRequests(srv *libsqltest.Server, n int) {
+	var mu sync.Mutex
+	srv.SetHook(func(req libsqltest.Request) *libsqltest.Error {
+		mu.Lock()
+		defer mu.Unlock()
+		if n == 0 || req.Type == "close" {
+			return nil
+		}
+		n--
+		return &libsqltest.Error{Message: "unavailable", HTTPStatus: http.StatusServiceUnavailable}
+	})
+}
+
+// receivedRequests returns the requests srv received since the last call, except the requests that
+// close streams.
+func receivedRequests(srv *libsqltest.Server) []libsqltest.Request {
+	var requests []libsqltest.Request
+	for _, req := range srv.Requests() {
+		if req.Type != "close" {
+			requests = append(requests, req)
+		}
+	}
+	return requests
+}
+
+func TestConnectUsesHTTPClient(t *testing.T) {
+	srv := httptest.NewTLSServer(newTestServer(t))
+	defer srv.Close()
+	config := Config{URL: srv.URL, Token: shared.StaticToken(""), Host: strings.TrimPrefix(srv.URL, "https://")}
+	ctx := context.Background()
+
+	if err := Connect(config).(*hranaV2Conn).PingContext(ctx); err == nil {
+		t.Errorf("PingContext() succeeded without trusting the server certificate")
+	}
+	config.Client = srv.Client()
+	conn := Connect(config).(*hranaV2Conn)
+	if err := conn.PingContext(ctx); err != nil {
+		t.Fatalf("PingContext() error = %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+}
+
+func TestEndpointCache(t *testing.T) {
+	var mu sync.Mutex
+	probes := 0
+	down := true
+	client := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if req.Method == http.MethodGet {
+			probes++
+			if down {
+				return nil, errors.New("connection refused")
+			}
+		}
+		status, body := http.StatusOK, ""
+		if req.URL.Path == "/v3/pipeline" {
+			body = `{"baton":null,"results":[{"type":"ok","response":{"type":"execute","result":{"cols":[],"rows":[],"affected_row_count":0,"last_insert_rowid":null}}}]}`
+		}
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(body)), Header: http.Header{}}, nil
+	})}
+
+	ctx := context.Background()
+	endpoints := &EndpointCache{}
+	ping := func() error {
+		conn := Connect(Config{URL: "http://example.com", Token: shared.StaticToken(""), Host: "example.com", Client: client, Endpoints: endpoints}).(*hranaV2Conn)
+		return conn.PingContext(ctx)
+	}
+	// A failed negotiation is not cached.
+	if err := ping(); err == nil {
+		t.Fatalf("PingContext() succeeded, want the probe to fail")
+	}
+	mu.Lock()
+	down = false
+	mu.Unlock()
+	for i := 0; i < 3; i++ {
+		if err := ping(); err != nil {
+			t.Fatalf("PingContext() error = %v", err)
+		}
+	}
+	if probes != 2 {
+		t.Errorf("server was probed %d times, want 2", probes)
+	}
+	if endpoints.endpoint != v3Endpoint {
+		t.Errorf("cached endpoint = %+v, want v3", endpoints.endpoint)
+	}
+}
+
+func TestSendRequestRefreshesToken(t *testing.T) {
+	var authorizations []string
+	client := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		authorizations = append(authorizations, req.Header.Get("Authorization"))
+		status := http.StatusOK
+		if req.Header.Get("Authorization") != "Bearer new" {
+			status = http.StatusUnauthorized
+		}
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader("")), Header: http.Header{}}, nil
+	})}
+
+	current, refreshes := "old", 0
+	token := func(ctx context.Context, refresh bool) (string, error) {
+		if refresh {
+			refreshes++
+			current = "new"
+		}
+		return current, nil
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := sendRequest(context.Background(), http.MethodGet, "http://example.com", "/v3", nil, "", token, "example.com", client)
+		if err != nil {
+			t.Fatalf("sendRequest() error = %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("sendRequest() status = %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+	}
+	want := []string{"Bearer old", "Bearer new", "Bearer new"}
+	if strings.Join(authorizations, ",") != strings.Join(want, ",") || refreshes != 1 {
+		t.Errorf("sent authorizations %v with %d refreshes, want %v with 1 refresh", authorizations, refreshes, want)
+	}
+
+	// A token that doesn't change on refresh is not retried.
+	authorizations = nil
+	resp, err := sendRequest(context.Background(), http.MethodGet, "http://example.com", "/v3", nil, "", shared.StaticToken("old"), "example.com", client)
+	if err != nil {
+		t.Fatalf("sendRequest() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized || len(authorizations) != 1 {
+		t.Errorf("sendRequest() status = %d after %d requests, want %d after 1", resp.StatusCode, len(authorizations), http.StatusUnauthorized)
+	}
+}
+
+func TestErrorFromResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		wantMessage string
+		wantCode    string
+		wantBadConn bool
+	}{
+		{name: "error field", status: 400, body: `{"error":"bad request"}`, wantMessage: "bad request"},
+		{name: "hrana error", status: 400, body: `{"message":"stream expired","code":"STREAM_EXPIRED"}`, wantMessage: "stream expired", wantCode: "STREAM_EXPIRED", wantBadConn: true},
+		{name: "error with code", status: 400, body: `{"error":"UNIQUE constraint failed: t.id","code":"SQLITE_CONSTRAINT_UNIQUE"}`, wantMessage: "UNIQUE constraint failed: t.id", wantCode: "SQLITE_CONSTRAINT_UNIQUE"},
+		{name: "plain text", status: 502, body: "bad gateway", wantMessage: "bad gateway"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := errorFromResponse(&http.Response{StatusCode: tt.status, Body: io.NopCloser(strings.NewReader(tt.body))})
+			var serverErr *hrana.ServerError
+			if !errors.As(err, &serverErr) {
+				t.Fatalf("errorFromResponse() = %v, want a ServerError", err)
+			}
+			if serverErr.Message != tt.wantMessage || serverErr.Code != tt.wantCode || serverErr.HTTPStatus != tt.status {
+				t.Errorf("errorFromResponse() = %#v", serverErr)
+			}
+			if errors.Is(err, driver.ErrBadConn) != tt.wantBadConn {
+				t.Errorf("errors.Is(err, driver.ErrBadConn) = %v, want %v", !tt.wantBadConn, tt.wantBadConn)
+			}
+		})
+	}
+}
+
+func TestPrepareDescribes(t *testing.T) {
+	srv := newTestServer(t)
+	ctx := context.Background()
+	conn := connectTestServer(srv, Config{Describe: true})
+	if _, err := conn.ExecContext(ctx, "CREATE TABLE t (x TEXT, a, b)", nil); err != nil {
+		t.Fatalf("ExecContext() error = %v", err)
+	}
+
+	stmt, err := conn.PrepareContext(ctx, "SELECT x FROM t WHERE a = :a AND b = ?")
+	if err != nil {
+		t.Fatalf("PrepareContext() error = %v", err)
+	}
+	if got := stmt.NumInput(); got != 2 {
+		t.Errorf("NumInput() = %d, want 2", got)
+	}
+	if _, err := conn.PrepareContext(ctx, "SELECT x FROM missing"); err == nil || !strings.Contains(err.Error(), "no such table: missing") {
+		t.Errorf("PrepareContext() error = %v, want no such table", err)
+	}
+}
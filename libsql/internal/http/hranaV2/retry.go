@@ -0,0 +1,172 @@
+package hranaV2
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/hrana"
+)
+
+// IsTransient reports whether err is a failure that may go away when the request is sent again: a
+// network error, a connection that was closed before the response was complete, a 502, 503 or 504
+// response from a proxy, or an expired stream. Other errors of the HTTP client, such as an invalid
+// URL or a certificate that isn't trusted, are permanent.
+func IsTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var serverErr *hrana.ServerError
+	if errors.As(err, &serverErr) {
+		switch serverErr.HTTPStatus {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return serverErr.Code == "STREAM_EXPIRED"
+	}
+	// A *url.Error is a net.Error itself, whatever it wraps, so only the error it wraps is checked.
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// retry sends a request with send and sends it again while it fails with a transient error, as
+// long as the retry policy allows. A request is replayed on a new stream, and only if every request
+// sent on the current stream so far was a read, so a replay can never lose the state of a
+// transaction.
+func (h *hranaV2Conn) retry(ctx context.Context, readOnly bool, send func() error) error {
+	if h.baton == "" {
+		h.streamReadOnly = true
+	}
+	h.streamReadOnly = h.streamReadOnly && readOnly
+	retryable := h.retryPolicy.Retryable
+	if retryable == nil {
+		retryable = IsTransient
+	}
+
+	err := send()
+	for attempt := 1; err != nil && attempt < h.retryPolicy.MaxAttempts; attempt++ {
+		if !h.streamReadOnly || !retryable(err) {
+			break
+		}
+		select {
+		case <-time.After(h.retryPolicy.Backoff(attempt)):
+		case <-ctx.Done():
+			return err
+		}
+		h.closeStream()
+		h.streamClosed = false
+		err = send()
+	}
+	return err
+}
+
+func isReadOnlyStmt(stmt *hrana.Stmt) bool {
+	if stmt.Sql == nil {
+		// Statements stored with store_sql are classified by hranaV2Stmt, which knows their SQL.
+		return false
+	}
+	return isReadOnlySql(*stmt.Sql)
+}
+
+// isReadOnlySql reports whether the statement sql only reads.
+func isReadOnlySql(sql string) bool {
+	sql = strings.TrimSpace(sql)
+	for _, keyword := range []string{"SELECT", "VALUES", "EXPLAIN"} {
+		if startsWithKeyword(sql, keyword) {
+			return true
+		}
+	}
+	// Common table expressions can only hold selects, but the statement that uses them can write.
+	return startsWithKeyword(sql, "WITH") && !containsDml(sql)
+}
+
+func startsWithKeyword(sql string, keyword string) bool {
+	return len(sql) > len(keyword) && strings.EqualFold(sql[:len(keyword)], keyword) && !isIdentifierChar(sql[len(keyword)])
+}
+
+// containsDml reports whether sql contains one of the keywords INSERT, UPDATE, DELETE or REPLACE
+// outside of literals, quoted identifiers and comments. A keyword followed by a parenthesis is a
+// function call, like replace(x, y, z).
+func containsDml(sql string) bool {
+	for i := 0; i < len(sql); {
+		switch c := sql[i]; {
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			next := strings.IndexByte(sql[i+1:], end)
+			if next < 0 {
+				return false
+			}
+			i += next + 2
+		case strings.HasPrefix(sql[i:], "--"):
+			next := strings.IndexByte(sql[i:], '\n')
+			if next < 0 {
+				return false
+			}
+			i += next + 1
+		case strings.HasPrefix(sql[i:], "/*"):
+			next := strings.Index(sql[i+2:], "*/")
+			if next < 0 {
+				return false
+			}
+			i += next + 4
+		case isIdentifierChar(c):
+			start := i
+			for i < len(sql) && isIdentifierChar(sql[i]) {
+				i++
+			}
+			switch strings.ToUpper(sql[start:i]) {
+			case "INSERT", "UPDATE", "DELETE", "REPLACE":
+				if !strings.HasPrefix(strings.TrimLeft(sql[i:], " \t\r\n"), "(") {
+					return true
+				}
+			}
+		default:
+			i++
+		}
+	}
+	return false
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isReadOnlyBatch(batch *hrana.Batch) bool {
+	for idx := range batch.Steps {
+		if !isReadOnlyStmt(&batch.Steps[idx].Stmt) {
+			return false
+		}
+	}
+	return true
+}
+
+func isReadOnlyPipeline(msg *hrana.PipelineRequest) bool {
+	for _, req := range msg.Requests {
+		switch {
+		case req.Stmt != nil:
+			if !isReadOnlyStmt(req.Stmt) {
+				return false
+			}
+		case req.Batch != nil:
+			if !isReadOnlyBatch(req.Batch) {
+				return false
+			}
+		case req.Type == "describe":
+		default:
+			return false
+		}
+	}
+	return true
+}
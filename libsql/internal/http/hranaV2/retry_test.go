@@ -0,0 +1,158 @@
+package hranaV2
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"testing"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/hrana"
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
+	"github.com/tursodatabase/libsql-client-go/libsql/libsqltest"
+)
+
+func TestIsReadOnlyStmt(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want bool
+	}{
+		{sql: "SELECT 1", want: true},
+		{sql: "  select * from t", want: true},
+		{sql: "VALUES (1)", want: true},
+		{sql: "EXPLAIN QUERY PLAN SELECT 1", want: true},
+		{sql: "SELECTED", want: false},
+		{sql: "INSERT INTO t VALUES (1)", want: false},
+		{sql: "WITH x AS (SELECT 1) DELETE FROM t", want: false},
+		{sql: "WITH x AS (SELECT 1) SELECT * FROM x", want: true},
+		{sql: "with x as (select replace(a, 'b', 'c') from t) select * from x", want: true},
+		{sql: "WITH x AS (SELECT 'DELETE' AS \"UPDATE\" /* INSERT */) SELECT * FROM x -- REPLACE", want: true},
+		{sql: "WITH x AS (SELECT 1) INSERT INTO t SELECT * FROM x", want: false},
+		{sql: "WITH x AS (SELECT 'it''s') UPDATE t SET a = 1", want: false},
+		{sql: "WITH x AS (SELECT 1) REPLACE INTO t SELECT * FROM x", want: false},
+		{sql: "WITHOUT", want: false},
+		{sql: "BEGIN", want: false},
+		{sql: "PRAGMA foreign_keys = ON", want: false},
+	}
+	for _, tt := range tests {
+		if got := isReadOnlyStmt(&hrana.Stmt{Sql: &tt.sql}); got != tt.want {
+			t.Errorf("isReadOnlyStmt(%q) = %v, want %v", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection refused", &url.Error{Op: "Post", URL: "http://example.com", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}, true},
+		{"connection closed", &url.Error{Op: "Post", URL: "http://example.com", Err: io.EOF}, true},
+		{"truncated response", fmt.Errorf("failed to read response: %w", io.ErrUnexpectedEOF), true},
+		{"unsupported scheme", &url.Error{Op: "Post", URL: "ftp://example.com", Err: errors.New(`unsupported protocol scheme "ftp"`)}, false},
+		{"untrusted certificate", &url.Error{Op: "Post", URL: "https://example.com", Err: &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}}, false},
+		{"cancelled", &url.Error{Op: "Post", URL: "http://example.com", Err: context.Canceled}, false},
+		{"service unavailable", hrana.NewHTTPError(http.StatusServiceUnavailable, "unavailable", ""), true},
+		{"bad request", hrana.NewHTTPError(http.StatusBadRequest, "bad request", ""), false},
+		{"stream expired", hrana.NewHTTPError(http.StatusBadRequest, "expired", "STREAM_EXPIRED"), true},
+	}
+	for _, tt := range tests {
+		if got := IsTransient(tt.err); got != tt.want {
+			t.Errorf("%s: IsTransient(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	srv := newTestServer(t)
+	policy := shared.RetryPolicy{MaxAttempts: 3}
+	ctx := context.Background()
+	check := func(t *testing.T, want ...string) []libsqltest.Request {
+		t.Helper()
+		requests := receivedRequests(srv)
+		if got := fmt.Sprintf("%q", requests); got != fmt.Sprintf("%q", want) {
+			t.Errorf("requests = %s, want %q", got, want)
+		}
+		return requests
+	}
+
+	t.Run("read on a new stream", func(t *testing.T) {
+		conn := connectTestServer(srv, Config{RetryPolicy: policy})
+		failRequests(srv, 2)
+		if _, err := conn.QueryContext(ctx, "SELECT 1", nil); err != nil {
+			t.Fatalf("QueryContext() error = %v", err)
+		}
+		check(t, "execute SELECT 1", "execute SELECT 1", "execute SELECT 1")
+	})
+
+	t.Run("prepared read", func(t *testing.T) {
+		conn := connectTestServer(srv, Config{RetryPolicy: policy})
+		stmt, err := conn.PrepareContext(ctx, "WITH x AS (SELECT 1) SELECT * FROM x")
+		if err != nil {
+			t.Fatalf("PrepareContext() error = %v", err)
+		}
+		defer stmt.Close()
+		failRequests(srv, 1)
+		if _, err := stmt.(driver.StmtExecContext).ExecContext(ctx, nil); err != nil {
+			t.Fatalf("ExecContext() error = %v", err)
+		}
+		// The statement is stored again on the new stream.
+		check(t, "store_sql WITH x AS (SELECT 1) SELECT * FROM x", "store_sql WITH x AS (SELECT 1) SELECT * FROM x", "execute")
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		conn := connectTestServer(srv, Config{RetryPolicy: policy})
+		failRequests(srv, 3)
+		if _, err := conn.QueryContext(ctx, "SELECT 1", nil); err == nil || !IsTransient(err) {
+			t.Fatalf("QueryContext() error = %v, want the transient error", err)
+		}
+		check(t, "execute SELECT 1", "execute SELECT 1", "execute SELECT 1")
+	})
+
+	t.Run("write", func(t *testing.T) {
+		conn := connectTestServer(srv, Config{RetryPolicy: policy})
+		failRequests(srv, 1)
+		if _, err := conn.ExecContext(ctx, "CREATE TABLE t (a)", nil); err == nil {
+			t.Fatalf("ExecContext() succeeded, want the error of the first attempt")
+		}
+		check(t, "execute CREATE TABLE t (a)")
+	})
+
+	t.Run("read in a transaction", func(t *testing.T) {
+		conn := connectTestServer(srv, Config{RetryPolicy: policy})
+		if _, err := conn.BeginTx(ctx, driver.TxOptions{}); err != nil {
+			t.Fatalf("BeginTx() error = %v", err)
+		}
+		failRequests(srv, 1)
+		if _, err := conn.QueryContext(ctx, "SELECT 1", nil); err == nil {
+			t.Fatalf("QueryContext() succeeded, want the error of the first attempt")
+		}
+		requests := check(t, "execute BEGIN", "execute SELECT 1")
+		if len(requests) == 2 && requests[0].Stream != requests[1].Stream {
+			t.Errorf("SELECT was sent on stream %d, want the stream of BEGIN", requests[1].Stream)
+		}
+	})
+
+	t.Run("read on a stream with reads only", func(t *testing.T) {
+		conn := connectTestServer(srv, Config{RetryPolicy: policy})
+		if _, err := conn.QueryContext(ctx, "SELECT 1", nil); err != nil {
+			t.Fatalf("QueryContext() error = %v", err)
+		}
+		failRequests(srv, 1)
+		if _, err := conn.QueryContext(ctx, "SELECT 2", nil); err != nil {
+			t.Fatalf("QueryContext() error = %v", err)
+		}
+		requests := check(t, "execute SELECT 1", "execute SELECT 2", "execute SELECT 2")
+		if len(requests) == 3 && requests[2].Stream == requests[1].Stream {
+			t.Errorf("SELECT 2 was replayed on stream %d, want a new stream", requests[2].Stream)
+		}
+	})
+}
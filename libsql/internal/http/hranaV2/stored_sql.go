@@ -0,0 +1,127 @@
+package hranaV2
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/hrana"
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
+)
+
+// beginRequest is called before a request is sent on the stream. A request without a baton opens a
+// new stream, which doesn't know the SQL stored on the previous one.
+func (h *hranaV2Conn) beginRequest() {
+	if h.baton == "" {
+		h.streamGeneration++
+		h.sqlClosesPending = nil
+	}
+}
+
+func (h *hranaV2Conn) allocSqlId() int32 {
+	if n := len(h.freeSqlIds); n > 0 {
+		id := h.freeSqlIds[n-1]
+		h.freeSqlIds = h.freeSqlIds[:n-1]
+		return id
+	}
+	h.nextSqlId++
+	return h.nextSqlId
+}
+
+// releaseSql gives back the id of a closed statement. If its SQL is stored on the current stream,
+// it is closed together with the next request. The id can be reused right away, because the
+// close_sql request is sent before anything else.
+func (h *hranaV2Conn) releaseSql(s *hranaV2Stmt) {
+	if s.streamGeneration == h.streamGeneration && h.baton != "" {
+		h.sqlClosesPending = append(h.sqlClosesPending, s.sqlId)
+	}
+	h.freeSqlIds = append(h.freeSqlIds, s.sqlId)
+}
+
+// withSqlCloses returns a copy of msg that closes the SQL with the given ids before the requests of
+// msg.
+func withSqlCloses(msg *hrana.PipelineRequest, sqlIds []int32) *hrana.PipelineRequest {
+	res := &hrana.PipelineRequest{Baton: msg.Baton, Requests: make([]hrana.StreamRequest, 0, len(sqlIds)+len(msg.Requests))}
+	for _, id := range sqlIds {
+		res.Add(hrana.CloseStoredSqlStream(id))
+	}
+	res.Requests = append(res.Requests, msg.Requests...)
+	return res
+}
+
+// storedParams converts the arguments of s.
+func storedParams(s *hranaV2Stmt, args []driver.NamedValue) (shared.Params, error) {
+	_, params, err := shared.ParseStatementAndArgs(s.sql, args)
+	if err != nil || len(params) == 0 {
+		return shared.Params{}, err
+	}
+	return params[0], nil
+}
+
+// isStored reports whether the SQL of s is stored on the current stream.
+func (h *hranaV2Conn) isStored(s *hranaV2Stmt) bool {
+	return s.streamGeneration == h.streamGeneration && h.baton != ""
+}
+
+// executeStored executes the statement s by its id. Its SQL is stored first if the stream doesn't
+// know it yet, in the same request. A retried request runs on a new stream, so it stores the SQL
+// again.
+func (h *hranaV2Conn) executeStored(ctx context.Context, s *hranaV2Stmt, args []driver.NamedValue, wantRows bool) (*hrana.StmtResult, error) {
+	p, err := storedParams(s, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL: %s\n%w", s.sql, err)
+	}
+	execute, err := hrana.ExecuteStoredStream(s.sqlId, p, wantRows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL: %s\n%w", s.sql, err)
+	}
+
+	var result *hrana.PipelineResponse
+	err = h.retry(ctx, s.readOnly, func() (err error) {
+		msg := &hrana.PipelineRequest{}
+		if !h.isStored(s) {
+			msg.Add(hrana.StoreSqlStream(s.sql, s.sqlId))
+		}
+		msg.Add(*execute)
+		result, err = h.sendMsg(ctx, msg)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL: %s\n%w", s.sql, err)
+	}
+	s.streamGeneration = h.streamGeneration
+	return result.Results[len(result.Results)-1].Response.ExecuteResult()
+}
+
+// queryStoredCursor executes the statement s and reads its rows through a cursor. A cursor request
+// can't store SQL, so the statement is referred to by its id if its SQL is stored on the stream
+// already, and sent as text otherwise.
+func (h *hranaV2Conn) queryStoredCursor(ctx context.Context, s *hranaV2Stmt, args []driver.NamedValue) (driver.Rows, error) {
+	p, err := storedParams(s, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL: %s\n%w", s.sql, err)
+	}
+	var rows *cursorRows
+	err = h.retry(ctx, s.readOnly, func() (err error) {
+		stmt := hrana.Stmt{WantRows: true}
+		if h.isStored(s) {
+			sqlId := s.sqlId
+			stmt.SqlId = &sqlId
+		} else {
+			stmt.Sql = &s.sql
+		}
+		if err := stmt.AddArgs(p); err != nil {
+			return err
+		}
+		rows, err = h.sendCursorRequest(ctx, &hrana.Batch{Steps: []hrana.BatchStep{{Stmt: stmt}}}, 1)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL: %s\n%w", s.sql, err)
+	}
+	if err := rows.beginStep(); err != nil {
+		rows.Close()
+		return nil, fmt.Errorf("failed to execute SQL: %s\n%w", s.sql, err)
+	}
+	return rows, nil
+}
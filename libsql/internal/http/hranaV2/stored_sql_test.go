@@ -0,0 +1,140 @@
+package hranaV2
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
+)
+
+func TestStoredSql(t *testing.T) {
+	ctx := context.Background()
+	srv := newTestServer(t)
+	conn := connectTestServer(srv, Config{})
+	if _, err := conn.ExecContext(ctx, "CREATE TABLE t (a)", nil); err != nil {
+		t.Fatalf("ExecContext() error = %v", err)
+	}
+	receivedRequests(srv)
+
+	exec := func(stmt driver.Stmt) {
+		t.Helper()
+		res, err := stmt.(driver.StmtExecContext).ExecContext(ctx, nil)
+		if err != nil {
+			t.Fatalf("ExecContext() error = %v", err)
+		}
+		if n, _ := res.RowsAffected(); n != 1 {
+			t.Errorf("RowsAffected() = %d, want 1", n)
+		}
+	}
+
+	first, err := conn.PrepareContext(ctx, "INSERT INTO t VALUES (1)")
+	if err != nil {
+		t.Fatalf("PrepareContext() error = %v", err)
+	}
+	exec(first)
+	exec(first)
+	second, err := conn.PrepareContext(ctx, "INSERT INTO t VALUES (2)")
+	if err != nil {
+		t.Fatalf("PrepareContext() error = %v", err)
+	}
+	exec(second)
+	// A new stream doesn't know the SQL stored on the old one.
+	if err := conn.ResetSession(ctx); err != nil {
+		t.Fatalf("ResetSession() error = %v", err)
+	}
+	exec(first)
+	if err := first.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	// The second statement was not stored on the current stream, so there is nothing to close.
+	if err := second.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	// The third statement reuses an id, which is closed on the stream before it is stored again.
+	third, err := conn.PrepareContext(ctx, "INSERT INTO t VALUES (3)")
+	if err != nil {
+		t.Fatalf("PrepareContext() error = %v", err)
+	}
+	exec(third)
+
+	want := []string{
+		"store_sql INSERT INTO t VALUES (1)", "execute",
+		"execute",
+		"store_sql INSERT INTO t VALUES (2)", "execute",
+		"store_sql INSERT INTO t VALUES (1)", "execute",
+		"close_sql", "store_sql INSERT INTO t VALUES (3)", "execute",
+	}
+	if got := fmt.Sprintf("%q", receivedRequests(srv)); got != fmt.Sprintf("%q", want) {
+		t.Errorf("requests = %s, want %q", got, want)
+	}
+	rows, err := conn.QueryContext(ctx, "SELECT group_concat(a) FROM t", nil)
+	if err != nil {
+		t.Fatalf("QueryContext() error = %v", err)
+	}
+	if got := readAll(t, rows.(*cursorRows)); !reflect.DeepEqual(got, [][]driver.Value{{"1,1,2,1,3"}}) {
+		t.Errorf("rows = %v, want every statement to insert its own value", got)
+	}
+}
+
+func TestStoredSqlCursor(t *testing.T) {
+	ctx := context.Background()
+	srv := newTestServer(t)
+	conn := connectTestServer(srv, Config{RetryPolicy: shared.RetryPolicy{MaxAttempts: 2}})
+	if _, err := conn.ExecContext(ctx, "CREATE TABLE t (a); INSERT INTO t VALUES (1)", nil); err != nil {
+		t.Fatalf("ExecContext() error = %v", err)
+	}
+	// Replays are only allowed on streams that only read, so the reads start on a new stream.
+	if err := conn.ResetSession(ctx); err != nil {
+		t.Fatalf("ResetSession() error = %v", err)
+	}
+	stmt, err := conn.PrepareContext(ctx, "SELECT a FROM t")
+	if err != nil {
+		t.Fatalf("PrepareContext() error = %v", err)
+	}
+	receivedRequests(srv)
+	query := func() {
+		t.Helper()
+		rows, err := stmt.(driver.StmtQueryContext).QueryContext(ctx, nil)
+		if err != nil {
+			t.Fatalf("QueryContext() error = %v", err)
+		}
+		if got := readAll(t, rows.(*cursorRows)); !reflect.DeepEqual(got, [][]driver.Value{{int64(1)}}) {
+			t.Errorf("rows = %v", got)
+		}
+	}
+	exec := func() {
+		t.Helper()
+		if _, err := stmt.(driver.StmtExecContext).ExecContext(ctx, nil); err != nil {
+			t.Fatalf("ExecContext() error = %v", err)
+		}
+	}
+
+	query()
+	exec()
+	query()
+	// The statement is a read, so failed requests are replayed on a new stream, which doesn't know
+	// the stored SQL.
+	failRequests(srv, 1)
+	query()
+	exec()
+	failRequests(srv, 1)
+	exec()
+
+	want := []string{
+		// A cursor can't store SQL, so it is sent as text until an execution stored it.
+		"execute SELECT a FROM t",
+		"store_sql SELECT a FROM t", "execute",
+		"execute",
+		// The failed cursor and its replay.
+		"execute", "execute SELECT a FROM t",
+		"store_sql SELECT a FROM t", "execute",
+		// The failed execution and its replay.
+		"execute", "store_sql SELECT a FROM t", "execute",
+	}
+	if got := fmt.Sprintf("%q", receivedRequests(srv)); got != fmt.Sprintf("%q", want) {
+		t.Errorf("requests = %s, want %q", got, want)
+	}
+}
@@ -0,0 +1,19 @@
+package shared
+
+import "reflect"
+
+// ColumnType describes a column of a result set as reported by the RowsColumnType interfaces of
+// database/sql/driver.
+type ColumnType struct {
+	// DatabaseTypeName is the declared type of the column in upper case, or empty if the column is
+	// an expression.
+	DatabaseTypeName string
+	ScanType         reflect.Type
+	Nullable         bool
+	NullableOk       bool
+	Length           int64
+	LengthOk         bool
+}
+
+// AnyType is the scan type of columns that can hold values of any type.
+var AnyType = reflect.TypeOf((*any)(nil)).Elem()
@@ -0,0 +1,116 @@
+package shared
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// ValueConverter converts arguments of types that the driver doesn't know, such as UUIDs or
+// decimals. It returns driver.ErrSkip for values it doesn't handle.
+type ValueConverter func(v any) (driver.Value, error)
+
+// CheckNamedValue converts the value of nv with the first of converters that handles it. Values that
+// no converter handles are converted with their driver.Valuer implementation if they have one, and
+// by their kind otherwise.
+func CheckNamedValue(nv *driver.NamedValue, converters []ValueConverter) error {
+	value, err := ConvertValue(nv.Value, converters)
+	if err != nil {
+		if nv.Name != "" {
+			return fmt.Errorf("invalid argument %s: %w", nv.Name, err)
+		}
+		return fmt.Errorf("invalid argument %d: %w", nv.Ordinal, err)
+	}
+	nv.Value = value
+	return nil
+}
+
+// ConvertValue converts v to nil, int64, float64, bool, []byte, string or time.Time.
+func ConvertValue(v any, converters []ValueConverter) (driver.Value, error) {
+	for _, convert := range converters {
+		value, err := convert(v)
+		if err == driver.ErrSkip {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return convertKind(value, converters)
+	}
+	if valuer, ok := v.(driver.Valuer); ok {
+		rv := reflect.ValueOf(v)
+		// Like database/sql, treat a nil pointer to a type whose Value method has a value receiver as
+		// NULL instead of panicking.
+		if rv.Kind() == reflect.Pointer && rv.IsNil() && rv.Type().Elem().Implements(valuerType) {
+			return nil, nil
+		}
+		value, err := valuer.Value()
+		if err != nil {
+			return nil, err
+		}
+		return convertKind(value, converters)
+	}
+	return convertKind(v, converters)
+}
+
+var valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+
+func convertKind(v any, converters []ValueConverter) (driver.Value, error) {
+	switch v := v.(type) {
+	case nil, int64, float64, bool, []byte, string, time.Time:
+		return v, nil
+	case int:
+		return int64(v), nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return ConvertValue(rv.Elem().Interface(), converters)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		if u > math.MaxInt64 {
+			return nil, fmt.Errorf("value %d of type %T overflows int64", u, v)
+		}
+		return int64(u), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return rv.Bytes(), nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported type %T", v)
+}
+
+// Codec converts the values of a connection: arguments before they are sent and results after
+// they are received.
+type Codec struct {
+	// Converters convert arguments of types the driver doesn't know.
+	Converters []ValueConverter
+	// Time configures how times are written and read.
+	Time TimeEncoding
+}
+
+// CheckNamedValue converts the argument nv like the package level CheckNamedValue and encodes
+// times.
+func (c Codec) CheckNamedValue(nv *driver.NamedValue) error {
+	if err := CheckNamedValue(nv, c.Converters); err != nil {
+		return err
+	}
+	if t, ok := nv.Value.(time.Time); ok {
+		nv.Value = c.Time.Encode(t)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package shared
+
+import (
+	"database/sql/driver"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testName string
+
+type testValuer struct{ v string }
+
+func (v testValuer) Value() (driver.Value, error) {
+	return v.v, nil
+}
+
+type testUUID [2]byte
+
+func TestConvertValue(t *testing.T) {
+	one := 1
+	var nilValuer *testValuer
+	converters := []ValueConverter{func(v any) (driver.Value, error) {
+		if u, ok := v.(testUUID); ok {
+			return []byte{u[0], u[1]}, nil
+		}
+		return nil, driver.ErrSkip
+	}}
+	tests := []struct {
+		value any
+		want  driver.Value
+	}{
+		{value: nil, want: nil},
+		{value: int8(-3), want: int64(-3)},
+		{value: int32(7), want: int64(7)},
+		{value: uint16(8), want: int64(8)},
+		{value: uint64(math.MaxInt64), want: int64(math.MaxInt64)},
+		{value: float32(1.5), want: float64(1.5)},
+		{value: testName("x"), want: "x"},
+		{value: &one, want: int64(1)},
+		{value: (*int)(nil), want: nil},
+		{value: testValuer{"v"}, want: "v"},
+		{value: nilValuer, want: nil},
+		{value: testUUID{1, 2}, want: []byte{1, 2}},
+		{value: &testUUID{3, 4}, want: []byte{3, 4}},
+	}
+	for _, tt := range tests {
+		got, err := ConvertValue(tt.value, converters)
+		if err != nil {
+			t.Errorf("ConvertValue(%#v) error = %v", tt.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ConvertValue(%#v) = %#v, want %#v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestConvertValueErrors(t *testing.T) {
+	if _, err := ConvertValue(uint64(math.MaxUint64), nil); err == nil || !strings.Contains(err.Error(), "overflows int64") {
+		t.Errorf("ConvertValue(MaxUint64) error = %v, want overflow", err)
+	}
+	if _, err := ConvertValue(struct{}{}, nil); err == nil || !strings.Contains(err.Error(), "unsupported type") {
+		t.Errorf("ConvertValue(struct{}{}) error = %v, want unsupported type", err)
+	}
+	errConvert := errors.New("bad value")
+	failing := []ValueConverter{func(v any) (driver.Value, error) { return nil, errConvert }}
+	nv := driver.NamedValue{Name: "a", Value: 1}
+	if err := CheckNamedValue(&nv, failing); !errors.Is(err, errConvert) || !strings.Contains(err.Error(), "argument a") {
+		t.Errorf("CheckNamedValue() error = %v, want %v", err, errConvert)
+	}
+}
@@ -0,0 +1,37 @@
+package shared
+
+import (
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy configures how requests that failed with a transient error are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a request is sent, including the first attempt.
+	// 0 and 1 disable retries; negative values are invalid.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. It doubles with every further retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between retries. Zero means no cap.
+	MaxBackoff time.Duration
+	// Retryable reports whether a request that failed with err may be retried. If nil, the driver
+	// retries transport errors, HTTP 502, 503 and 504 responses and expired streams.
+	Retryable func(err error) bool
+}
+
+// Backoff returns the delay before the given retry, starting at 1. The exponential delay is
+// jittered to a random duration between its half and its full length, so clients that failed
+// together don't retry together.
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < retry && (p.MaxBackoff == 0 || backoff < p.MaxBackoff); i++ {
+		backoff *= 2
+	}
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
@@ -0,0 +1,30 @@
+package shared
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	tests := []struct {
+		retry int
+		want  time.Duration
+	}{
+		{retry: 1, want: 100 * time.Millisecond},
+		{retry: 2, want: 200 * time.Millisecond},
+		{retry: 4, want: 800 * time.Millisecond},
+		{retry: 5, want: time.Second},
+		{retry: 100, want: time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 10; i++ {
+			if got := p.Backoff(tt.retry); got < tt.want/2 || got > tt.want {
+				t.Errorf("Backoff(%d) = %v, want between %v and %v", tt.retry, got, tt.want/2, tt.want)
+			}
+		}
+	}
+	if got := (RetryPolicy{}).Backoff(3); got != 0 {
+		t.Errorf("Backoff() without a backoff = %v, want 0", got)
+	}
+}
@@ -4,12 +4,14 @@ import (
 	"database/sql/driver"
 	"fmt"
 	"io"
+	"reflect"
 )
 
 type rowsProvider interface {
 	SetsCount() int
 	RowsCount(setIdx int) int
 	Columns(setIdx int) []string
+	ColumnTypes(setIdx int) []ColumnType
 	FieldValue(setIdx, rowIdx int, columnIdx int) driver.Value
 	Error(setIdx int) string
 	HasResult(setIdx int) bool
@@ -23,12 +25,42 @@ type rows struct {
 	result                rowsProvider
 	currentResultSetIndex int
 	currentRowIdx         int
+	// columnTypes caches the types of the columns of the current result set.
+	columnTypes []ColumnType
 }
 
 func (r *rows) Columns() []string {
 	return r.result.Columns(r.currentResultSetIndex)
 }
 
+func (r *rows) columnType(index int) ColumnType {
+	if r.columnTypes == nil {
+		r.columnTypes = r.result.ColumnTypes(r.currentResultSetIndex)
+	}
+	if index >= len(r.columnTypes) {
+		return ColumnType{ScanType: AnyType}
+	}
+	return r.columnTypes[index]
+}
+
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
+	return r.columnType(index).DatabaseTypeName
+}
+
+func (r *rows) ColumnTypeScanType(index int) reflect.Type {
+	return r.columnType(index).ScanType
+}
+
+func (r *rows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	ct := r.columnType(index)
+	return ct.Nullable, ct.NullableOk
+}
+
+func (r *rows) ColumnTypeLength(index int) (length int64, ok bool) {
+	ct := r.columnType(index)
+	return ct.Length, ct.LengthOk
+}
+
 func (r *rows) Close() error {
 	return nil
 }
@@ -56,6 +88,7 @@ func (r *rows) NextResultSet() error {
 
 	r.currentResultSetIndex++
 	r.currentRowIdx = 0
+	r.columnTypes = nil
 
 	errStr := r.result.Error(r.currentResultSetIndex)
 	if errStr != "" {
@@ -1,6 +1,7 @@
 package shared
 
 import (
+	"bytes"
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
@@ -150,6 +151,18 @@ func ConvertArgs(args []driver.NamedValue) (Params, error) {
 	return parameters, nil
 }
 
+// IsTransactionStatement reports whether stmt begins or ends a transaction. Such statements are
+// skipped when a script is executed in a transaction of its own.
+func IsTransactionStatement(stmt string) bool {
+	patterns := [][]byte{[]byte("begin"), []byte("commit"), []byte("end"), []byte("rollback")}
+	for _, p := range patterns {
+		if len(stmt) >= len(p) && bytes.Equal(bytes.ToLower([]byte(stmt[0:len(p)])), p) {
+			return true
+		}
+	}
+	return false
+}
+
 func isExplain(stmt string) bool {
 	statementStream := antlr.NewInputStream(stmt)
 
@@ -0,0 +1,144 @@
+package shared
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// TimeFormat is how time.Time arguments are written to the database.
+type TimeFormat int
+
+const (
+	// TimeFormatSQLite writes text like "2006-01-02 15:04:05.999999999-07:00", which the SQLite date
+	// and time functions understand.
+	TimeFormatSQLite TimeFormat = iota
+	// TimeFormatRFC3339 writes text in the RFC 3339 format with nanoseconds.
+	TimeFormatRFC3339
+	// TimeFormatUnixSeconds writes integer seconds since the Unix epoch.
+	TimeFormatUnixSeconds
+	// TimeFormatUnixMillis writes integer milliseconds since the Unix epoch.
+	TimeFormatUnixMillis
+	// TimeFormatJulianDay writes the fractional Julian day number, like the julianday() function.
+	TimeFormatJulianDay
+)
+
+const sqliteTimeFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+// unixEpochJulianDay is the Julian day number of 1970-01-01 00:00:00 UTC.
+const unixEpochJulianDay = 2440587.5
+
+// timeLayouts are the layouts that text is parsed with, in order.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// TimeEncoding configures how times are written to and read from the database.
+type TimeEncoding struct {
+	// Format is how time.Time arguments are written.
+	Format TimeFormat
+	// DeclTypes are the declared column types whose values are read as time.Time, compared case
+	// insensitively. If nil, TIMESTAMP and DATETIME columns are read as times. Text is parsed in
+	// any of the text formats; numbers are read only if Format is a numeric format.
+	DeclTypes []string
+	// Location is the location of times read from text without an offset and of times read from
+	// numbers. If nil, UTC is used.
+	Location *time.Location
+}
+
+// Validate reports whether the encoding can be used.
+func (e TimeEncoding) Validate() error {
+	if e.Format < TimeFormatSQLite || e.Format > TimeFormatJulianDay {
+		return fmt.Errorf("unknown time format %d", e.Format)
+	}
+	return nil
+}
+
+// Encode converts t to the value that is written to the database.
+func (e TimeEncoding) Encode(t time.Time) driver.Value {
+	switch e.Format {
+	case TimeFormatRFC3339:
+		return t.Format(time.RFC3339Nano)
+	case TimeFormatUnixSeconds:
+		return t.Unix()
+	case TimeFormatUnixMillis:
+		return t.UnixMilli()
+	case TimeFormatJulianDay:
+		return float64(t.UnixMilli())/(24*60*60*1000) + unixEpochJulianDay
+	default:
+		return t.Format(sqliteTimeFormat)
+	}
+}
+
+// IsTimeColumn reports whether values of columns declared with declType are read as times.
+func (e TimeEncoding) IsTimeColumn(declType string) bool {
+	if e.DeclTypes == nil {
+		return strings.EqualFold(declType, "timestamp") || strings.EqualFold(declType, "datetime")
+	}
+	for _, t := range e.DeclTypes {
+		if strings.EqualFold(declType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodesNumbers reports whether integers and floats in time columns are read as times.
+func (e TimeEncoding) DecodesNumbers() bool {
+	return e.Format == TimeFormatUnixSeconds || e.Format == TimeFormatUnixMillis || e.Format == TimeFormatJulianDay
+}
+
+// Decode reads v, which was read from a column declared with declType, as a time. It returns false
+// if the column is not a time column or v can't be read as a time.
+func (e TimeEncoding) Decode(declType string, v any) (time.Time, bool) {
+	if !e.IsTimeColumn(declType) {
+		return time.Time{}, false
+	}
+	loc := e.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	switch v := v.(type) {
+	case string:
+		for _, layout := range timeLayouts {
+			if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+				return t, true
+			}
+		}
+	case int64:
+		switch e.Format {
+		case TimeFormatUnixSeconds:
+			return time.Unix(v, 0).In(loc), true
+		case TimeFormatUnixMillis:
+			return time.UnixMilli(v).In(loc), true
+		case TimeFormatJulianDay:
+			return julianDayToTime(float64(v)).In(loc), true
+		}
+	case float64:
+		switch e.Format {
+		case TimeFormatUnixSeconds:
+			return time.UnixMilli(int64(math.Round(v * 1000))).In(loc), true
+		case TimeFormatUnixMillis:
+			return time.UnixMilli(int64(math.Round(v))).In(loc), true
+		case TimeFormatJulianDay:
+			return julianDayToTime(v).In(loc), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// julianDayToTime converts a Julian day number to a time, rounded to milliseconds like SQLite does.
+func julianDayToTime(day float64) time.Time {
+	return time.UnixMilli(int64(math.Round((day - unixEpochJulianDay) * 24 * 60 * 60 * 1000)))
+}
@@ -0,0 +1,59 @@
+package shared
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+func TestTimeEncodingEncode(t *testing.T) {
+	tm := time.Date(2024, 2, 3, 4, 5, 6, 7_000_000, time.UTC)
+	tests := []struct {
+		format TimeFormat
+		want   driver.Value
+	}{
+		{format: TimeFormatSQLite, want: "2024-02-03 04:05:06.007+00:00"},
+		{format: TimeFormatRFC3339, want: "2024-02-03T04:05:06.007Z"},
+		{format: TimeFormatUnixSeconds, want: int64(1706933106)},
+		{format: TimeFormatUnixMillis, want: int64(1706933106007)},
+	}
+	for _, tt := range tests {
+		if got := (TimeEncoding{Format: tt.format}).Encode(tm); got != tt.want {
+			t.Errorf("Encode() with format %d = %#v, want %#v", tt.format, got, tt.want)
+		}
+	}
+
+	julian := TimeEncoding{Format: TimeFormatJulianDay}
+	day := julian.Encode(tm).(float64)
+	if got, ok := (TimeEncoding{Format: TimeFormatJulianDay, DeclTypes: []string{"REAL"}}).Decode("real", day); !ok || !got.Equal(tm) {
+		t.Errorf("Julian day %v decoded to %v, %v, want %v", day, got, ok, tm)
+	}
+}
+
+func TestTimeEncodingDecode(t *testing.T) {
+	berlin := time.FixedZone("Berlin", 3600)
+	tests := []struct {
+		name     string
+		encoding TimeEncoding
+		declType string
+		value    any
+		want     time.Time
+		ok       bool
+	}{
+		{name: "default text", declType: "DATETIME", value: "2024-02-03 04:05:06", want: time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC), ok: true},
+		{name: "default ignores DATE", declType: "DATE", value: "2024-02-03"},
+		{name: "default ignores numbers", declType: "TIMESTAMP", value: int64(1706933106)},
+		{name: "custom decltype", encoding: TimeEncoding{DeclTypes: []string{"date"}}, declType: "DATE", value: "2024-02-03", want: time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC), ok: true},
+		{name: "location", encoding: TimeEncoding{Location: berlin}, declType: "timestamp", value: "2024-02-03 04:05", want: time.Date(2024, 2, 3, 4, 5, 0, 0, berlin), ok: true},
+		{name: "offset wins over location", encoding: TimeEncoding{Location: berlin}, declType: "timestamp", value: "2024-02-03T04:05:06Z", want: time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC), ok: true},
+		{name: "unix seconds", encoding: TimeEncoding{Format: TimeFormatUnixSeconds}, declType: "TIMESTAMP", value: int64(1706933106), want: time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC), ok: true},
+		{name: "unix millis", encoding: TimeEncoding{Format: TimeFormatUnixMillis}, declType: "TIMESTAMP", value: int64(1706933106007), want: time.Date(2024, 2, 3, 4, 5, 6, 7_000_000, time.UTC), ok: true},
+		{name: "unparsable text", declType: "TIMESTAMP", value: "yesterday"},
+	}
+	for _, tt := range tests {
+		got, ok := tt.encoding.Decode(tt.declType, tt.value)
+		if ok != tt.ok || !got.Equal(tt.want) || ok && got.Location().String() != tt.want.Location().String() {
+			t.Errorf("%s: Decode() = %v, %v, want %v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package shared
+
+import "context"
+
+// TokenProvider returns the auth token that is sent to the server. refresh is true when the server
+// rejected the previous token, in which case the provider should obtain a new one.
+type TokenProvider func(ctx context.Context, refresh bool) (string, error)
+
+// StaticToken returns a TokenProvider that always returns jwt.
+func StaticToken(jwt string) TokenProvider {
+	return func(context.Context, bool) (string, error) {
+		return jwt, nil
+	}
+}
@@ -0,0 +1,57 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+)
+
+// TxMode is the locking mode of a transaction.
+type TxMode int
+
+const (
+	// TxDeferred starts the transaction without taking any lock. The write lock is taken by the
+	// first write, which fails if another connection holds it.
+	TxDeferred TxMode = iota
+	// TxImmediate takes the write lock when the transaction starts.
+	TxImmediate
+	// TxExclusive takes the write lock when the transaction starts and also keeps other
+	// connections from reading in journal modes other than WAL.
+	TxExclusive
+)
+
+type txModeKey struct{}
+
+// ContextWithTxMode returns a context that makes BeginTx start transactions in mode.
+func ContextWithTxMode(ctx context.Context, mode TxMode) context.Context {
+	return context.WithValue(ctx, txModeKey{}, mode)
+}
+
+// BeginStatement returns the statement that starts a transaction with opts and the mode stored in
+// ctx.
+func BeginStatement(ctx context.Context, opts driver.TxOptions) (string, error) {
+	switch sql.IsolationLevel(opts.Isolation) {
+	case sql.LevelDefault, sql.LevelSerializable:
+		// SQLite transactions are always serializable.
+	default:
+		return "", fmt.Errorf("isolation level %s is not supported", sql.IsolationLevel(opts.Isolation))
+	}
+	mode, _ := ctx.Value(txModeKey{}).(TxMode)
+	if opts.ReadOnly {
+		if mode != TxDeferred {
+			return "", fmt.Errorf("read only transactions can't take the write lock")
+		}
+		return "BEGIN TRANSACTION READONLY", nil
+	}
+	switch mode {
+	case TxDeferred:
+		return "BEGIN", nil
+	case TxImmediate:
+		return "BEGIN IMMEDIATE", nil
+	case TxExclusive:
+		return "BEGIN EXCLUSIVE", nil
+	default:
+		return "", fmt.Errorf("unknown transaction mode %d", mode)
+	}
+}
@@ -0,0 +1,42 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+)
+
+func TestBeginStatement(t *testing.T) {
+	if got, err := BeginStatement(context.Background(), driver.TxOptions{}); err != nil || got != "BEGIN" {
+		t.Errorf("BeginStatement() without a mode = %q, %v, want BEGIN", got, err)
+	}
+
+	tests := []struct {
+		name    string
+		mode    TxMode
+		opts    driver.TxOptions
+		want    string
+		wantErr bool
+	}{
+		{name: "serializable", opts: driver.TxOptions{Isolation: driver.IsolationLevel(sql.LevelSerializable)}, want: "BEGIN"},
+		{name: "read only", opts: driver.TxOptions{ReadOnly: true}, want: "BEGIN TRANSACTION READONLY"},
+		{name: "deferred", mode: TxDeferred, want: "BEGIN"},
+		{name: "immediate", mode: TxImmediate, want: "BEGIN IMMEDIATE"},
+		{name: "exclusive", mode: TxExclusive, want: "BEGIN EXCLUSIVE"},
+		{name: "read only immediate", mode: TxImmediate, opts: driver.TxOptions{ReadOnly: true}, wantErr: true},
+		{name: "read committed", opts: driver.TxOptions{Isolation: driver.IsolationLevel(sql.LevelReadCommitted)}, wantErr: true},
+		{name: "unknown mode", mode: TxMode(42), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BeginStatement(ContextWithTxMode(context.Background(), tt.mode), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BeginStatement() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("BeginStatement() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
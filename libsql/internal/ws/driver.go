@@ -3,54 +3,17 @@ package ws
 import (
 	"context"
 	"database/sql/driver"
-	"io"
-	"sort"
-)
-
-type result struct {
-	id      int64
-	changes int64
-}
-
-func (r *result) LastInsertId() (int64, error) {
-	return r.id, nil
-}
-
-func (r *result) RowsAffected() (int64, error) {
-	return r.changes, nil
-}
-
-type rows struct {
-	res           *execResponse
-	currentRowIdx int
-}
-
-func (r *rows) Columns() []string {
-	return r.res.columns()
-}
+	"errors"
+	"fmt"
 
-func (r *rows) Close() error {
-	return nil
-}
-
-func (r *rows) Next(dest []driver.Value) error {
-	if r.currentRowIdx == r.res.rowsCount() {
-		return io.EOF
-	}
-	count := r.res.rowLen(r.currentRowIdx)
-	for idx := 0; idx < count; idx++ {
-		v, err := r.res.value(r.currentRowIdx, idx)
-		if err != nil {
-			return err
-		}
-		dest[idx] = v
-	}
-	r.currentRowIdx++
-	return nil
-}
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/hrana"
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
+)
 
 type conn struct {
-	ws *websocketConn
+	ws       *websocketConn
+	describe bool
+	codec    shared.Codec
 }
 
 func Connect(url string, jwt string) (*conn, error) {
@@ -58,20 +21,33 @@ func Connect(url string, jwt string) (*conn, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &conn{c}, nil
+	return &conn{ws: c}, nil
 }
 
 type stmt struct {
-	c     *conn
-	query string
+	c        *conn
+	query    string
+	numInput int
+	// sqlId is the id the query is stored under on the session, or 0 if it isn't stored.
+	sqlId int32
 }
 
 func (s stmt) Close() error {
-	return nil
+	if s.sqlId == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultWSTimeout)
+	defer cancel()
+	err := s.c.ws.closeSql(ctx, s.sqlId)
+	if errors.Is(err, driver.ErrBadConn) {
+		// The stored SQL is gone together with the session.
+		return nil
+	}
+	return err
 }
 
 func (s stmt) NumInput() int {
-	return -1
+	return s.numInput
 }
 
 func convertToNamed(args []driver.Value) []driver.NamedValue {
@@ -94,11 +70,25 @@ func (s stmt) Query(args []driver.Value) (driver.Rows, error) {
 }
 
 func (s stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
-	return s.c.ExecContext(ctx, s.query, args)
+	if s.sqlId == 0 {
+		return s.c.ExecContext(ctx, s.query, args)
+	}
+	resp, err := s.c.ws.executeStored(ctx, s.sqlId, s.query, args, false)
+	if err != nil {
+		return nil, err
+	}
+	return execResult(s.query, resp)
 }
 
 func (s stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
-	return s.c.QueryContext(ctx, s.query, args)
+	if s.sqlId == 0 {
+		return s.c.QueryContext(ctx, s.query, args)
+	}
+	resp, err := s.c.ws.executeStored(ctx, s.sqlId, s.query, args, true)
+	if err != nil {
+		return nil, err
+	}
+	return s.c.queryRows(s.query, resp)
 }
 
 func (c *conn) Ping() error {
@@ -106,22 +96,85 @@ func (c *conn) Ping() error {
 }
 
 func (c *conn) PingContext(ctx context.Context) error {
-	_, err := c.ws.exec(ctx, "SELECT 1", params{}, false)
+	_, err := c.ws.executeStmt(ctx, "SELECT 1", nil, false)
 	return err
 }
 
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	return c.codec.CheckNamedValue(nv)
+}
+
+// TimeEncoding returns how the connection writes and reads times.
+func (c *conn) TimeEncoding() shared.TimeEncoding {
+	return c.codec.Time
+}
+
 func (c *conn) Prepare(query string) (driver.Stmt, error) {
 	return c.PrepareContext(context.Background(), query)
 }
 
-func (c *conn) PrepareContext(_ context.Context, query string) (driver.Stmt, error) {
-	return stmt{c, query}, nil
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	numInput := -1
+	if c.describe {
+		desc, err := c.Describe(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		numInput = len(desc.Params)
+	}
+	s := stmt{c: c, query: query, numInput: numInput}
+	// A single statement is stored on the session, so that its SQL is sent only once. Queries with
+	// several statements are sent as a batch on every execution.
+	if c.ws.version >= 2 && isSingleStatement(query) {
+		sqlId, err := c.ws.storeSql(ctx, query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare SQL: %s\n%w", query, err)
+		}
+		s.sqlId = sqlId
+	}
+	return s, nil
+}
+
+func isSingleStatement(query string) bool {
+	stmts, _, err := shared.ParseStatement(query)
+	return err == nil && len(stmts) == 1
+}
+
+// Describe asks the server for the parameters and result columns of query without executing it.
+func (c *conn) Describe(ctx context.Context, query string) (*hrana.DescribeResult, error) {
+	desc, err := c.ws.describe(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe SQL: %s\n%w", query, err)
+	}
+	return desc, nil
 }
 
 func (c *conn) Close() error {
 	return c.ws.Close()
 }
 
+// ResetSession rolls back a transaction that was left open on the stream, for example by an
+// explicit BEGIN, before the connection is used again. The server is only asked whether a
+// transaction is open after a statement that may have opened one. Servers that speak only hrana1
+// or hrana2 can't tell, so their connections are reused as they are.
+func (c *conn) ResetSession(ctx context.Context) error {
+	if c.ws.version < 3 || !c.ws.mayBeInTx {
+		return nil
+	}
+	isAutocommit, err := c.ws.getAutocommit(ctx)
+	if err == nil && !isAutocommit {
+		_, err = c.ExecContext(ctx, "ROLLBACK", nil)
+	}
+	if err == nil {
+		c.ws.mayBeInTx = false
+	}
+	if err != nil && !errors.Is(err, driver.ErrBadConn) {
+		// Closing the stream ends the transaction, too.
+		return fmt.Errorf("%w: %s", driver.ErrBadConn, err.Error())
+	}
+	return err
+}
+
 type tx struct {
 	c *conn
 }
@@ -146,49 +199,98 @@ func (c *conn) Begin() (driver.Tx, error) {
 	return c.BeginTx(context.Background(), driver.TxOptions{})
 }
 
-func (c *conn) BeginTx(ctx context.Context, _ driver.TxOptions) (driver.Tx, error) {
-	_, err := c.ExecContext(ctx, "BEGIN", nil)
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	begin, err := shared.BeginStatement(ctx, opts)
+	if err != nil {
+		return tx{nil}, err
+	}
+	_, err = c.ExecContext(ctx, begin, nil)
 	if err != nil {
 		return tx{nil}, err
 	}
 	return tx{c}, nil
 }
 
-func convertArgs(args []driver.NamedValue) params {
-	if len(args) == 0 {
-		return params{}
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	resp, err := c.ws.executeStmt(ctx, query, args, false)
+	if err != nil {
+		return nil, err
 	}
-	positionalArgs := [](*driver.NamedValue){}
-	namedArgs := []namedParam{}
-	for idx := range args {
-		if len(args[idx].Name) > 0 {
-			namedArgs = append(namedArgs, namedParam{args[idx].Name, args[idx].Value})
-		} else {
-			positionalArgs = append(positionalArgs, &args[idx])
+	return execResult(query, resp)
+}
+
+func execResult(query string, resp *hrana.StreamResponse) (driver.Result, error) {
+	switch resp.Type {
+	case "execute":
+		res, err := resp.ExecuteResult()
+		if err != nil {
+			return nil, err
 		}
+		return shared.NewResult(res.GetLastInsertRowId(), int64(res.AffectedRowCount)), nil
+	case "batch":
+		res, err := resp.BatchResult()
+		if err != nil {
+			return nil, err
+		}
+		lastInsertRowId := int64(0)
+		affectedRowCount := int64(0)
+		// The last step is the rollback that runs only if the script fails.
+		for idx := 0; idx < len(res.StepResults)-1; idx++ {
+			r := res.StepResults[idx]
+			if r == nil {
+				continue
+			}
+			rowId := r.GetLastInsertRowId()
+			if rowId > 0 {
+				lastInsertRowId = rowId
+			}
+			affectedRowCount += int64(r.AffectedRowCount)
+		}
+		return shared.NewResult(lastInsertRowId, affectedRowCount), nil
+	default:
+		return nil, fmt.Errorf("failed to execute SQL: %s\n%s", query, "unknown response type")
 	}
-	sort.Slice(positionalArgs, func(i, j int) bool {
-		return positionalArgs[i].Ordinal < positionalArgs[j].Ordinal
-	})
-	posArgs := [](any){}
-	for idx := range positionalArgs {
-		posArgs = append(posArgs, positionalArgs[idx].Value)
-	}
-	return params{PositinalArgs: posArgs, NamedArgs: namedArgs}
 }
 
-func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
-	res, err := c.ws.exec(ctx, query, convertArgs(args), false)
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	resp, err := c.ws.executeStmt(ctx, query, args, true)
 	if err != nil {
 		return nil, err
 	}
-	return &result{res.lastInsertId(), res.affectedRowCount()}, nil
+	return c.queryRows(query, resp)
 }
 
-func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
-	res, err := c.ws.exec(ctx, query, convertArgs(args), true)
+func (c *conn) queryRows(query string, resp *hrana.StreamResponse) (driver.Rows, error) {
+	switch resp.Type {
+	case "execute":
+		res, err := resp.ExecuteResult()
+		if err != nil {
+			return nil, err
+		}
+		return shared.NewRows(hrana.NewStmtResultRowsProvider(res, c.codec.Time)), nil
+	case "batch":
+		res, err := resp.BatchResult()
+		if err != nil {
+			return nil, err
+		}
+		if len(res.StepResults) > 0 {
+			res.StepResults = res.StepResults[:len(res.StepResults)-1]
+		}
+		if len(res.StepErrors) > 0 {
+			res.StepErrors = res.StepErrors[:len(res.StepErrors)-1]
+		}
+		return shared.NewRows(hrana.NewBatchResultRowsProvider(res, c.codec.Time)), nil
+	default:
+		return nil, fmt.Errorf("failed to execute SQL: %s\n%s", query, "unknown response type")
+	}
+}
+
+// ExecuteBatch executes batch in a single request. Errors of individual steps are returned in the
+// result.
+func (c *conn) ExecuteBatch(ctx context.Context, batch *hrana.Batch) (*hrana.BatchResult, error) {
+	res, err := c.ws.batch(ctx, batch)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to execute batch: %w", err)
 	}
-	return &rows{res, 0}, nil
+	return res, nil
 }
@@ -0,0 +1,57 @@
+package ws
+
+import (
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/hrana"
+)
+
+// subprotocols lists the Hrana versions we speak over WebSockets, most preferred first.
+var subprotocols = []string{"hrana3", "hrana2", "hrana1"}
+
+func versionFromSubprotocol(subprotocol string) int {
+	switch subprotocol {
+	case "hrana3":
+		return 3
+	case "hrana2":
+		return 2
+	default:
+		// Servers that only speak hrana1 may not confirm the subprotocol at all.
+		return 1
+	}
+}
+
+type helloMsg struct {
+	Type string  `json:"type"`
+	Jwt  *string `json:"jwt"`
+}
+
+type requestMsg struct {
+	Type      string   `json:"type"`
+	RequestId uint32   `json:"request_id"`
+	Request   *request `json:"request"`
+}
+
+// request is a request sent over the WebSocket. Stream requests are the same as in the HTTP
+// pipeline, except that they also name the stream they belong to.
+type request struct {
+	hrana.StreamRequest
+	StreamId int32 `json:"stream_id"`
+}
+
+type serverMsg struct {
+	Type      string                `json:"type"`
+	RequestId *uint32               `json:"request_id,omitempty"`
+	Response  *hrana.StreamResponse `json:"response,omitempty"`
+	Error     *hrana.Error          `json:"error,omitempty"`
+}
+
+func openStream(streamId int32) *request {
+	return &request{StreamRequest: hrana.StreamRequest{Type: "open_stream"}, StreamId: streamId}
+}
+
+func closeStream(streamId int32) *request {
+	return &request{StreamRequest: hrana.StreamRequest{Type: "close_stream"}, StreamId: streamId}
+}
+
+func streamRequest(streamId int32, req hrana.StreamRequest) *request {
+	return &request{StreamRequest: req, StreamId: streamId}
+}
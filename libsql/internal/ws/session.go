@@ -0,0 +1,285 @@
+package ws
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/hrana"
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
+)
+
+var errSessionClosed = errors.New("websocket session is closed")
+
+// helloError is returned when the server rejects the hello message, usually because of the auth token.
+type helloError struct {
+	message string
+}
+
+func (e *helloError) Error() string {
+	return "handshake error: " + e.message
+}
+
+// session is a WebSocket connection to a Hrana server. Every driver connection opens its own stream
+// on the session, so many connections share a single socket. Responses are read by a single
+// goroutine and handed to the request that is waiting for them.
+type session struct {
+	conn *websocket.Conn
+	// version is the Hrana version negotiated with the server.
+	version    int
+	requestIds *idPool
+	streamIds  *idPool
+	// sqlIds hands out the ids of SQL stored with store_sql, which belongs to the session rather
+	// than to a stream.
+	sqlIds *idPool
+
+	mu sync.Mutex
+	// pending maps the ids of requests that were sent to the channels their responses go to.
+	pending map[uint32]chan *serverMsg
+	// streams is the number of open streams. The socket is closed when the last stream is closed.
+	streams int
+	// err is the reason the session can no longer be used.
+	err error
+}
+
+// dialSession dials a session authenticated with a token from token. If the server rejects the token,
+// a refreshed one is requested and the session is dialed once more with it.
+func dialSession(ctx context.Context, url string, token shared.TokenProvider, client *http.Client) (*session, error) {
+	jwt, err := token(ctx, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get auth token: %w", err)
+	}
+	s, err := dial(ctx, url, jwt, client)
+	var rejected *helloError
+	if !errors.As(err, &rejected) {
+		return s, err
+	}
+	refreshed, refreshErr := token(ctx, true)
+	if refreshErr != nil {
+		return nil, fmt.Errorf("failed to refresh auth token: %w", refreshErr)
+	}
+	if refreshed == jwt {
+		return nil, err
+	}
+	return dial(ctx, url, refreshed, client)
+}
+
+func dial(ctx context.Context, url string, jwt string, client *http.Client) (*session, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultWSTimeout)
+	defer cancel()
+	c, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{
+		HTTPClient:   client,
+		Subprotocols: subprotocols,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.SetReadLimit(1024 * 1024 * 16) // 16MB
+
+	hello := helloMsg{Type: "hello"}
+	if jwt != "" {
+		hello.Jwt = &jwt
+	}
+	if err = wsjson.Write(ctx, c, hello); err != nil {
+		c.Close(websocket.StatusInternalError, err.Error())
+		return nil, err
+	}
+
+	var helloResp serverMsg
+	err = wsjson.Read(ctx, c, &helloResp)
+	if err != nil {
+		c.Close(websocket.StatusInternalError, err.Error())
+		return nil, err
+	}
+	if helloResp.Type != "hello_ok" {
+		if helloResp.Type == "hello_error" {
+			err = &helloError{message: errorMsg(helloResp.Error)}
+		} else {
+			err = fmt.Errorf("handshake error: unexpected %s message", helloResp.Type)
+		}
+		c.Close(websocket.StatusProtocolError, err.Error())
+		return nil, err
+	}
+
+	s := &session{
+		conn:       c,
+		version:    versionFromSubprotocol(c.Subprotocol()),
+		requestIds: newIDPool(),
+		streamIds:  newIDPool(),
+		sqlIds:     newIDPool(),
+		pending:    make(map[uint32]chan *serverMsg),
+	}
+	go s.readResponses()
+	return s, nil
+}
+
+func (s *session) readResponses() {
+	for {
+		var msg serverMsg
+		if err := wsjson.Read(context.Background(), s.conn, &msg); err != nil {
+			s.fail(err)
+			return
+		}
+		var ch chan *serverMsg
+		if msg.RequestId != nil {
+			s.mu.Lock()
+			ch = s.pending[*msg.RequestId]
+			delete(s.pending, *msg.RequestId)
+			s.mu.Unlock()
+		}
+		if ch == nil {
+			err := fmt.Errorf("unexpected %s message", msg.Type)
+			s.fail(err)
+			s.conn.Close(websocket.StatusProtocolError, err.Error())
+			return
+		}
+		// The id is recycled only now, because a request that was cancelled by its context still
+		// occupies it until the server responds.
+		s.requestIds.Put(*msg.RequestId)
+		ch <- &msg
+	}
+}
+
+// fail marks the session as unusable and wakes up all requests that wait for a response.
+func (s *session) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	pending := s.pending
+	s.pending = make(map[uint32]chan *serverMsg)
+	s.mu.Unlock()
+	for _, ch := range pending {
+		close(ch)
+	}
+}
+
+func (s *session) badConn() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fmt.Errorf("%w: %s", driver.ErrBadConn, s.err.Error())
+}
+
+func (s *session) sendRequest(ctx context.Context, req *request) (*hrana.StreamResponse, error) {
+	requestId := s.requestIds.Get()
+	ch := make(chan *serverMsg, 1)
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		s.requestIds.Put(requestId)
+		return nil, s.badConn()
+	}
+	s.pending[requestId] = ch
+	s.mu.Unlock()
+
+	err := wsjson.Write(ctx, s.conn, requestMsg{Type: "request", RequestId: requestId, Request: req})
+	if err != nil {
+		// A failed write leaves the socket in an unknown state, so it can't be used anymore.
+		s.fail(err)
+		s.conn.Close(websocket.StatusInternalError, err.Error())
+		return nil, fmt.Errorf("%w: %s", driver.ErrBadConn, err.Error())
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, s.badConn()
+		}
+		return checkResponse(resp, requestId)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// acquire reserves a stream on the session. It reports false if the session is closed.
+func (s *session) acquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return false
+	}
+	s.streams++
+	return true
+}
+
+// release gives back a stream reserved by acquire.
+func (s *session) release() {
+	s.mu.Lock()
+	s.streams--
+	last := s.streams == 0 && s.err == nil
+	if last {
+		s.err = errSessionClosed
+	}
+	s.mu.Unlock()
+	if last {
+		s.conn.Close(websocket.StatusNormalClosure, "All's good")
+	}
+}
+
+// openStream opens a stream on a session reserved by acquire.
+func (s *session) openStream(ctx context.Context) (*websocketConn, error) {
+	streamId := int32(s.streamIds.Get())
+	if _, err := s.sendRequest(ctx, openStream(streamId)); err != nil {
+		s.streamIds.Put(uint32(streamId))
+		s.release()
+		return nil, fmt.Errorf("unable to open stream: %w", err)
+	}
+	return &websocketConn{session: s, streamId: streamId, version: s.version}, nil
+}
+
+// Connector opens connections that share a single WebSocket session. A new session is dialed when
+// there is none yet or the previous one was closed.
+type Connector struct {
+	url    string
+	token  shared.TokenProvider
+	client *http.Client
+	// describe makes connections describe statements when they are prepared.
+	describe bool
+	// codec converts arguments and results.
+	codec shared.Codec
+
+	mu      sync.Mutex
+	session *session
+}
+
+// NewConnector creates a Connector. Every session is authenticated with a token from token. The
+// WebSocket handshake is sent with client, or with http.DefaultClient if client is nil. If describe
+// is set, statements are described by the server when they are prepared. Arguments and results
+// are converted with codec.
+func NewConnector(url string, token shared.TokenProvider, client *http.Client, describe bool, codec shared.Codec) *Connector {
+	return &Connector{url: url, token: token, client: client, describe: describe, codec: codec}
+}
+
+func (c *Connector) Connect(ctx context.Context) (*conn, error) {
+	s, err := c.acquireSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ws, err := s.openStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{ws: ws, describe: c.describe, codec: c.codec}, nil
+}
+
+func (c *Connector) acquireSession(ctx context.Context) (*session, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.session != nil && c.session.acquire() {
+		return c.session, nil
+	}
+	s, err := dialSession(ctx, c.url, c.token, c.client)
+	if err != nil {
+		return nil, err
+	}
+	s.acquire()
+	c.session = s
+	return s, nil
+}
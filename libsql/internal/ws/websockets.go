@@ -3,257 +3,219 @@ package ws
 import (
 	"context"
 	"database/sql/driver"
-	"encoding/base64"
+	"errors"
 	"fmt"
-	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/coder/websocket"
-	"github.com/coder/websocket/wsjson"
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/hrana"
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
 )
 
 // defaultWSTimeout specifies the timeout used for initial http connection
 var defaultWSTimeout = 120 * time.Second
 
-func errorMsg(errorResp interface{}) string {
-	return errorResp.(map[string]interface{})["error"].(map[string]interface{})["message"].(string)
-}
-
-func isErrorResp(resp interface{}) bool {
-	return resp.(map[string]interface{})["type"] == "response_error"
+func errorMsg(err *hrana.Error) string {
+	if err == nil {
+		return "unknown error"
+	}
+	return err.Message
 }
 
+// websocketConn is a Hrana stream opened on a session.
 type websocketConn struct {
-	conn   *websocket.Conn
-	idPool *idPool
-}
-
-type namedParam struct {
-	Name  string
-	Value any
+	session  *session
+	streamId int32
+	// version is the Hrana version negotiated with the server.
+	version int
+	// mayBeInTx is set when a statement that opens a transaction was executed on the stream. It is
+	// cleared once the stream is known to be in autocommit mode again.
+	mayBeInTx bool
 }
 
-type params struct {
-	PositinalArgs []any
-	NamedArgs     []namedParam
-}
-
-func convertValue(v any) (map[string]interface{}, error) {
-	res := map[string]interface{}{}
-	if v == nil {
-		res["type"] = "null"
-	} else if integer, ok := v.(int64); ok {
-		res["type"] = "integer"
-		res["value"] = strconv.FormatInt(integer, 10)
-	} else if text, ok := v.(string); ok {
-		res["type"] = "text"
-		res["value"] = text
-	} else if blob, ok := v.([]byte); ok {
-		res["type"] = "blob"
-		res["base64"] = base64.StdEncoding.WithPadding(base64.NoPadding).EncodeToString(blob)
-	} else if float, ok := v.(float64); ok {
-		res["type"] = "float"
-		res["value"] = float
-	} else if boolean, ok := v.(bool); ok {
-		res["type"] = "integer"
-		if boolean {
-			res["value"] = "1"
-		} else {
-			res["value"] = "0"
+// opensTransaction reports whether one of stmts may leave a transaction open.
+func opensTransaction(stmts []string) bool {
+	for _, stmt := range stmts {
+		keyword, _, _ := strings.Cut(strings.TrimSpace(stmt), " ")
+		if strings.EqualFold(keyword, "BEGIN") || strings.EqualFold(keyword, "SAVEPOINT") {
+			return true
 		}
-	} else {
-		return nil, fmt.Errorf("unsupported value type: %s", v)
 	}
-	return res, nil
+	return false
 }
 
-type execResponse struct {
-	resp map[string]interface{}
+// checkResponse returns the response carried by msg if it answers the request with requestId.
+func checkResponse(msg *serverMsg, requestId uint32) (*hrana.StreamResponse, error) {
+	if msg.RequestId == nil || *msg.RequestId != requestId {
+		return nil, fmt.Errorf("%w: unexpected %s message", driver.ErrBadConn, msg.Type)
+	}
+	switch msg.Type {
+	case "response_ok":
+		if msg.Response == nil {
+			return nil, fmt.Errorf("%w: response_ok message without a response", driver.ErrBadConn)
+		}
+		return msg.Response, nil
+	case "response_error":
+		return nil, hrana.NewServerError(msg.Error)
+	default:
+		return nil, fmt.Errorf("%w: unexpected %s message", driver.ErrBadConn, msg.Type)
+	}
 }
 
-func (r *execResponse) affectedRowCount() int64 {
-	return int64(r.resp["affected_row_count"].(float64))
+func (ws *websocketConn) streamRequest(ctx context.Context, req hrana.StreamRequest) (*hrana.StreamResponse, error) {
+	resp, err := ws.session.sendRequest(ctx, streamRequest(ws.streamId, req))
+	if err != nil {
+		return nil, err
+	}
+	if resp.Type != req.Type {
+		return nil, fmt.Errorf("unexpected response type %s to %s request", resp.Type, req.Type)
+	}
+	return resp, nil
 }
 
-func (r *execResponse) lastInsertId() int64 {
-	id, ok := r.resp["last_insert_rowid"].(string)
-	if !ok {
-		return 0
+func (ws *websocketConn) requireVersion(version int, request string) error {
+	if ws.version < version {
+		return fmt.Errorf("%s requests require hrana%d, but the server only supports hrana%d", request, version, ws.version)
 	}
-	value, _ := strconv.ParseInt(id, 10, 64)
-	return value
+	return nil
 }
 
-func (r *execResponse) columns() []string {
-	res := []string{}
-	cols := r.resp["cols"].([]interface{})
-	for idx := range cols {
-		var v string = ""
-		if cols[idx].(map[string]interface{})["name"] != nil {
-			v = cols[idx].(map[string]interface{})["name"].(string)
-		}
-		res = append(res, v)
+func (ws *websocketConn) execute(ctx context.Context, stmt *hrana.Stmt) (*hrana.StmtResult, error) {
+	resp, err := ws.streamRequest(ctx, hrana.StreamRequest{Type: "execute", Stmt: stmt})
+	if err != nil {
+		return nil, err
 	}
-	return res
+	return resp.ExecuteResult()
 }
 
-func (r *execResponse) rowsCount() int {
-	return len(r.resp["rows"].([]interface{}))
+func (ws *websocketConn) batch(ctx context.Context, batch *hrana.Batch) (*hrana.BatchResult, error) {
+	resp, err := ws.streamRequest(ctx, hrana.StreamRequest{Type: "batch", Batch: batch})
+	if err != nil {
+		return nil, err
+	}
+	return resp.BatchStepsResult()
 }
 
-func (r *execResponse) rowLen(rowIdx int) int {
-	return len(r.resp["rows"].([]interface{})[rowIdx].([]interface{}))
+func (ws *websocketConn) describe(ctx context.Context, sql string) (*hrana.DescribeResult, error) {
+	if err := ws.requireVersion(2, "describe"); err != nil {
+		return nil, err
+	}
+	resp, err := ws.streamRequest(ctx, hrana.DescribeStream(sql))
+	if err != nil {
+		return nil, err
+	}
+	return resp.DescribeResult()
 }
 
-func (r *execResponse) value(rowIdx int, colIdx int) (any, error) {
-	val := r.resp["rows"].([]interface{})[rowIdx].([]interface{})[colIdx].(map[string]interface{})
-	switch val["type"] {
-	case "null":
-		return nil, nil
-	case "integer":
-		v, err := strconv.ParseInt(val["value"].(string), 10, 64)
-		if err != nil {
-			return nil, err
-		}
-		return v, nil
-	case "text":
-		return val["value"].(string), nil
-	case "blob":
-		base64Encoded := val["base64"].(string)
-		v, err := base64.StdEncoding.WithPadding(base64.NoPadding).DecodeString(base64Encoded)
-		if err != nil {
-			return nil, err
+// storeSql stores sql on the session under a new id, which statements of every stream can refer to.
+func (ws *websocketConn) storeSql(ctx context.Context, sql string) (int32, error) {
+	if err := ws.requireVersion(2, "store_sql"); err != nil {
+		return 0, err
+	}
+	sqlId := int32(ws.session.sqlIds.Get())
+	if _, err := ws.streamRequest(ctx, hrana.StoreSqlStream(sql, sqlId)); err != nil {
+		// The id can be reused only if the server is known not to hold it.
+		var serverErr *hrana.ServerError
+		if errors.As(err, &serverErr) {
+			ws.session.sqlIds.Put(uint32(sqlId))
 		}
-		return v, nil
-	case "float":
-		return val["value"].(float64), nil
+		return 0, err
 	}
-	return nil, fmt.Errorf("unrecognized value type: %s", val["type"])
+	return sqlId, nil
 }
 
-func (ws *websocketConn) exec(ctx context.Context, sql string, sqlParams params, wantRows bool) (*execResponse, error) {
-	requestId := ws.idPool.Get()
-	defer ws.idPool.Put(requestId)
-	stmt := map[string]interface{}{
-		"sql":       sql,
-		"want_rows": wantRows,
-	}
-	if len(sqlParams.PositinalArgs) > 0 {
-		args := []map[string]interface{}{}
-		for idx := range sqlParams.PositinalArgs {
-			v, err := convertValue(sqlParams.PositinalArgs[idx])
-			if err != nil {
-				return nil, err
-			}
-			args = append(args, v)
-		}
-		stmt["args"] = args
+// closeSql removes the SQL stored under sqlId from the session and gives back its id.
+func (ws *websocketConn) closeSql(ctx context.Context, sqlId int32) error {
+	if _, err := ws.streamRequest(ctx, hrana.CloseStoredSqlStream(sqlId)); err != nil {
+		return err
 	}
-	if len(sqlParams.NamedArgs) > 0 {
-		args := []map[string]interface{}{}
-		for idx := range sqlParams.NamedArgs {
-			v, err := convertValue(sqlParams.NamedArgs[idx].Value)
-			if err != nil {
-				return nil, err
-			}
-			arg := map[string]interface{}{
-				"name":  sqlParams.NamedArgs[idx].Name,
-				"value": v,
-			}
-			args = append(args, arg)
-		}
-		stmt["named_args"] = args
+	ws.session.sqlIds.Put(uint32(sqlId))
+	return nil
+}
+
+func (ws *websocketConn) getAutocommit(ctx context.Context) (bool, error) {
+	if err := ws.requireVersion(3, "get_autocommit"); err != nil {
+		return false, err
 	}
-	err := wsjson.Write(ctx, ws.conn, map[string]interface{}{
-		"type":       "request",
-		"request_id": requestId,
-		"request": map[string]interface{}{
-			"type":      "execute",
-			"stream_id": 0,
-			"stmt":      stmt,
-		},
-	})
+	resp, err := ws.streamRequest(ctx, hrana.GetAutocommitStream())
 	if err != nil {
-		return nil, fmt.Errorf("%w: %s", driver.ErrBadConn, err.Error())
+		return false, err
 	}
+	return resp.AutocommitResult()
+}
 
-	var resp interface{}
-	if err = wsjson.Read(ctx, ws.conn, &resp); err != nil {
-		return nil, fmt.Errorf("%w: %s", driver.ErrBadConn, err.Error())
+// executeStmt runs the statements of query. A single statement is sent as an execute request and
+// several statements as a batch that stops at the first failing statement.
+func (ws *websocketConn) executeStmt(ctx context.Context, query string, args []driver.NamedValue, wantRows bool) (*hrana.StreamResponse, error) {
+	stmts, params, err := shared.ParseStatementAndArgs(query, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL:\n%w", err)
 	}
-
-	if isErrorResp(resp) {
-		err = fmt.Errorf("unable to execute %s: %s", sql, errorMsg(resp))
-		return nil, err
+	ws.mayBeInTx = ws.mayBeInTx || opensTransaction(stmts)
+	var req *hrana.StreamRequest
+	if len(stmts) == 1 {
+		var p *shared.Params
+		if len(params) > 0 {
+			p = &params[0]
+		}
+		req, err = hrana.ExecuteStream(stmts[0], p, wantRows)
+	} else {
+		req, err = hrana.BatchStream(stmts, params, wantRows, true)
 	}
-
-	return &execResponse{resp.(map[string]interface{})["response"].(map[string]interface{})["result"].(map[string]interface{})}, nil
-}
-
-func (ws *websocketConn) Close() error {
-	return ws.conn.Close(websocket.StatusNormalClosure, "All's good")
-}
-
-func connect(url string, jwt string) (*websocketConn, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), defaultWSTimeout)
-	defer cancel()
-	c, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{
-		Subprotocols: []string{"hrana1"},
-	})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to execute SQL:\n%w", err)
 	}
-
-	c.SetReadLimit(1024 * 1024 * 16) // 16MB
-
-	err = wsjson.Write(ctx, c, map[string]interface{}{
-		"type": "hello",
-		"jwt":  jwt,
-	})
+	resp, err := ws.streamRequest(ctx, *req)
 	if err != nil {
-		c.Close(websocket.StatusInternalError, err.Error())
-		return nil, err
+		return nil, fmt.Errorf("unable to execute %s: %w", query, err)
 	}
+	return resp, nil
+}
 
-	err = wsjson.Write(ctx, c, map[string]interface{}{
-		"type":       "request",
-		"request_id": 0,
-		"request": map[string]interface{}{
-			"type":      "open_stream",
-			"stream_id": 0,
-		},
-	})
+// executeStored runs query, which is stored on the session under sqlId.
+func (ws *websocketConn) executeStored(ctx context.Context, sqlId int32, query string, args []driver.NamedValue, wantRows bool) (*hrana.StreamResponse, error) {
+	stmts, params, err := shared.ParseStatementAndArgs(query, args)
 	if err != nil {
-		c.Close(websocket.StatusInternalError, err.Error())
-		return nil, err
+		return nil, fmt.Errorf("failed to execute SQL:\n%w", err)
 	}
-
-	var helloResp interface{}
-	err = wsjson.Read(ctx, c, &helloResp)
+	ws.mayBeInTx = ws.mayBeInTx || opensTransaction(stmts)
+	var p shared.Params
+	if len(params) > 0 {
+		p = params[0]
+	}
+	req, err := hrana.ExecuteStoredStream(sqlId, p, wantRows)
 	if err != nil {
-		c.Close(websocket.StatusInternalError, err.Error())
-		return nil, err
+		return nil, fmt.Errorf("failed to execute SQL:\n%w", err)
 	}
-	if helloResp.(map[string]interface{})["type"] == "hello_error" {
-		err = fmt.Errorf("handshake error: %s", errorMsg(helloResp))
-		c.Close(websocket.StatusProtocolError, err.Error())
-		return nil, err
+	resp, err := ws.streamRequest(ctx, *req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute %s: %w", query, err)
 	}
+	return resp, nil
+}
 
-	var openStreamResp interface{}
-	err = wsjson.Read(ctx, c, &openStreamResp)
-	if err != nil {
-		c.Close(websocket.StatusInternalError, err.Error())
-		return nil, err
+func (ws *websocketConn) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultWSTimeout)
+	defer cancel()
+	_, err := ws.session.sendRequest(ctx, closeStream(ws.streamId))
+	ws.session.streamIds.Put(uint32(ws.streamId))
+	ws.session.release()
+	if errors.Is(err, driver.ErrBadConn) {
+		// The stream is gone together with the session.
+		return nil
 	}
+	return err
+}
 
-	if isErrorResp(openStreamResp) {
-		err = fmt.Errorf("unable to open stream: %s", errorMsg(helloResp))
-		c.Close(websocket.StatusProtocolError, err.Error())
+// connect opens a stream on a new session, which is closed together with the stream.
+func connect(url string, jwt string) (*websocketConn, error) {
+	s, err := dialSession(context.Background(), url, shared.StaticToken(jwt), nil)
+	if err != nil {
 		return nil, err
 	}
-	return &websocketConn{c, newIDPool()}, nil
+	s.acquire()
+	return s.openStream(context.Background())
 }
 
 // Below is modified IDPool from "vitess.io/vitess/go/pools"
@@ -1,136 +1,462 @@
 package ws
 
 import (
-	"fmt"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	_ "modernc.org/sqlite"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/hrana"
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
+	"github.com/tursodatabase/libsql-client-go/libsql/libsqltest"
 )
 
-func TestConvertValue(t *testing.T) {
+// testServer is a minimal Hrana server. handle returns the response to a request as raw JSON, or
+// an error message that is sent back as response_error. Requests are handled concurrently.
+type testServer struct {
+	subprotocols []string
+	handle       func(req map[string]any) (response string, errMsg string)
+	// jwt is the token the hello message must carry, if set.
+	jwt string
+	// sockets counts the WebSocket connections that were accepted.
+	sockets atomic.Int32
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{Subprotocols: s.subprotocols})
+	if err != nil {
+		return
+	}
+	s.sockets.Add(1)
+	defer c.Close(websocket.StatusNormalClosure, "")
+	ctx := r.Context()
+	var hello map[string]any
+	if err := wsjson.Read(ctx, c, &hello); err != nil || hello["type"] != "hello" {
+		return
+	}
+	if s.jwt != "" && hello["jwt"] != s.jwt {
+		_ = wsjson.Write(ctx, c, map[string]any{"type": "hello_error", "error": map[string]any{"message": "invalid token"}})
+		return
+	}
+	if err := wsjson.Write(ctx, c, map[string]any{"type": "hello_ok"}); err != nil {
+		return
+	}
+	for {
+		var msg struct {
+			RequestId uint32         `json:"request_id"`
+			Request   map[string]any `json:"request"`
+		}
+		if err := wsjson.Read(ctx, c, &msg); err != nil {
+			return
+		}
+		go func() {
+			var resp map[string]any
+			if reqType := msg.Request["type"]; reqType == "open_stream" || reqType == "close_stream" {
+				resp = map[string]any{"type": "response_ok", "request_id": msg.RequestId, "response": map[string]any{"type": reqType}}
+			} else if response, errMsg := s.handle(msg.Request); errMsg != "" {
+				resp = map[string]any{"type": "response_error", "request_id": msg.RequestId, "error": map[string]any{"message": errMsg}}
+			} else {
+				resp = map[string]any{"type": "response_ok", "request_id": msg.RequestId, "response": json.RawMessage(response)}
+			}
+			_ = wsjson.Write(ctx, c, resp)
+		}()
+	}
+}
+
+func connectTestServer(t *testing.T, s *testServer) *websocketConn {
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	c, err := connect("ws"+strings.TrimPrefix(srv.URL, "http"), "")
+	if err != nil {
+		t.Fatalf("connect() error = %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// newDatabaseServer returns a Hrana server backed by a SQLite database, for the tests that don't
+// depend on how the server speaks the protocol.
+func newDatabaseServer(t *testing.T) *libsqltest.Server {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "server.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	srv := libsqltest.NewServer(db)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func connectDatabaseServer(t *testing.T, srv *libsqltest.Server) *websocketConn {
+	c, err := connect(srv.WebSocketURL(), "")
+	if err != nil {
+		t.Fatalf("connect() error = %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestConnectNegotiatesVersion(t *testing.T) {
 	tests := []struct {
-		name  string
-		value any
-		want  map[string]any
-		err   error
+		name         string
+		subprotocols []string
+		want         int
 	}{
-		{
-			name:  "nil",
-			value: nil,
-			want: map[string]any{
-				"type": "null",
-			},
-			err: nil,
-		},
-		{
-			name:  "integer",
-			value: int64(42),
-			want: map[string]any{
-				"type":  "integer",
-				"value": "42",
-			},
-			err: nil,
-		},
-		{
-			name:  "text",
-			value: "turso for win",
-			want: map[string]any{
-				"type":  "text",
-				"value": "turso for win",
-			},
-			err: nil,
-		},
-		{
-			name:  "blob",
-			value: []byte("hello world"),
-			want: map[string]any{
-				"type": "blob",
-				// `hello world` encoded is `aGVsbG8gd29ybGQ=` but we want without padding
-				"base64": "aGVsbG8gd29ybGQ",
-			},
-			err: nil,
-		},
-		{
-			name:  "float",
-			value: 3.14,
-			want: map[string]any{
-				"type":  "float",
-				"value": 3.14,
-			},
-			err: nil,
-		},
-		{
-			name:  "boolean_true",
-			value: true,
-			want: map[string]any{
-				"type":  "integer",
-				"value": "1",
-			},
-			err: nil,
-		},
-		{
-			name:  "boolean_false",
-			value: false,
-			want: map[string]any{
-				"type":  "integer",
-				"value": "0",
-			},
-			err: nil,
-		},
-		{
-			name:  "unsupported",
-			value: struct{}{},
-			want:  nil,
-			err:   fmt.Errorf("unsupported value type: %s", struct{}{}),
-		},
+		{name: "hrana3", subprotocols: []string{"hrana3", "hrana2", "hrana1"}, want: 3},
+		{name: "hrana2", subprotocols: []string{"hrana2", "hrana1"}, want: 2},
+		{name: "hrana1", subprotocols: []string{"hrana1"}, want: 1},
+		{name: "unconfirmed", subprotocols: nil, want: 1},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := convertValue(tt.value)
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("got %v, want %v", got, tt.want)
-			}
-			if !reflect.DeepEqual(err, tt.err) {
-				t.Errorf("got error %v, want %v", err, tt.err)
+			c := connectTestServer(t, &testServer{subprotocols: tt.subprotocols})
+			if c.version != tt.want {
+				t.Errorf("version = %d, want %d", c.version, tt.want)
 			}
 		})
 	}
 }
 
-func Test_execResponse_lastInsertId(t *testing.T) {
-	tests := []struct {
-		name  string
-		value map[string]interface{}
-		want  int64
-	}{
-		{
-			name:  "valid",
-			value: map[string]interface{}{"last_insert_rowid": "42"},
-			want:  42,
-		},
-		{
-			name:  "empty",
-			value: map[string]interface{}{},
-			want:  0,
-		},
-		{
-			name:  "invalid",
-			value: map[string]interface{}{"last_insert_rowid": "invalid"},
-			want:  0,
-		},
-		{
-			name:  "invalid_type",
-			value: map[string]interface{}{"last_insert_rowid": 42.0},
-			want:  0,
+func TestRequests(t *testing.T) {
+	c := connectDatabaseServer(t, newDatabaseServer(t))
+	ctx := context.Background()
+
+	if _, err := c.executeStmt(ctx, "CREATE TABLE t (a INTEGER)", nil, false); err != nil {
+		t.Fatalf("executeStmt() error = %v", err)
+	}
+	resp, err := c.executeStmt(ctx, "INSERT INTO t VALUES (42)", nil, false)
+	if err != nil {
+		t.Fatalf("executeStmt() error = %v", err)
+	}
+	res, err := resp.ExecuteResult()
+	if err != nil {
+		t.Fatalf("ExecuteResult() error = %v", err)
+	}
+	if res.AffectedRowCount != 1 || res.GetLastInsertRowId() != 1 {
+		t.Errorf("ExecuteResult() = %#v", res)
+	}
+	resp, err = c.executeStmt(ctx, "SELECT a FROM t", nil, true)
+	if err != nil {
+		t.Fatalf("executeStmt() error = %v", err)
+	}
+	res, err = resp.ExecuteResult()
+	if err != nil {
+		t.Fatalf("ExecuteResult() error = %v", err)
+	}
+	if len(res.Rows) != 1 || res.Rows[0][0].ToValue(nil) != int64(42) {
+		t.Errorf("ExecuteResult() = %#v", res)
+	}
+	if _, err := c.executeStmt(ctx, "SELECT x", nil, true); err == nil || !strings.HasPrefix(err.Error(), "unable to execute SELECT x: ") || !strings.Contains(err.Error(), "no such column: x") {
+		t.Errorf("executeStmt() error = %v", err)
+	}
+
+	insert, query, first := "INSERT INTO t VALUES (43)", "SELECT 1", int32(0)
+	var b hrana.Batch
+	b.Add(hrana.Stmt{Sql: &insert}, nil)
+	b.Add(hrana.Stmt{Sql: &query}, &hrana.BatchCondition{Type: "not", Cond: &hrana.BatchCondition{Type: "ok", Step: &first}})
+	batch, err := c.batch(ctx, &b)
+	if err != nil {
+		t.Fatalf("batch() error = %v", err)
+	}
+	if len(batch.StepResults) != 2 || batch.StepResults[0].AffectedRowCount != 1 || batch.StepResults[1] != nil {
+		t.Errorf("batch() = %#v", batch)
+	}
+
+	describe, err := c.describe(ctx, "SELECT :a AS a")
+	if err != nil {
+		t.Fatalf("describe() error = %v", err)
+	}
+	if len(describe.Params) != 1 || *describe.Params[0].Name != ":a" || len(describe.Cols) != 1 || !describe.IsReadonly {
+		t.Errorf("describe() = %#v", describe)
+	}
+
+	isAutocommit, err := c.getAutocommit(ctx)
+	if err != nil || !isAutocommit {
+		t.Errorf("getAutocommit() = %v, %v", isAutocommit, err)
+	}
+	sqlId, err := c.storeSql(ctx, "SELECT count(*) FROM t")
+	if err != nil {
+		t.Fatalf("storeSql() error = %v", err)
+	}
+	resp, err = c.executeStored(ctx, sqlId, "SELECT count(*) FROM t", nil, true)
+	if err != nil {
+		t.Fatalf("executeStored() error = %v", err)
+	}
+	if res, err := resp.ExecuteResult(); err != nil || len(res.Rows) != 1 || res.Rows[0][0].ToValue(nil) != int64(2) {
+		t.Errorf("ExecuteResult() = %#v, %v", res, err)
+	}
+	if err := c.closeSql(ctx, sqlId); err != nil {
+		t.Errorf("closeSql() error = %v", err)
+	}
+	if _, err := c.executeStored(ctx, sqlId, "SELECT count(*) FROM t", nil, true); err == nil {
+		t.Errorf("executeStored() succeeded after closeSql()")
+	}
+}
+
+func TestRequestsRequireVersion(t *testing.T) {
+	c := connectTestServer(t, &testServer{
+		subprotocols: []string{"hrana1"},
+		handle: func(req map[string]any) (string, string) {
+			t.Errorf("unexpected %s request sent to a hrana1 server", req["type"])
+			return "", "unexpected request"
 		},
+	})
+	ctx := context.Background()
+	if _, err := c.describe(ctx, "SELECT 1"); err == nil || !strings.Contains(err.Error(), "require hrana2") {
+		t.Errorf("describe() error = %v", err)
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			r := &execResponse{
-				resp: tt.value,
-			}
-			if got := r.lastInsertId(); got != tt.want {
-				t.Errorf("lastInsertId() = %v, want %v", got, tt.want)
+	if _, err := c.getAutocommit(ctx); err == nil || !strings.Contains(err.Error(), "require hrana3") {
+		t.Errorf("getAutocommit() error = %v", err)
+	}
+}
+
+func TestMalformedResponse(t *testing.T) {
+	c := connectTestServer(t, &testServer{
+		subprotocols: []string{"hrana3"},
+		handle: func(req map[string]any) (string, string) {
+			if req["type"] == "execute" {
+				return `{"type":"execute","result":{"cols":"oops"}}`, ""
 			}
-		})
+			return `{"type":"sequence"}`, ""
+		},
+	})
+	ctx := context.Background()
+	resp, err := c.executeStmt(ctx, "SELECT 1", nil, true)
+	if err != nil {
+		t.Fatalf("executeStmt() error = %v", err)
+	}
+	if _, err := resp.ExecuteResult(); err == nil {
+		t.Errorf("ExecuteResult() expected an error for a malformed result")
+	}
+	if _, err := c.describe(ctx, "SELECT 1"); err == nil || !strings.Contains(err.Error(), "unexpected response type sequence") {
+		t.Errorf("describe() error = %v", err)
+	}
+}
+
+func TestMultiStatement(t *testing.T) {
+	srv := newDatabaseServer(t)
+	c := &conn{ws: connectDatabaseServer(t, srv)}
+	ctx := context.Background()
+	if _, err := c.ExecContext(ctx, "CREATE TABLE t (a); CREATE TABLE u (b)", nil); err != nil {
+		t.Fatalf("ExecContext() error = %v", err)
+	}
+	srv.Requests()
+
+	args := []driver.NamedValue{{Ordinal: 1, Value: int64(1)}, {Ordinal: 2, Value: "x"}}
+	res, err := c.ExecContext(ctx, "INSERT INTO t VALUES (?); INSERT INTO u VALUES (?), ('y')", args)
+	if err != nil {
+		t.Fatalf("ExecContext() error = %v", err)
+	}
+	// The statements are sent as one batch, which ends with a rollback that only runs if a statement
+	// failed.
+	requests := srv.Requests()
+	want := []string{"INSERT INTO t VALUES (?)", "INSERT INTO u VALUES (?), ('y')", "ROLLBACK"}
+	if len(requests) != 1 || requests[0].Type != "batch" || !reflect.DeepEqual(requests[0].Steps, want) {
+		t.Errorf("requests = %+v, want a batch of %q", requests, want)
+	}
+	if id, _ := res.LastInsertId(); id != 2 {
+		t.Errorf("LastInsertId() = %d, want 2", id)
+	}
+	if n, _ := res.RowsAffected(); n != 3 {
+		t.Errorf("RowsAffected() = %d, want 3", n)
+	}
+
+	rows, err := c.QueryContext(ctx, "SELECT a FROM t; SELECT b FROM u", nil)
+	if err != nil {
+		t.Fatalf("QueryContext() error = %v", err)
+	}
+	defer rows.Close()
+	multi := rows.(driver.RowsNextResultSet)
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil || dest[0] != int64(1) {
+		t.Errorf("first result set row = %v, %v", dest[0], err)
+	}
+	if !multi.HasNextResultSet() {
+		t.Fatalf("HasNextResultSet() = false, want the second statement")
+	}
+	if err := multi.NextResultSet(); err != nil {
+		t.Fatalf("NextResultSet() error = %v", err)
+	}
+	var got []driver.Value
+	for rows.Next(dest) == nil {
+		got = append(got, dest[0])
+	}
+	// Each statement got its own argument.
+	if len(got) != 2 || got[0] != "x" || got[1] != "y" {
+		t.Errorf("second result set = %v", got)
+	}
+	if multi.HasNextResultSet() {
+		t.Errorf("HasNextResultSet() = true, the rollback step must not be a result set")
+	}
+}
+
+func TestConnectorSharesSession(t *testing.T) {
+	db := newDatabaseServer(t)
+	db.SetHook(func(req libsqltest.Request) *libsqltest.Error {
+		if req.SQL == "SELECT 'slow'" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return nil
+	})
+	var sockets atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sockets.Add(1)
+		db.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	connector := NewConnector("ws"+strings.TrimPrefix(srv.URL, "http"), shared.StaticToken(""), nil, false, shared.Codec{})
+	ctx := context.Background()
+
+	conns := make([]*conn, 3)
+	for idx := range conns {
+		c, err := connector.Connect(ctx)
+		if err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		conns[idx] = c
+	}
+	if got := sockets.Load(); got != 1 {
+		t.Errorf("connections opened %d sockets, want 1", got)
+	}
+	if conns[0].ws.streamId == conns[1].ws.streamId {
+		t.Errorf("connections share stream %d", conns[0].ws.streamId)
+	}
+
+	// The slow query must not hold up the fast one on the other stream.
+	slow := make(chan error, 1)
+	go func() {
+		_, err := conns[0].QueryContext(ctx, "SELECT 'slow'", nil)
+		slow <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	rows, err := conns[1].QueryContext(ctx, "SELECT 'fast'", nil)
+	if err != nil {
+		t.Fatalf("QueryContext() error = %v", err)
+	}
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil || dest[0] != "fast" {
+		t.Errorf("fast query returned %v, %v", dest[0], err)
+	}
+	select {
+	case <-slow:
+		t.Errorf("slow query finished before the fast one")
+	default:
+	}
+	if err := <-slow; err != nil {
+		t.Errorf("slow query error = %v", err)
+	}
+
+	for _, c := range conns {
+		if err := c.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+	}
+	// Once all connections are closed the session is gone and the next connection dials again.
+	c, err := connector.Connect(ctx)
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer c.Close()
+	if err := c.Ping(); err != nil {
+		t.Errorf("Ping() error = %v", err)
+	}
+	if got := sockets.Load(); got != 2 {
+		t.Errorf("sockets = %d, want a new socket after the session was closed", got)
+	}
+}
+
+func TestConnectorUsesHTTPClient(t *testing.T) {
+	srv := httptest.NewTLSServer(newDatabaseServer(t))
+	defer srv.Close()
+	url := "wss" + strings.TrimPrefix(srv.URL, "https")
+	ctx := context.Background()
+
+	if _, err := NewConnector(url, shared.StaticToken(""), nil, false, shared.Codec{}).Connect(ctx); err == nil {
+		t.Errorf("Connect() succeeded without trusting the server certificate")
+	}
+	c, err := NewConnector(url, shared.StaticToken(""), srv.Client(), false, shared.Codec{}).Connect(ctx)
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+func TestConnectorRefreshesToken(t *testing.T) {
+	s := &testServer{subprotocols: []string{"hrana3"}, jwt: "new"}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	ctx := context.Background()
+
+	if _, err := NewConnector(url, shared.StaticToken("old"), nil, false, shared.Codec{}).Connect(ctx); err == nil || !strings.Contains(err.Error(), "invalid token") {
+		t.Errorf("Connect() error = %v, want the hello error", err)
+	}
+	if got := s.sockets.Load(); got != 1 {
+		t.Errorf("sockets = %d, want no retry with an unchanged token", got)
+	}
+
+	current, refreshes := "old", 0
+	token := func(ctx context.Context, refresh bool) (string, error) {
+		if refresh {
+			refreshes++
+			current = "new"
+		}
+		return current, nil
+	}
+	c, err := NewConnector(url, token, nil, false, shared.Codec{}).Connect(ctx)
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer c.Close()
+	if refreshes != 1 {
+		t.Errorf("refreshes = %d, want 1", refreshes)
+	}
+}
+
+func TestBeginTx(t *testing.T) {
+	srv := newDatabaseServer(t)
+	c := &conn{ws: connectDatabaseServer(t, srv)}
+
+	tx, err := c.BeginTx(shared.ContextWithTxMode(context.Background(), shared.TxImmediate), driver.TxOptions{})
+	if err != nil {
+		t.Fatalf("BeginTx() error = %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	tx, err = c.BeginTx(context.Background(), driver.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("BeginTx() error = %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if _, err := c.BeginTx(context.Background(), driver.TxOptions{Isolation: driver.IsolationLevel(sql.LevelReadUncommitted)}); err == nil {
+		t.Errorf("BeginTx() accepted an unsupported isolation level")
+	}
+
+	var statements []string
+	for _, req := range srv.Requests() {
+		if req.Type == "execute" {
+			statements = append(statements, req.SQL)
+		}
+	}
+	want := []string{"BEGIN IMMEDIATE", "COMMIT", "BEGIN TRANSACTION READONLY", "ROLLBACK"}
+	if !reflect.DeepEqual(statements, want) {
+		t.Errorf("statements = %q, want %q", statements, want)
 	}
 }
@@ -0,0 +1,447 @@
+// Package libsqltest provides a Hrana server for tests. It serves a database/sql database over HTTP
+// and WebSockets, so that code using the libsql driver can be tested without a sqld instance:
+//
+//	db, _ := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+//	srv := libsqltest.NewServer(db)
+//	defer srv.Close()
+//	client, _ := sql.Open("libsql", srv.URL)
+//
+// Any SQLite driver for database/sql can back the server. Over HTTP, the server speaks Hrana 2 and
+// Hrana 3 with both the JSON and the protobuf encoding.
+package libsqltest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/hrana"
+)
+
+// Request describes a request received by the server, as passed to a Hook.
+type Request struct {
+	// Type is the type of the stream request, such as "execute" or "batch".
+	Type string
+	// SQL is the SQL of the request, if it has any. It is empty for statements that refer to SQL
+	// stored with store_sql.
+	SQL string
+	// Steps holds the SQL of the steps of a batch request.
+	Steps []string
+	// Args holds the positional arguments of the statement of the request, as nil, int64, float64,
+	// string or []byte values.
+	Args []any
+	// Stream identifies the stream of the request. Requests on the same stream have the same Stream.
+	Stream int
+	// WebSocket is true if the request was sent over a WebSocket.
+	WebSocket bool
+	// Protobuf is true if the request was encoded with protobuf.
+	Protobuf bool
+}
+
+// String returns the type of the request followed by its SQL, such as "execute SELECT 1".
+func (r Request) String() string {
+	if r.SQL == "" {
+		return r.Type
+	}
+	return r.Type + " " + r.SQL
+}
+
+// Error is an error that a Hook makes the server return instead of handling a request.
+type Error struct {
+	Message string
+	// Code is the Hrana error code, for example STREAM_EXPIRED. A STREAM_EXPIRED error also closes the
+	// stream of the request.
+	Code string
+	// HTTPStatus makes the whole HTTP request fail with this status. It is ignored on WebSockets.
+	HTTPStatus int
+}
+
+// Hook is called for every stream request before it is handled. If it returns an error, the request
+// fails with it. The steps of cursors are passed as execute requests.
+type Hook func(req Request) *Error
+
+// Server is a Hrana server listening on a loopback address.
+type Server struct {
+	// URL is the base URL of the server, of the form http://127.0.0.1:port.
+	URL string
+
+	db         *sql.DB
+	httpServer *httptest.Server
+
+	mu sync.Mutex
+	// streams maps batons to the HTTP streams waiting for their next request.
+	streams    map[string]*stream
+	nextBaton  int
+	nextStream int
+	// requests holds the requests received since the last call to Requests.
+	requests []Request
+	hook     Hook
+	baseURL  string
+	// noProtobuf makes the server answer as if it didn't support the protobuf encoding.
+	noProtobuf bool
+	// sockets holds the open WebSockets, so that their streams can be expired.
+	sockets map[*socket]struct{}
+}
+
+// NewServer starts a server for db. The server must be closed with Close.
+func NewServer(db *sql.DB) *Server {
+	s := &Server{db: db, streams: make(map[string]*stream), sockets: make(map[*socket]struct{})}
+	s.httpServer = httptest.NewServer(s)
+	s.URL = s.httpServer.URL
+	return s
+}
+
+// WebSocketURL returns the URL that connects to the server over a WebSocket.
+func (s *Server) WebSocketURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+// SetHook sets the hook that is called for every request. A nil hook removes it.
+func (s *Server) SetHook(hook Hook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hook = hook
+}
+
+// SetBaseURL makes HTTP responses tell clients to send further requests of the stream to baseURL.
+// An empty baseURL restores the default.
+func (s *Server) SetBaseURL(baseURL string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.baseURL = baseURL
+}
+
+// Requests returns the stream requests that the server received since it was started or since the
+// last call to Requests.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := s.requests
+	s.requests = nil
+	return requests
+}
+
+// SetProtobuf sets whether the server supports the protobuf encoding of Hrana 3, which it does by
+// default. Without it, clients that ask for protobuf fall back to JSON.
+func (s *Server) SetProtobuf(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.noProtobuf = !enabled
+}
+
+func (s *Server) protobuf() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.noProtobuf
+}
+
+// ExpireStreams closes all open streams, as a server does when streams are idle for too long. Later
+// requests on them fail with STREAM_EXPIRED.
+func (s *Server) ExpireStreams() {
+	s.mu.Lock()
+	streams := s.streams
+	s.streams = make(map[string]*stream)
+	sockets := make([]*socket, 0, len(s.sockets))
+	for sock := range s.sockets {
+		sockets = append(sockets, sock)
+	}
+	s.mu.Unlock()
+	for _, st := range streams {
+		st.close()
+	}
+	for _, sock := range sockets {
+		sock.expireStreams()
+	}
+}
+
+// Close shuts the server down and closes all streams. It doesn't close the database.
+func (s *Server) Close() {
+	s.httpServer.CloseClientConnections()
+	s.httpServer.Close()
+	s.ExpireStreams()
+}
+
+// intercept records req, a request on st, and passes it to the hook. It returns the error the hook
+// injects.
+func (s *Server) intercept(st *stream, req *hrana.StreamRequest, webSocket bool, protobuf bool) *Error {
+	r := Request{Type: req.Type, Stream: st.id, WebSocket: webSocket, Protobuf: protobuf}
+	switch {
+	case req.Sql != nil:
+		r.SQL = *req.Sql
+	case req.Stmt != nil && req.Stmt.Sql != nil:
+		r.SQL = *req.Stmt.Sql
+	}
+	if req.Stmt != nil {
+		for _, arg := range req.Stmt.Args {
+			v, _ := decodeValue(arg)
+			r.Args = append(r.Args, v)
+		}
+	}
+	if req.Batch != nil {
+		for _, step := range req.Batch.Steps {
+			var sql string
+			if step.Stmt.Sql != nil {
+				sql = *step.Stmt.Sql
+			}
+			r.Steps = append(r.Steps, sql)
+		}
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, r)
+	hook := s.hook
+	s.mu.Unlock()
+	if hook == nil {
+		return nil
+	}
+	return hook(r)
+}
+
+func (e *Error) hranaError() *hrana.Error {
+	return newError(e.Code, e.Message)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Header.Get("Upgrade") != "":
+		s.serveWebSocket(w, r)
+	case r.Method == http.MethodGet && r.URL.Path == "/v3":
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPost && (r.URL.Path == "/v2/pipeline" || r.URL.Path == "/v3/pipeline"):
+		s.servePipeline(w, r, false)
+	case r.Method == http.MethodPost && r.URL.Path == "/v3/cursor":
+		s.serveCursor(w, r, false)
+	case strings.HasPrefix(r.URL.Path, "/v3-protobuf") && !s.protobuf():
+		http.NotFound(w, r)
+	case r.Method == http.MethodGet && r.URL.Path == "/v3-protobuf":
+		w.WriteHeader(http.StatusOK)
+	case strings.HasPrefix(r.URL.Path, "/v3-protobuf/") && r.Header.Get("Content-Type") != protobufContentType:
+		writeError(w, http.StatusUnsupportedMediaType, newError("", "expected a body of type "+protobufContentType))
+	case r.Method == http.MethodPost && r.URL.Path == "/v3-protobuf/pipeline":
+		s.servePipeline(w, r, true)
+	case r.Method == http.MethodPost && r.URL.Path == "/v3-protobuf/cursor":
+		s.serveCursor(w, r, true)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+const protobufContentType = "application/x-protobuf"
+
+// protoMessage is a request body that can be decoded from protobuf.
+type protoMessage interface {
+	UnmarshalProto(data []byte) error
+}
+
+// readBody decodes the body of r into msg, from protobuf if protobuf is set and from JSON otherwise.
+func readBody(r *http.Request, protobuf bool, msg protoMessage) error {
+	if !protobuf {
+		return json.NewDecoder(r.Body).Decode(msg)
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	return msg.UnmarshalProto(data)
+}
+
+func writeError(w http.ResponseWriter, status int, err *hrana.Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(err)
+}
+
+// takeStream removes the stream of baton from the waiting streams, or opens a new one if baton is
+// empty. It returns nil if the baton doesn't refer to an open stream.
+func (s *Server) takeStream(ctx context.Context, baton string) (*stream, error) {
+	if baton == "" {
+		return s.newStream(ctx, newSqlStore())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.streams[baton]
+	delete(s.streams, baton)
+	return st, nil
+}
+
+// putStream makes st wait for its next request and returns the baton that refers to it.
+func (s *Server) putStream(st *stream) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextBaton++
+	baton := "baton-" + strconv.Itoa(s.nextBaton)
+	s.streams[baton] = st
+	return baton
+}
+
+func (s *Server) responseBaseURL() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.baseURL
+}
+
+// openStream takes the stream of baton and writes an error response if that fails.
+func (s *Server) openStream(w http.ResponseWriter, r *http.Request, baton string) *stream {
+	st, err := s.takeStream(r.Context(), baton)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, toError(err))
+		return nil
+	}
+	if st == nil {
+		writeError(w, http.StatusBadRequest, newError("STREAM_EXPIRED", "the stream has expired"))
+		return nil
+	}
+	return st
+}
+
+// closeOrPut closes st if closed is true and makes it wait for the next request otherwise. It
+// returns the baton of the stream, which is empty if it was closed.
+func (s *Server) closeOrPut(st *stream, closed bool) string {
+	if closed {
+		st.close()
+		return ""
+	}
+	return s.putStream(st)
+}
+
+func (s *Server) servePipeline(w http.ResponseWriter, r *http.Request, protobuf bool) {
+	var req hrana.PipelineRequest
+	if err := readBody(r, protobuf, &req); err != nil {
+		writeError(w, http.StatusBadRequest, newError("", err.Error()))
+		return
+	}
+	st := s.openStream(w, r, req.Baton)
+	if st == nil {
+		return
+	}
+	results := make([]map[string]any, 0, len(req.Requests))
+	closed := false
+	for idx := range req.Requests {
+		streamReq := &req.Requests[idx]
+		if injected := s.intercept(st, streamReq, false, protobuf); injected != nil {
+			if injected.HTTPStatus != 0 {
+				st.close()
+				writeError(w, injected.HTTPStatus, injected.hranaError())
+				return
+			}
+			results = append(results, map[string]any{"type": "error", "error": injected.hranaError()})
+			closed = closed || injected.Code == "STREAM_EXPIRED"
+			continue
+		}
+		if closed {
+			results = append(results, map[string]any{"type": "error", "error": newError("STREAM_EXPIRED", "the stream has expired")})
+			continue
+		}
+		resp, err := st.handle(r.Context(), streamReq)
+		if err != nil {
+			results = append(results, map[string]any{"type": "error", "error": err})
+			continue
+		}
+		results = append(results, map[string]any{"type": "ok", "response": resp})
+		closed = closed || streamReq.Type == "close"
+	}
+	resp := map[string]any{"results": results, "baton": nil}
+	if baton := s.closeOrPut(st, closed); baton != "" {
+		resp["baton"] = baton
+	}
+	if baseURL := s.responseBaseURL(); baseURL != "" {
+		resp["base_url"] = baseURL
+	}
+	if !protobuf {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+		return
+	}
+	// The response is built for JSON, so it goes through hrana.PipelineResponse to be encoded with
+	// protobuf.
+	body, err := json.Marshal(resp)
+	var pipelineResp hrana.PipelineResponse
+	if err == nil {
+		err = json.Unmarshal(body, &pipelineResp)
+	}
+	if err == nil {
+		body, err = pipelineResp.MarshalProto()
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, toError(err))
+		return
+	}
+	w.Header().Set("Content-Type", protobufContentType)
+	_, _ = w.Write(body)
+}
+
+// serveCursor executes a batch and sends its results as newline-delimited JSON, or as
+// length-delimited protobuf messages if protobuf is set. The entries are collected before the
+// response is written, so the stream is free again once its baton is sent.
+func (s *Server) serveCursor(w http.ResponseWriter, r *http.Request, protobuf bool) {
+	var req hrana.CursorRequest
+	if err := readBody(r, protobuf, &req); err != nil {
+		writeError(w, http.StatusBadRequest, newError("", err.Error()))
+		return
+	}
+	if req.Batch == nil {
+		writeError(w, http.StatusBadRequest, newError("", "cursor request has no batch"))
+		return
+	}
+	st := s.openStream(w, r, req.Baton)
+	if st == nil {
+		return
+	}
+	var entries []hrana.CursorEntry
+	closed := false
+	for idx := range req.Batch.Steps {
+		injected := s.intercept(st, &hrana.StreamRequest{Type: "execute", Stmt: &req.Batch.Steps[idx].Stmt}, false, protobuf)
+		if injected == nil {
+			continue
+		}
+		if injected.HTTPStatus != 0 {
+			st.close()
+			writeError(w, injected.HTTPStatus, injected.hranaError())
+			return
+		}
+		entries = append(entries, hrana.CursorEntry{Type: "error", Error: injected.hranaError()})
+		closed = injected.Code == "STREAM_EXPIRED"
+		break
+	}
+	if entries == nil {
+		st.batch(r.Context(), req.Batch, func(entry hrana.CursorEntry) {
+			entries = append(entries, entry)
+		})
+	}
+	resp := hrana.CursorResponse{Baton: s.closeOrPut(st, closed), BaseUrl: s.responseBaseURL()}
+	if !protobuf {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		_ = encoder.Encode(resp)
+		for _, entry := range entries {
+			_ = encoder.Encode(entry)
+		}
+		return
+	}
+	body, err := appendProtoMessage(nil, &resp)
+	for idx := 0; err == nil && idx < len(entries); idx++ {
+		body, err = appendProtoMessage(body, &entries[idx])
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, toError(err))
+		return
+	}
+	w.Header().Set("Content-Type", protobufContentType)
+	_, _ = w.Write(body)
+}
+
+// appendProtoMessage appends msg to buf as a length-delimited protobuf message.
+func appendProtoMessage(buf []byte, msg interface{ MarshalProto() ([]byte, error) }) ([]byte, error) {
+	data, err := msg.MarshalProto()
+	if err != nil {
+		return nil, err
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...), nil
+}
@@ -0,0 +1,235 @@
+package libsqltest_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/tursodatabase/libsql-client-go/libsql"
+	"github.com/tursodatabase/libsql-client-go/libsql/libsqltest"
+)
+
+func newServer(t *testing.T) *libsqltest.Server {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := libsqltest.NewServer(db)
+	t.Cleanup(func() {
+		srv.Close()
+		db.Close()
+	})
+	return srv
+}
+
+func TestServer(t *testing.T) {
+	srv := newServer(t)
+	for name, url := range map[string]string{"http": srv.URL, "ws": srv.WebSocketURL()} {
+		t.Run(name, func(t *testing.T) {
+			srv.SetHook(nil)
+			ctx := context.Background()
+			db, err := sql.Open("libsql", url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			if _, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS t_"+name+" (a INTEGER PRIMARY KEY, b TEXT)"); err != nil {
+				t.Fatal(err)
+			}
+			res, err := db.ExecContext(ctx, "INSERT INTO t_"+name+" (b) VALUES (?)", "x")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n, _ := res.RowsAffected(); n != 1 {
+				t.Errorf("got %d affected rows, want 1", n)
+			}
+			var b string
+			if err := db.QueryRowContext(ctx, "SELECT b FROM t_"+name+" WHERE a = 1").Scan(&b); err != nil || b != "x" {
+				t.Errorf("got %q, %v, want x", b, err)
+			}
+
+			_, err = db.ExecContext(ctx, "INSERT INTO t_"+name+" (a, b) VALUES (1, 'y')")
+			var libsqlErr *libsql.Error
+			if !errors.As(err, &libsqlErr) || libsqlErr.Code != "SQLITE_CONSTRAINT_UNIQUE" {
+				t.Errorf("got %v, want a SQLITE_CONSTRAINT_UNIQUE error", err)
+			}
+
+			var mu sync.Mutex
+			var steps []string
+			srv.SetHook(func(req libsqltest.Request) *libsqltest.Error {
+				if req.SQL == "SELECT 'busy'" {
+					return &libsqltest.Error{Message: "injected", Code: "SQLITE_BUSY"}
+				}
+				if req.Type == "batch" {
+					mu.Lock()
+					steps = append(steps, req.Steps...)
+					mu.Unlock()
+				}
+				return nil
+			})
+			if _, err := db.ExecContext(ctx, "SELECT 1; SELECT 2"); err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			if len(steps) < 2 || steps[0] != "SELECT 1" || steps[1] != "SELECT 2" {
+				t.Errorf("got batch steps %q, want the two statements", steps)
+			}
+			mu.Unlock()
+			err = db.QueryRowContext(ctx, "SELECT 'busy'").Scan(&b)
+			if !errors.As(err, &libsqlErr) || libsqlErr.Code != "SQLITE_BUSY" || libsqlErr.Message != "injected" {
+				t.Errorf("got %v, want the injected error", err)
+			}
+		})
+	}
+}
+
+func TestServerExpireStreams(t *testing.T) {
+	srv := newServer(t)
+	for name, url := range map[string]string{"http": srv.URL, "ws": srv.WebSocketURL()} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			db, err := sql.Open("libsql", url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+			if _, err := tx.ExecContext(ctx, "SELECT 1"); err != nil {
+				t.Fatal(err)
+			}
+			srv.ExpireStreams()
+			_, err = tx.ExecContext(ctx, "SELECT 1")
+			var libsqlErr *libsql.Error
+			if !errors.As(err, &libsqlErr) || libsqlErr.Code != "STREAM_EXPIRED" {
+				t.Errorf("got %v, want a STREAM_EXPIRED error", err)
+			}
+		})
+	}
+}
+
+func TestServerStorageClasses(t *testing.T) {
+	srv := newServer(t)
+	db, err := sql.Open("libsql", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, "CREATE TABLE t (d DATE, ts TIMESTAMP, b BOOLEAN)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO t VALUES ('2024-01-02', 1700000000, 1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"requests": [{"type": "execute", "stmt": {"sql": "SELECT d, ts, b FROM t;", "want_rows": true}}, {"type": "close"}]}`
+	resp, err := http.Post(srv.URL+"/v2/pipeline", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var pipeline struct {
+		Results []struct {
+			Response struct {
+				Result struct {
+					Cols []struct {
+						Decltype string `json:"decltype"`
+					} `json:"cols"`
+					Rows [][]struct {
+						Type  string `json:"type"`
+						Value any    `json:"value"`
+					} `json:"rows"`
+				} `json:"result"`
+			} `json:"response"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pipeline); err != nil {
+		t.Fatal(err)
+	}
+	result := pipeline.Results[0].Response.Result
+	got := make([]string, 0, 3)
+	for idx, value := range result.Rows[0] {
+		got = append(got, fmt.Sprintf("%s %s %v", result.Cols[idx].Decltype, value.Type, value.Value))
+	}
+	want := []string{"DATE text 2024-01-02", "TIMESTAMP integer 1700000000", "BOOLEAN integer 1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestServerRequests(t *testing.T) {
+	srv := newServer(t)
+	db, err := sql.Open("libsql", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "CREATE TABLE t (a, b)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ExecContext(ctx, "INSERT INTO t VALUES (?, ?)", int64(1), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	requests := srv.Requests()
+	if got, want := fmt.Sprintf("%q", requests), `["execute CREATE TABLE t (a, b)" "execute INSERT INTO t VALUES (?, ?)"]`; got != want {
+		t.Fatalf("got requests %s, want %s", got, want)
+	}
+	if requests[0].Stream != requests[1].Stream {
+		t.Errorf("got streams %d and %d, want the requests of a connection on one stream", requests[0].Stream, requests[1].Stream)
+	}
+	if want := []any{int64(1), []byte("x")}; !reflect.DeepEqual(requests[1].Args, want) {
+		t.Errorf("got arguments %#v, want %#v", requests[1].Args, want)
+	}
+	if requests := srv.Requests(); len(requests) != 0 {
+		t.Errorf("got requests %q, want the log to be cleared", requests)
+	}
+}
+
+func TestServerDescribe(t *testing.T) {
+	srv := newServer(t)
+	db, err := sql.Open("libsql", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	desc, err := libsql.Describe(ctx, conn, "SELECT ?, :a, '?' AS \"x?\", ?4 -- ?\n, :a, $b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"", ":a", "", "", "$b"}; !reflect.DeepEqual(desc.Params, want) {
+		t.Errorf("got params %q, want %q", desc.Params, want)
+	}
+	if len(desc.Columns) != 6 || !desc.IsReadOnly {
+		t.Errorf("got %+v, want 6 columns of a read-only statement", desc)
+	}
+}
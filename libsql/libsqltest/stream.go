@@ -0,0 +1,583 @@
+package libsqltest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/hrana"
+)
+
+// stream is a Hrana stream. It owns a connection of the database, so that transactions span the
+// requests of the stream.
+type stream struct {
+	id   int
+	conn *sql.Conn
+	// sqls holds the SQL stored with store_sql. Over HTTP it belongs to the stream, over a WebSocket
+	// it is shared by all streams of the socket.
+	sqls *sqlStore
+}
+
+// sqlStore holds SQL texts by their ids. It is shared by the streams of a WebSocket, which run
+// concurrently.
+type sqlStore struct {
+	mu   sync.Mutex
+	sqls map[int32]string
+}
+
+func newSqlStore() *sqlStore {
+	return &sqlStore{sqls: make(map[int32]string)}
+}
+
+func (s *sqlStore) get(id int32) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	text, ok := s.sqls[id]
+	return text, ok
+}
+
+func (s *sqlStore) put(id int32, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sqls[id] = text
+}
+
+func (s *sqlStore) remove(id int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sqls, id)
+}
+
+func (s *Server) newStream(ctx context.Context, sqls *sqlStore) (*stream, error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextStream++
+	return &stream{id: s.nextStream, conn: conn, sqls: sqls}, nil
+}
+
+// close rolls back the transaction that is still open on the stream, so that the connection goes
+// back to the pool in autocommit mode.
+func (st *stream) close() {
+	_, _ = st.conn.ExecContext(context.Background(), "ROLLBACK")
+	st.conn.Close()
+}
+
+func (st *stream) sql(text *string, id *int32) (string, *hrana.Error) {
+	if text != nil {
+		return *text, nil
+	}
+	if id != nil {
+		if text, ok := st.sqls.get(*id); ok {
+			return text, nil
+		}
+		return "", newError("SQL_NOT_FOUND", fmt.Sprintf("SQL with id %d was not stored", *id))
+	}
+	return "", newError("", "the request has no SQL")
+}
+
+// handle executes a stream request and returns its response.
+func (st *stream) handle(ctx context.Context, req *hrana.StreamRequest) (map[string]any, *hrana.Error) {
+	switch req.Type {
+	case "close":
+		return map[string]any{"type": "close"}, nil
+	case "execute":
+		if req.Stmt == nil {
+			return nil, newError("", "execute request has no statement")
+		}
+		res, err := st.execute(ctx, req.Stmt, nil)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "execute", "result": res}, nil
+	case "batch":
+		if req.Batch == nil {
+			return nil, newError("", "batch request has no batch")
+		}
+		return map[string]any{"type": "batch", "result": st.batch(ctx, req.Batch, nil)}, nil
+	case "sequence":
+		text, err := st.sql(req.Sql, req.SqlId)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := st.conn.ExecContext(ctx, text); err != nil {
+			return nil, toError(err)
+		}
+		return map[string]any{"type": "sequence"}, nil
+	case "describe":
+		text, err := st.sql(req.Sql, req.SqlId)
+		if err != nil {
+			return nil, err
+		}
+		res, err := st.describe(ctx, text)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "describe", "result": res}, nil
+	case "store_sql":
+		if req.Sql == nil || req.SqlId == nil {
+			return nil, newError("", "store_sql request needs sql and sql_id")
+		}
+		st.sqls.put(*req.SqlId, *req.Sql)
+		return map[string]any{"type": "store_sql"}, nil
+	case "close_sql":
+		if req.SqlId != nil {
+			st.sqls.remove(*req.SqlId)
+		}
+		return map[string]any{"type": "close_sql"}, nil
+	case "get_autocommit":
+		return map[string]any{"type": "get_autocommit", "is_autocommit": st.isAutocommit(ctx)}, nil
+	}
+	return nil, newError("", fmt.Sprintf("unknown request type %q", req.Type))
+}
+
+// isAutocommit reports whether no transaction is open. database/sql has no way to ask, so it tries
+// to begin a transaction, which only succeeds in autocommit mode.
+func (st *stream) isAutocommit(ctx context.Context) bool {
+	if _, err := st.conn.ExecContext(ctx, "BEGIN DEFERRED"); err != nil {
+		return false
+	}
+	_, _ = st.conn.ExecContext(ctx, "ROLLBACK")
+	return true
+}
+
+// describe reports the parameters of text and, for queries, their columns.
+func (st *stream) describe(ctx context.Context, text string) (*hrana.DescribeResult, *hrana.Error) {
+	res := &hrana.DescribeResult{Params: []hrana.DescribeParam{}, Cols: []hrana.DescribeCol{}}
+	err := st.conn.Raw(func(driverConn any) error {
+		stmt, err := driverConn.(driver.Conn).Prepare(text)
+		if err != nil {
+			return err
+		}
+		return stmt.Close()
+	})
+	if err != nil {
+		return nil, toError(err)
+	}
+	res.Params = params(text)
+	keyword := strings.ToUpper(strings.Fields(text + " ")[0])
+	res.IsExplain = keyword == "EXPLAIN"
+	res.IsReadonly = keyword == "SELECT" || keyword == "VALUES" || res.IsExplain
+	if keyword == "SELECT" || keyword == "VALUES" {
+		args := make([]any, len(res.Params))
+		for idx, param := range res.Params {
+			if param.Name != nil {
+				args[idx] = sql.Named(strings.TrimLeft(*param.Name, ":@$"), nil)
+			}
+		}
+		rows, err := st.conn.QueryContext(ctx, "SELECT * FROM ("+text+") LIMIT 0", args...)
+		if err != nil {
+			return nil, toError(err)
+		}
+		defer rows.Close()
+		types, err := rows.ColumnTypes()
+		if err != nil {
+			return nil, toError(err)
+		}
+		for _, t := range types {
+			col := hrana.DescribeCol{Name: t.Name()}
+			if declType := t.DatabaseTypeName(); declType != "" {
+				col.Decltype = &declType
+			}
+			res.Cols = append(res.Cols, col)
+		}
+	}
+	return res, nil
+}
+
+// params returns the parameters of text. database/sql drivers don't expose them, so they are found
+// in text the way SQLite numbers them: ? takes the index after the largest one so far, ?NNN takes
+// index NNN, and a named parameter takes a new index the first time it appears.
+func params(text string) []hrana.DescribeParam {
+	var names []string
+	indexes := make(map[string]int)
+	for i := 0; i < len(text); {
+		switch c := text[i]; {
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			next := strings.IndexByte(text[i+1:], end)
+			if next < 0 {
+				return describeParams(names)
+			}
+			i += next + 2
+		case strings.HasPrefix(text[i:], "--"):
+			next := strings.IndexByte(text[i:], '\n')
+			if next < 0 {
+				return describeParams(names)
+			}
+			i += next + 1
+		case strings.HasPrefix(text[i:], "/*"):
+			next := strings.Index(text[i+2:], "*/")
+			if next < 0 {
+				return describeParams(names)
+			}
+			i += next + 4
+		case c == '?':
+			start := i
+			for i++; i < len(text) && text[i] >= '0' && text[i] <= '9'; i++ {
+			}
+			index := len(names) + 1
+			if i > start+1 {
+				index, _ = strconv.Atoi(text[start+1 : i])
+			}
+			for len(names) < index {
+				names = append(names, "")
+			}
+		case c == ':' || c == '@' || c == '$':
+			start := i
+			for i++; i < len(text) && isIdentifierChar(text[i]); i++ {
+			}
+			if i == start+1 {
+				continue
+			}
+			name := text[start:i]
+			if _, ok := indexes[name]; !ok {
+				names = append(names, name)
+				indexes[name] = len(names)
+			}
+		case isIdentifierChar(c):
+			// Skips identifiers as a whole, so that digits in them aren't taken for parameters.
+			for i++; i < len(text) && isIdentifierChar(text[i]); i++ {
+			}
+		default:
+			i++
+		}
+	}
+	return describeParams(names)
+}
+
+func describeParams(names []string) []hrana.DescribeParam {
+	params := make([]hrana.DescribeParam, len(names))
+	for idx := range names {
+		if names[idx] != "" {
+			params[idx].Name = &names[idx]
+		}
+	}
+	return params
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// entrySink receives the entries of a cursor.
+type entrySink func(entry hrana.CursorEntry)
+
+// execute executes stmt. If sink is set, the rows are passed to it instead of being collected in the
+// result.
+func (st *stream) execute(ctx context.Context, stmt *hrana.Stmt, sink entrySink) (*hrana.StmtResult, *hrana.Error) {
+	text, herr := st.sql(stmt.Sql, stmt.SqlId)
+	if herr != nil {
+		return nil, herr
+	}
+	// SQLite doesn't know the read-only transactions of libSQL, so they start as deferred
+	// transactions. Writes in them are not rejected.
+	if strings.EqualFold(strings.Join(strings.Fields(text), " "), "BEGIN TRANSACTION READONLY") {
+		text = "BEGIN"
+	}
+	args := make([]any, 0, len(stmt.Args)+len(stmt.NamedArgs))
+	for _, arg := range stmt.Args {
+		value, err := decodeValue(arg)
+		if err != nil {
+			return nil, newError("", err.Error())
+		}
+		args = append(args, value)
+	}
+	for _, arg := range stmt.NamedArgs {
+		value, err := decodeValue(arg.Value)
+		if err != nil {
+			return nil, newError("", err.Error())
+		}
+		args = append(args, sql.Named(strings.TrimLeft(arg.Name, ":@$"), value))
+	}
+
+	var changesBefore int64
+	if err := st.conn.QueryRowContext(ctx, "SELECT total_changes()").Scan(&changesBefore); err != nil {
+		return nil, toError(err)
+	}
+	query, types := text, []*sql.ColumnType(nil)
+	if raw, rawTypes, ok := st.rawQuery(ctx, text, args); ok {
+		query, types = raw, rawTypes
+	}
+	rows, err := st.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, toError(err)
+	}
+	res, herr := readRows(rows, types, stmt.WantRows, sink)
+	if herr != nil {
+		return nil, herr
+	}
+
+	var changesAfter, changes, lastInsertRowId int64
+	err = st.conn.QueryRowContext(ctx, "SELECT total_changes(), changes(), last_insert_rowid()").Scan(&changesAfter, &changes, &lastInsertRowId)
+	if err != nil {
+		return nil, toError(err)
+	}
+	// changes() still counts the last write when the statement didn't write at all.
+	if changesAfter != changesBefore {
+		res.AffectedRowCount = int32(changes)
+	}
+	rowId := strconv.FormatInt(lastInsertRowId, 10)
+	res.LastInsertRowId = &rowId
+	return res, nil
+}
+
+// rawQuery returns a query that selects the same rows as text, but without the declared types of
+// its columns. Drivers convert values according to the declared type of their column, for example
+// text in DATE columns to time.Time, and without it they return the values as SQLite stored them.
+// The columns of text, with their declared types, are returned as well. ok is false if text is not
+// a query that can be rewritten, such as a statement with a RETURNING clause.
+func (st *stream) rawQuery(ctx context.Context, text string, args []any) (query string, types []*sql.ColumnType, ok bool) {
+	keyword := strings.ToUpper(strings.Fields(text + " ")[0])
+	if keyword != "SELECT" && keyword != "VALUES" && keyword != "WITH" {
+		return "", nil, false
+	}
+	body := strings.TrimRight(text, "; \t\r\n")
+	rows, err := st.conn.QueryContext(ctx, "SELECT * FROM (\n"+body+"\n) LIMIT 0", args...)
+	if err != nil {
+		return "", nil, false
+	}
+	defer rows.Close()
+	types, err = rows.ColumnTypes()
+	if err != nil {
+		return "", nil, false
+	}
+	declared := false
+	names := make([]string, len(types))
+	selected := make([]string, len(types))
+	for idx, t := range types {
+		declared = declared || t.DatabaseTypeName() != ""
+		names[idx] = "c" + strconv.Itoa(idx)
+		// The unary plus returns its operand unchanged, but without the declared type.
+		selected[idx] = "+c" + strconv.Itoa(idx)
+	}
+	if !declared {
+		return "", nil, false
+	}
+	query = "WITH libsqltest_raw(" + strings.Join(names, ", ") + ") AS (\n" + body + "\n) SELECT " + strings.Join(selected, ", ") + " FROM libsqltest_raw"
+	return query, types, true
+}
+
+// readRows reads the result of a statement. types are the columns that are reported; if they are
+// nil, the columns of rows are.
+func readRows(rows *sql.Rows, types []*sql.ColumnType, wantRows bool, sink entrySink) (*hrana.StmtResult, *hrana.Error) {
+	defer rows.Close()
+	res := &hrana.StmtResult{Cols: []hrana.Column{}, Rows: [][]hrana.Value{}}
+	if types == nil {
+		var err error
+		if types, err = rows.ColumnTypes(); err != nil {
+			return nil, toError(err)
+		}
+	}
+	for _, t := range types {
+		name := t.Name()
+		col := hrana.Column{Name: &name}
+		if declType := t.DatabaseTypeName(); declType != "" {
+			col.Type = &declType
+		}
+		res.Cols = append(res.Cols, col)
+	}
+	if sink != nil {
+		sink(hrana.CursorEntry{Type: "step_begin", Cols: res.Cols})
+	}
+	values := make([]any, len(types))
+	dest := make([]any, len(types))
+	for idx := range values {
+		dest[idx] = &values[idx]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, toError(err)
+		}
+		row := make([]hrana.Value, len(values))
+		for idx, value := range values {
+			row[idx] = encodeValue(value)
+		}
+		if sink != nil {
+			sink(hrana.CursorEntry{Type: "row", Row: row})
+		} else if wantRows {
+			res.Rows = append(res.Rows, row)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, toError(err)
+	}
+	return res, nil
+}
+
+// batch executes the steps of b whose conditions hold.
+func (st *stream) batch(ctx context.Context, b *hrana.Batch, sink entrySink) *hrana.BatchResult {
+	res := &hrana.BatchResult{StepResults: make([]*hrana.StmtResult, len(b.Steps)), StepErrors: make([]*hrana.Error, len(b.Steps))}
+	for idx := range b.Steps {
+		if !st.holds(ctx, b.Steps[idx].Condition, res) {
+			continue
+		}
+		var stepSink entrySink
+		if sink != nil {
+			step := int32(idx)
+			stepSink = func(entry hrana.CursorEntry) {
+				entry.Step = step
+				sink(entry)
+			}
+		}
+		stepRes, err := st.execute(ctx, &b.Steps[idx].Stmt, stepSink)
+		if err != nil {
+			res.StepErrors[idx] = err
+			if sink != nil {
+				sink(hrana.CursorEntry{Type: "step_error", Step: int32(idx), Error: err})
+			}
+			continue
+		}
+		res.StepResults[idx] = stepRes
+		if sink != nil {
+			sink(hrana.CursorEntry{Type: "step_end", AffectedRowCount: stepRes.AffectedRowCount, LastInsertRowId: stepRes.LastInsertRowId})
+		}
+	}
+	return res
+}
+
+func (st *stream) holds(ctx context.Context, cond *hrana.BatchCondition, res *hrana.BatchResult) bool {
+	if cond == nil {
+		return true
+	}
+	switch cond.Type {
+	case "ok":
+		return cond.Step != nil && int(*cond.Step) < len(res.StepResults) && res.StepResults[*cond.Step] != nil
+	case "error":
+		return cond.Step != nil && int(*cond.Step) < len(res.StepErrors) && res.StepErrors[*cond.Step] != nil
+	case "not":
+		return cond.Cond != nil && !st.holds(ctx, cond.Cond, res)
+	case "and":
+		for idx := range cond.Conds {
+			if !st.holds(ctx, &cond.Conds[idx], res) {
+				return false
+			}
+		}
+		return true
+	case "or":
+		for idx := range cond.Conds {
+			if st.holds(ctx, &cond.Conds[idx], res) {
+				return true
+			}
+		}
+		return false
+	case "is_autocommit":
+		return st.isAutocommit(ctx)
+	}
+	return false
+}
+
+// decodeValue converts a value of a request. Values decoded from protobuf hold integers as int64 and
+// blobs as []byte rather than as strings.
+func decodeValue(v hrana.Value) (any, error) {
+	switch v.Type {
+	case "null":
+		return nil, nil
+	case "integer":
+		switch i := v.Value.(type) {
+		case string:
+			return strconv.ParseInt(i, 10, 64)
+		case float64:
+			return int64(i), nil
+		case int64:
+			return i, nil
+		}
+	case "float":
+		if f, ok := v.Value.(float64); ok {
+			return f, nil
+		}
+	case "text":
+		if s, ok := v.Value.(string); ok {
+			return s, nil
+		}
+	case "blob":
+		if blob, ok := v.Value.([]byte); ok {
+			return blob, nil
+		}
+		if v.Base64 == nil {
+			return []byte{}, nil
+		}
+		blob, err := base64.StdEncoding.WithPadding(base64.NoPadding).DecodeString(strings.TrimRight(*v.Base64, "="))
+		if blob == nil {
+			blob = []byte{}
+		}
+		return blob, err
+	}
+	return nil, fmt.Errorf("invalid value of type %q", v.Type)
+}
+
+// encodeValue converts a value scanned from the database. Queries are rewritten by rawQuery, so that
+// the values are what SQLite stored. Results of other statements can still hold values that the
+// driver converted according to the declared type of their column; they are sent the way SQLite
+// stores them when they are written.
+func encodeValue(v any) hrana.Value {
+	switch v := v.(type) {
+	case nil:
+		return hrana.Value{Type: "null"}
+	case int64:
+		return hrana.Value{Type: "integer", Value: strconv.FormatInt(v, 10)}
+	case float64:
+		return hrana.Value{Type: "float", Value: v}
+	case string:
+		return hrana.Value{Type: "text", Value: v}
+	case []byte:
+		b64 := base64.StdEncoding.WithPadding(base64.NoPadding).EncodeToString(v)
+		return hrana.Value{Type: "blob", Base64: &b64}
+	case bool:
+		if v {
+			return hrana.Value{Type: "integer", Value: "1"}
+		}
+		return hrana.Value{Type: "integer", Value: "0"}
+	case time.Time:
+		return hrana.Value{Type: "text", Value: v.Format("2006-01-02 15:04:05.999999999-07:00")}
+	}
+	return hrana.Value{Type: "text", Value: fmt.Sprint(v)}
+}
+
+func newError(code string, message string) *hrana.Error {
+	err := &hrana.Error{Message: message}
+	if code != "" {
+		err.Code = &code
+	}
+	return err
+}
+
+// toError converts an error of the database. The Hrana error code is derived from the message,
+// because database/sql drivers report SQLite result codes in their own types.
+func toError(err error) *hrana.Error {
+	message := err.Error()
+	code := "SQLITE_ERROR"
+	for _, c := range errorCodes {
+		if strings.Contains(message, c.pattern) {
+			code = c.code
+			break
+		}
+	}
+	return newError(code, message)
+}
+
+var errorCodes = []struct {
+	pattern string
+	code    string
+}{
+	{"UNIQUE constraint failed", "SQLITE_CONSTRAINT_UNIQUE"},
+	{"NOT NULL constraint failed", "SQLITE_CONSTRAINT_NOTNULL"},
+	{"FOREIGN KEY constraint failed", "SQLITE_CONSTRAINT_FOREIGNKEY"},
+	{"CHECK constraint failed", "SQLITE_CONSTRAINT_CHECK"},
+	{"constraint failed", "SQLITE_CONSTRAINT"},
+	{"database is locked", "SQLITE_BUSY"},
+	{"database table is locked", "SQLITE_LOCKED"},
+	{"attempt to write a readonly database", "SQLITE_READONLY"},
+}
@@ -0,0 +1,192 @@
+package libsqltest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/hrana"
+)
+
+type clientMsg struct {
+	Type      string         `json:"type"`
+	RequestId *uint32        `json:"request_id,omitempty"`
+	Request   *socketRequest `json:"request,omitempty"`
+}
+
+// socketRequest is a request sent over a WebSocket. It names the stream it belongs to.
+type socketRequest struct {
+	hrana.StreamRequest
+	StreamId int32 `json:"stream_id"`
+}
+
+type serverMsg struct {
+	Type      string       `json:"type"`
+	RequestId *uint32      `json:"request_id,omitempty"`
+	Response  any          `json:"response,omitempty"`
+	Error     *hrana.Error `json:"error,omitempty"`
+}
+
+// socket is a WebSocket connection of a client. Every stream of the socket handles its requests in
+// order on its own goroutine, so that a stream that waits for a lock doesn't block the others.
+type socket struct {
+	server *Server
+	conn   *websocket.Conn
+	// writeMu serializes the messages written to conn.
+	writeMu sync.Mutex
+	sqls    *sqlStore
+
+	mu      sync.Mutex
+	streams map[int32]*socketStream
+}
+
+// socketStream is a stream opened on a socket.
+type socketStream struct {
+	requests chan socketJob
+	// done is closed when the goroutine of the stream has exited.
+	done chan struct{}
+}
+
+type socketJob struct {
+	requestId uint32
+	req       *socketRequest
+}
+
+func (s *Server) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{Subprotocols: []string{"hrana3", "hrana2"}})
+	if err != nil {
+		return
+	}
+	conn.SetReadLimit(1024 * 1024 * 16)
+	sock := &socket{server: s, conn: conn, sqls: newSqlStore(), streams: make(map[int32]*socketStream)}
+	s.mu.Lock()
+	s.sockets[sock] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.sockets, sock)
+		s.mu.Unlock()
+		sock.expireStreams()
+		conn.Close(websocket.StatusNormalClosure, "")
+	}()
+	sock.serve(r.Context())
+}
+
+func (sock *socket) write(ctx context.Context, msg serverMsg) {
+	sock.writeMu.Lock()
+	defer sock.writeMu.Unlock()
+	_ = wsjson.Write(ctx, sock.conn, msg)
+}
+
+func (sock *socket) serve(ctx context.Context) {
+	var hello clientMsg
+	if err := wsjson.Read(ctx, sock.conn, &hello); err != nil {
+		return
+	}
+	if hello.Type != "hello" {
+		sock.conn.Close(websocket.StatusProtocolError, fmt.Sprintf("expected hello, got %s", hello.Type))
+		return
+	}
+	sock.write(ctx, serverMsg{Type: "hello_ok"})
+	for {
+		var msg clientMsg
+		if err := wsjson.Read(ctx, sock.conn, &msg); err != nil {
+			return
+		}
+		if msg.Type != "request" || msg.RequestId == nil || msg.Request == nil {
+			sock.conn.Close(websocket.StatusProtocolError, fmt.Sprintf("unexpected %s message", msg.Type))
+			return
+		}
+		sock.dispatch(ctx, *msg.RequestId, msg.Request)
+	}
+}
+
+func (sock *socket) respond(ctx context.Context, requestId uint32, resp any, err *hrana.Error) {
+	if err != nil {
+		sock.write(ctx, serverMsg{Type: "response_error", RequestId: &requestId, Error: err})
+		return
+	}
+	sock.write(ctx, serverMsg{Type: "response_ok", RequestId: &requestId, Response: resp})
+}
+
+// dispatch opens and closes streams itself and hands all other requests to their stream.
+func (sock *socket) dispatch(ctx context.Context, requestId uint32, req *socketRequest) {
+	switch req.Type {
+	case "open_stream":
+		st, err := sock.server.newStream(ctx, sock.sqls)
+		if err != nil {
+			sock.respond(ctx, requestId, nil, toError(err))
+			return
+		}
+		ss := &socketStream{requests: make(chan socketJob, 16), done: make(chan struct{})}
+		sock.mu.Lock()
+		if _, ok := sock.streams[req.StreamId]; ok {
+			sock.mu.Unlock()
+			st.close()
+			sock.respond(ctx, requestId, nil, newError("", fmt.Sprintf("stream %d is already open", req.StreamId)))
+			return
+		}
+		sock.streams[req.StreamId] = ss
+		sock.mu.Unlock()
+		go sock.run(ctx, st, ss)
+		sock.respond(ctx, requestId, map[string]any{"type": "open_stream"}, nil)
+	case "close_stream":
+		sock.mu.Lock()
+		ss := sock.streams[req.StreamId]
+		delete(sock.streams, req.StreamId)
+		sock.mu.Unlock()
+		if ss != nil {
+			close(ss.requests)
+			<-ss.done
+		}
+		sock.respond(ctx, requestId, map[string]any{"type": "close_stream"}, nil)
+	default:
+		// The request is queued under the lock, so that the stream can't be expired in between.
+		sock.mu.Lock()
+		ss := sock.streams[req.StreamId]
+		if ss != nil {
+			ss.requests <- socketJob{requestId: requestId, req: req}
+		}
+		sock.mu.Unlock()
+		if ss == nil {
+			sock.respond(ctx, requestId, nil, newError("STREAM_EXPIRED", fmt.Sprintf("stream %d is not open", req.StreamId)))
+		}
+	}
+}
+
+// run handles the requests of a stream until the stream is closed.
+func (sock *socket) run(ctx context.Context, st *stream, ss *socketStream) {
+	defer close(ss.done)
+	defer st.close()
+	expired := false
+	for job := range ss.requests {
+		if expired {
+			sock.respond(ctx, job.requestId, nil, newError("STREAM_EXPIRED", "the stream has expired"))
+			continue
+		}
+		if injected := sock.server.intercept(st, &job.req.StreamRequest, true, false); injected != nil {
+			sock.respond(ctx, job.requestId, nil, injected.hranaError())
+			expired = injected.Code == "STREAM_EXPIRED"
+			continue
+		}
+		resp, err := st.handle(ctx, &job.req.StreamRequest)
+		sock.respond(ctx, job.requestId, resp, err)
+	}
+}
+
+// expireStreams closes all streams of the socket. Requests that were already queued are still
+// handled, later requests on the streams fail with STREAM_EXPIRED.
+func (sock *socket) expireStreams() {
+	sock.mu.Lock()
+	streams := sock.streams
+	sock.streams = make(map[int32]*socketStream)
+	sock.mu.Unlock()
+	for _, ss := range streams {
+		close(ss.requests)
+		<-ss.done
+	}
+}
@@ -0,0 +1,578 @@
+package libsql
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/hrana"
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
+	"github.com/tursodatabase/libsql-client-go/sqliteparserutils"
+)
+
+const defaultMigrationsTable = "libsql_migrations"
+
+// ErrMigrationsLocked is returned by MigrateUp and MigrateDown if another process is migrating the
+// database. A lock that was left behind by a crashed process can be removed with UnlockMigrations.
+var ErrMigrationsLocked = errors.New("migrations are locked by another process")
+
+// Migration is a versioned change of the schema.
+type Migration struct {
+	// Version orders the migrations. Every migration needs a distinct, positive version.
+	Version int64
+	Name    string
+	// Up is the SQL script that applies the migration.
+	Up string
+	// Down is the SQL script that reverts the migration. It is empty if the migration can't be
+	// reverted.
+	Down string
+}
+
+// Checksum returns the SHA-256 hash of the Up and Down scripts, which is recorded when the migration
+// is applied to detect later changes of either script. A Down script that is added to an applied
+// migration counts as a change, too.
+func (m Migration) Checksum() string {
+	h := sha256.New()
+	// The length of Up separates the scripts, so that no two pairs of scripts are hashed alike.
+	fmt.Fprintf(h, "%d\n%s%s", len(m.Up), m.Up, m.Down)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// AppliedMigration is a migration recorded in the tracking table.
+type AppliedMigration struct {
+	Version  int64
+	Name     string
+	Checksum string
+	// AppliedAt is the UTC time the migration was applied at, in the format YYYY-MM-DD HH:MM:SS.
+	AppliedAt string
+	// Dirty reports that applying or reverting the migration failed after some of its statements
+	// were executed. This can only happen on schema databases; see MigrateUp.
+	Dirty bool
+}
+
+// MigrateOptions configures MigrateUp, MigrateDown, AppliedMigrations, MarkMigration and
+// UnlockMigrations.
+type MigrateOptions struct {
+	// Table is the name of the table that tracks the applied migrations. It defaults to
+	// libsql_migrations. The lock is held in a table of the same name with the suffix _lock.
+	Table string
+	// Progress is called after every migration that was applied or reverted.
+	Progress func(Migration)
+}
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(.+?)(\.up|\.down)?\.sql$`)
+
+// LoadMigrations reads the migrations in directory dir of fsys, which can be an embed.FS. A migration
+// is a file named VERSION_NAME.up.sql, or VERSION_NAME.sql, that holds the Up script, and an optional
+// file VERSION_NAME.down.sql that holds the Down script, for example 0001_create_users.up.sql.
+// Files that don't end in .sql are ignored. The migrations are returned in order of their versions.
+func LoadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations:\n%w", err)
+	}
+	byVersion := make(map[int64]*Migration)
+	hasUp := make(map[int64]bool)
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s, want VERSION_NAME.up.sql or VERSION_NAME.down.sql", entry.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid version of migration file %s", entry.Name())
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration:\n%w", err)
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == ".down" {
+			if m.Down != "" {
+				return nil, fmt.Errorf("migration %d has more than one down script", version)
+			}
+			m.Down = string(content)
+		} else {
+			if hasUp[version] {
+				return nil, fmt.Errorf("migration %d has more than one up script", version)
+			}
+			hasUp[version] = true
+			m.Up = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for version, m := range byVersion {
+		if !hasUp[version] {
+			return nil, fmt.Errorf("migration %d has a down script but no up script", version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// MigrateUp applies the migrations that weren't applied yet, in order of their versions. Every
+// migration is applied together with its record in the tracking table in a single transaction, which
+// remote databases execute in a single round trip, so a migration is either applied and recorded or
+// not applied at all.
+//
+// Before anything is applied, the applied migrations are checked against migrations: MigrateUp fails
+// if one of them is missing or its Up or Down script changed, or if a migration that wasn't applied
+// is older than the newest applied one. While migrating, the database is locked against other
+// processes that migrate it.
+//
+// On a connection to a schema database, created with WithSchemaDb, the migrations are applied to the
+// schema, and the server applies them to all databases that use it. Schema databases don't accept
+// BEGIN and COMMIT, so a migration is not atomic there: if one of its statements fails, the
+// statements before it stay applied. The migration is then recorded as dirty, and MigrateUp and
+// MigrateDown fail until the schema is repaired by hand and the migration is resolved with
+// MarkMigration. A migration whose first statement fails leaves the tracking table as it was.
+//
+// The tracking table and the lock table are part of the schema as well, so the server copies them to
+// all databases that use it, together with their rows: the applied migrations, the dirty markers and
+// the lock while it is held. Migrate only the schema database, never the databases that use it, and
+// remove a lock that was left behind from the schema database with UnlockMigrations.
+func MigrateUp(ctx context.Context, conn *sql.Conn, migrations []Migration, opts *MigrateOptions) error {
+	return migrate(ctx, conn, migrations, opts, func(m *migrator, applied []AppliedMigration) error {
+		var newest int64
+		isApplied := make(map[int64]bool, len(applied))
+		for _, a := range applied {
+			isApplied[a.Version] = true
+			if a.Version > newest {
+				newest = a.Version
+			}
+		}
+		var pending []Migration
+		for _, migration := range m.migrations {
+			if isApplied[migration.Version] {
+				continue
+			}
+			if migration.Version < newest {
+				return fmt.Errorf("migration %d (%s) is older than the applied migration %d", migration.Version, migration.Name, newest)
+			}
+			pending = append(pending, migration)
+		}
+		for _, migration := range pending {
+			if err := m.exec(ctx, migration, true); err != nil {
+				return fmt.Errorf("failed to apply migration %d (%s):\n%w", migration.Version, migration.Name, err)
+			}
+			if m.progress != nil {
+				m.progress(migration)
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateDown reverts the applied migrations newer than version, newest first, by executing their
+// Down scripts. Like MigrateUp, it checks the applied migrations against migrations first, locks the
+// database and reverts every migration in a single transaction, except on schema databases. It fails
+// before reverting anything if one of the migrations has no Down script.
+func MigrateDown(ctx context.Context, conn *sql.Conn, migrations []Migration, version int64, opts *MigrateOptions) error {
+	return migrate(ctx, conn, migrations, opts, func(m *migrator, applied []AppliedMigration) error {
+		var reverted []Migration
+		for idx := len(applied) - 1; idx >= 0 && applied[idx].Version > version; idx-- {
+			migration := m.byVersion[applied[idx].Version]
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d (%s) can't be reverted because it has no down script", migration.Version, migration.Name)
+			}
+			reverted = append(reverted, migration)
+		}
+		for _, migration := range reverted {
+			if err := m.exec(ctx, migration, false); err != nil {
+				return fmt.Errorf("failed to revert migration %d (%s):\n%w", migration.Version, migration.Name, err)
+			}
+			if m.progress != nil {
+				m.progress(migration)
+			}
+		}
+		return nil
+	})
+}
+
+// AppliedMigrations returns the migrations recorded in the tracking table in order of their versions.
+// opts can be nil.
+func AppliedMigrations(ctx context.Context, conn *sql.Conn, opts *MigrateOptions) ([]AppliedMigration, error) {
+	table := migrationsTable(opts)
+	var exists bool
+	err := conn.QueryRowContext(ctx, "SELECT count(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations:\n%w", err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	return appliedMigrations(ctx, conn, table)
+}
+
+// MarkMigration records migration in the tracking table as applied, or removes its record if applied
+// is false, without executing any of its scripts. It resolves a migration that was left dirty on a
+// schema database, after the schema has been repaired by hand. opts can be nil.
+func MarkMigration(ctx context.Context, conn *sql.Conn, migration Migration, applied bool, opts *MigrateOptions) error {
+	table := migrationsTable(opts)
+	if err := createMigrationTables(ctx, conn, table); err != nil {
+		return err
+	}
+	if err := lockMigrations(ctx, conn, table); err != nil {
+		return err
+	}
+	var err error
+	if applied {
+		_, err = conn.ExecContext(ctx, "INSERT OR REPLACE INTO "+quoteIdentifier(table)+" (version, name, checksum, applied_at, dirty) VALUES (?, ?, ?, datetime('now'), 0)", migration.Version, migration.Name, migration.Checksum())
+	} else {
+		_, err = conn.ExecContext(ctx, "DELETE FROM "+quoteIdentifier(table)+" WHERE version = ?", migration.Version)
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to mark migration %d (%s):\n%w", migration.Version, migration.Name, err)
+	}
+	return unlockMigrations(conn, table, err)
+}
+
+// UnlockMigrations removes the lock that MigrateUp and MigrateDown hold while they migrate the
+// database. Use it only if the process that held the lock is gone. opts can be nil.
+func UnlockMigrations(ctx context.Context, conn *sql.Conn, opts *MigrateOptions) error {
+	if err := createMigrationTables(ctx, conn, migrationsTable(opts)); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "DELETE FROM "+quoteIdentifier(migrationsTable(opts)+"_lock")); err != nil {
+		return fmt.Errorf("failed to unlock migrations:\n%w", err)
+	}
+	return nil
+}
+
+func migrationsTable(opts *MigrateOptions) string {
+	if opts != nil && opts.Table != "" {
+		return opts.Table
+	}
+	return defaultMigrationsTable
+}
+
+// migrator holds the state shared by MigrateUp and MigrateDown.
+type migrator struct {
+	conn       *sql.Conn
+	table      string
+	migrations []Migration
+	byVersion  map[int64]Migration
+	progress   func(Migration)
+	remote     bool
+	schemaDb   bool
+}
+
+// schemaDbConn is implemented by connections that can target a schema database.
+type schemaDbConn interface {
+	SchemaDb() bool
+}
+
+// migrate locks the database, checks the applied migrations against migrations and calls run while
+// the lock is held.
+func migrate(ctx context.Context, conn *sql.Conn, migrations []Migration, opts *MigrateOptions, run func(m *migrator, applied []AppliedMigration) error) error {
+	m := &migrator{conn: conn, table: migrationsTable(opts), byVersion: make(map[int64]Migration, len(migrations))}
+	if opts != nil {
+		m.progress = opts.Progress
+	}
+	m.migrations = make([]Migration, len(migrations))
+	copy(m.migrations, migrations)
+	sort.SliceStable(m.migrations, func(i, j int) bool { return m.migrations[i].Version < m.migrations[j].Version })
+	for _, migration := range m.migrations {
+		if migration.Version <= 0 {
+			return fmt.Errorf("migration %s has version %d, want a positive version", migration.Name, migration.Version)
+		}
+		if _, ok := m.byVersion[migration.Version]; ok {
+			return fmt.Errorf("there is more than one migration with version %d", migration.Version)
+		}
+		m.byVersion[migration.Version] = migration
+	}
+	err := conn.Raw(func(driverConn any) error {
+		_, m.remote = driverConn.(batchExecutor)
+		if schemaConn, ok := driverConn.(schemaDbConn); ok {
+			m.schemaDb = schemaConn.SchemaDb()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := createMigrationTables(ctx, conn, m.table); err != nil {
+		return err
+	}
+	if err := lockMigrations(ctx, conn, m.table); err != nil {
+		return err
+	}
+	return unlockMigrations(conn, m.table, m.verifyAndRun(ctx, run))
+}
+
+// lockMigrations takes the lock of the migrations tracked in table, or returns ErrMigrationsLocked if
+// it is held already.
+func lockMigrations(ctx context.Context, conn *sql.Conn, table string) error {
+	res, err := conn.ExecContext(ctx, "INSERT OR IGNORE INTO "+quoteIdentifier(table+"_lock")+" (id, locked_at) VALUES (1, datetime('now'))")
+	if err != nil {
+		return fmt.Errorf("failed to lock migrations:\n%w", err)
+	}
+	if locked, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to lock migrations:\n%w", err)
+	} else if locked == 0 {
+		return ErrMigrationsLocked
+	}
+	return nil
+}
+
+// unlockMigrations releases the lock taken by lockMigrations and returns err, joined with the error of
+// releasing the lock if that fails.
+func unlockMigrations(conn *sql.Conn, table string, err error) error {
+	// The lock must be released even if ctx is the reason of the failure.
+	if _, unlockErr := conn.ExecContext(context.Background(), "DELETE FROM "+quoteIdentifier(table+"_lock")); unlockErr != nil {
+		return errors.Join(err, fmt.Errorf("failed to unlock migrations:\n%w", unlockErr))
+	}
+	return err
+}
+
+func (m *migrator) verifyAndRun(ctx context.Context, run func(m *migrator, applied []AppliedMigration) error) error {
+	applied, err := appliedMigrations(ctx, m.conn, m.table)
+	if err != nil {
+		return err
+	}
+	for _, a := range applied {
+		if a.Dirty {
+			return fmt.Errorf("migration %d (%s) failed after some of its statements were executed: repair the schema and resolve the migration with MarkMigration", a.Version, a.Name)
+		}
+		migration, ok := m.byVersion[a.Version]
+		if !ok {
+			return fmt.Errorf("applied migration %d (%s) is missing", a.Version, a.Name)
+		}
+		if migration.Checksum() != a.Checksum {
+			return fmt.Errorf("migration %d (%s) was changed after it was applied: its checksum is %s, but %s was applied", a.Version, a.Name, migration.Checksum(), a.Checksum)
+		}
+	}
+	return run(m, applied)
+}
+
+// trackingStmt is a statement that updates the tracking table.
+type trackingStmt struct {
+	sql  string
+	args []any
+}
+
+func (t trackingStmt) hranaStmt() (hrana.Stmt, error) {
+	stmt := hrana.Stmt{Sql: &t.sql}
+	return stmt, stmt.AddPositionalArgs(t.args)
+}
+
+// track returns the statement that records that migration was applied, or reverted if up is false.
+// If dirty is true, the applied migration is recorded as dirty instead.
+func (m *migrator) track(migration Migration, up bool, dirty bool) trackingStmt {
+	table := quoteIdentifier(m.table)
+	switch {
+	case up && dirty:
+		return trackingStmt{"INSERT INTO " + table + " (version, name, checksum, applied_at, dirty) VALUES (?, ?, ?, datetime('now'), 1)", []any{migration.Version, migration.Name, migration.Checksum()}}
+	case up:
+		return trackingStmt{"INSERT INTO " + table + " (version, name, checksum, applied_at) VALUES (?, ?, ?, datetime('now'))", []any{migration.Version, migration.Name, migration.Checksum()}}
+	case dirty:
+		return trackingStmt{"UPDATE " + table + " SET dirty = 1 WHERE version = ?", []any{migration.Version}}
+	default:
+		return trackingStmt{"DELETE FROM " + table + " WHERE version = ?", []any{migration.Version}}
+	}
+}
+
+// exec applies migration, or reverts it if up is false, and updates the tracking table in a single
+// transaction. Schema databases execute it without a transaction; see execSchemaDb.
+func (m *migrator) exec(ctx context.Context, migration Migration, up bool) error {
+	script := migration.Up
+	if !up {
+		script = migration.Down
+	}
+	split, _ := sqliteparserutils.SplitStatement(script)
+	stmts := make([]string, 0, len(split))
+	for _, stmt := range split {
+		if !shared.IsTransactionStatement(stmt) {
+			stmts = append(stmts, stmt)
+		}
+	}
+	track := m.track(migration, up, false)
+	if !m.remote {
+		return m.execLocal(ctx, stmts, track)
+	}
+	if m.schemaDb {
+		return m.execSchemaDb(ctx, migration, up, stmts)
+	}
+
+	steps := make([]hrana.Stmt, len(stmts)+1)
+	for idx := range stmts {
+		steps[idx] = hrana.Stmt{Sql: &stmts[idx]}
+	}
+	var err error
+	if steps[len(stmts)], err = track.hranaStmt(); err != nil {
+		return err
+	}
+	res, err := m.executeBatch(ctx, transactionBatch(steps))
+	if err != nil {
+		return err
+	}
+	if err := res.StepError(0); err != nil {
+		return fmt.Errorf("failed to begin migration transaction:\n%w", err)
+	}
+	for idx := range stmts {
+		if err := res.StepError(1 + idx); err != nil {
+			return scriptStatementError(int64(idx), stmts[idx], err)
+		}
+	}
+	if err := res.StepError(1 + len(stmts)); err != nil {
+		return fmt.Errorf("failed to update %s:\n%w", m.table, err)
+	}
+	if err := res.StepError(2 + len(stmts)); err != nil {
+		return fmt.Errorf("failed to commit migration transaction:\n%w", err)
+	}
+	return nil
+}
+
+// execSchemaDb executes stmts on a schema database in a single batch. Every statement only runs if
+// the one before it succeeded, but a failing statement doesn't undo the ones before it. So the
+// migration is recorded as dirty before its first statement, and the record is completed after the
+// last one. If the first statement fails, nothing was changed and the record is undone.
+func (m *migrator) execSchemaDb(ctx context.Context, migration Migration, up bool, stmts []string) error {
+	mark, err := m.track(migration, up, true).hranaStmt()
+	if err != nil {
+		return err
+	}
+	// Completing the record of an applied migration clears the dirty flag, and undoing it removes the
+	// record; for a reverted migration it is the other way round.
+	table := quoteIdentifier(m.table)
+	clean := trackingStmt{"UPDATE " + table + " SET dirty = 0 WHERE version = ?", []any{migration.Version}}
+	remove := trackingStmt{"DELETE FROM " + table + " WHERE version = ?", []any{migration.Version}}
+	done, undo := clean, remove
+	if !up {
+		done, undo = remove, clean
+	}
+	doneStmt, err := done.hranaStmt()
+	if err != nil {
+		return err
+	}
+	undoStmt, err := undo.hranaStmt()
+	if err != nil {
+		return err
+	}
+
+	ok := func(step int) *hrana.BatchCondition {
+		idx := int32(step)
+		return &hrana.BatchCondition{Type: "ok", Step: &idx}
+	}
+	batch := &hrana.Batch{}
+	batch.Add(mark, nil)
+	for idx := range stmts {
+		batch.Add(hrana.Stmt{Sql: &stmts[idx]}, ok(idx))
+	}
+	batch.Add(doneStmt, ok(len(stmts)))
+	if len(stmts) > 0 {
+		batch.Add(undoStmt, &hrana.BatchCondition{Type: "and", Conds: []hrana.BatchCondition{
+			*ok(0),
+			{Type: "not", Cond: ok(1)},
+		}})
+	}
+
+	res, err := m.executeBatch(ctx, batch)
+	if err != nil {
+		return err
+	}
+	if err := res.StepError(0); err != nil {
+		return fmt.Errorf("failed to update %s:\n%w", m.table, err)
+	}
+	for idx := range stmts {
+		if err := res.StepError(1 + idx); err != nil {
+			err = scriptStatementError(int64(idx), stmts[idx], err)
+			if idx == 0 {
+				if undoErr := res.StepError(2 + len(stmts)); undoErr != nil {
+					return errors.Join(err, fmt.Errorf("failed to update %s:\n%w", m.table, undoErr))
+				}
+				return err
+			}
+			return fmt.Errorf("migration was executed partially and is recorded as dirty:\n%w", err)
+		}
+	}
+	if err := res.StepError(1 + len(stmts)); err != nil {
+		return fmt.Errorf("failed to update %s, the migration is recorded as dirty:\n%w", m.table, err)
+	}
+	return nil
+}
+
+func (m *migrator) executeBatch(ctx context.Context, batch *hrana.Batch) (*hrana.BatchResult, error) {
+	var res *hrana.BatchResult
+	err := m.conn.Raw(func(driverConn any) error {
+		var err error
+		res, err = driverConn.(batchExecutor).ExecuteBatch(ctx, batch)
+		return err
+	})
+	return res, err
+}
+
+func (m *migrator) execLocal(ctx context.Context, stmts []string, track trackingStmt) error {
+	tx, err := m.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration transaction:\n%w", err)
+	}
+	for idx, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			tx.Rollback()
+			return scriptStatementError(int64(idx), stmt, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, track.sql, track.args...); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to update %s:\n%w", m.table, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration transaction:\n%w", err)
+	}
+	return nil
+}
+
+func createMigrationTables(ctx context.Context, conn *sql.Conn, table string) error {
+	stmts := []string{
+		"CREATE TABLE IF NOT EXISTS " + quoteIdentifier(table) + " (version INTEGER PRIMARY KEY, name TEXT NOT NULL, checksum TEXT NOT NULL, applied_at TEXT NOT NULL, dirty INTEGER NOT NULL DEFAULT 0)",
+		"CREATE TABLE IF NOT EXISTS " + quoteIdentifier(table+"_lock") + " (id INTEGER PRIMARY KEY CHECK (id = 1), locked_at TEXT NOT NULL)",
+	}
+	for _, stmt := range stmts {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to create %s:\n%w", table, err)
+		}
+	}
+	return nil
+}
+
+func appliedMigrations(ctx context.Context, conn *sql.Conn, table string) ([]AppliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, checksum, applied_at, dirty FROM "+quoteIdentifier(table)+" ORDER BY version")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations:\n%w", err)
+	}
+	defer rows.Close()
+	var applied []AppliedMigration
+	for rows.Next() {
+		var a AppliedMigration
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum, &a.AppliedAt, &a.Dirty); err != nil {
+			return nil, fmt.Errorf("failed to read applied migrations:\n%w", err)
+		}
+		applied = append(applied, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations:\n%w", err)
+	}
+	return applied, nil
+}
@@ -0,0 +1,347 @@
+package libsql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/libsqltest"
+)
+
+var testMigrations = fstest.MapFS{
+	"migrations/0001_users.up.sql":   {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);\nCREATE INDEX users_name ON users (name);\n")},
+	"migrations/0001_users.down.sql": {Data: []byte("DROP TABLE users;")},
+	"migrations/0002_posts.sql": {Data: []byte(`BEGIN;
+CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users (id), deleted INTEGER DEFAULT 0);
+CREATE TRIGGER users_deleted AFTER DELETE ON users BEGIN
+  UPDATE posts SET deleted = 1 WHERE user_id = old.id;
+END;
+COMMIT;
+`)},
+	"migrations/0002_posts.down.sql": {Data: []byte("DROP TRIGGER users_deleted; DROP TABLE posts;")},
+	"migrations/README.md":           {Data: []byte("not a migration")},
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations(testMigrations, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].Version != 1 || migrations[0].Name != "users" || migrations[1].Version != 2 || migrations[1].Name != "posts" {
+		t.Fatalf("got %+v", migrations)
+	}
+	if migrations[1].Down != "DROP TRIGGER users_deleted; DROP TABLE posts;" {
+		t.Errorf("got down script %q", migrations[1].Down)
+	}
+
+	for name, fsys := range map[string]fstest.MapFS{
+		"bad name":     {"m/users.sql": {}},
+		"duplicate up": {"m/1_users.up.sql": {}, "m/1_users.sql": {}},
+		"only down":    {"m/1_users.down.sql": {}},
+		"other name":   {"m/1_users.up.sql": {}, "m/1_people.down.sql": {}},
+		"zero version": {"m/0_users.sql": {}},
+	} {
+		if _, err := LoadMigrations(fsys, "m"); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	migrations, err := LoadMigrations(testMigrations, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, url := range testURLs(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			conn := openConn(t, url)
+			opts := &MigrateOptions{Table: "migrations_" + name}
+			versions := func() []int64 {
+				applied, err := AppliedMigrations(ctx, conn, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				res := []int64{}
+				for _, a := range applied {
+					res = append(res, a.Version)
+				}
+				return res
+			}
+			tables := func() string {
+				var res string
+				if err := conn.QueryRowContext(ctx, "SELECT coalesce(group_concat(name, ','), '') FROM (SELECT name FROM sqlite_master WHERE name IN ('users', 'posts', 'users_deleted') ORDER BY name)").Scan(&res); err != nil {
+					t.Fatal(err)
+				}
+				return res
+			}
+
+			var progress []int64
+			opts.Progress = func(m Migration) { progress = append(progress, m.Version) }
+			if err := MigrateUp(ctx, conn, migrations, opts); err != nil {
+				t.Fatal(err)
+			}
+			if got := versions(); !reflect.DeepEqual(got, []int64{1, 2}) || !reflect.DeepEqual(progress, got) {
+				t.Errorf("got applied %v and progress %v, want [1 2]", got, progress)
+			}
+			if got := tables(); got != "posts,users,users_deleted" {
+				t.Errorf("got tables %s", got)
+			}
+			progress = nil
+			if err := MigrateUp(ctx, conn, migrations, opts); err != nil || progress != nil {
+				t.Errorf("got %v and progress %v, want nothing to be applied again", err, progress)
+			}
+
+			failing := append(migrations[:2:2], Migration{Version: 3, Name: "broken", Up: "CREATE TABLE tags (name TEXT); INSERT INTO missing VALUES (1);"})
+			err := MigrateUp(ctx, conn, failing, opts)
+			if err == nil || !strings.Contains(err.Error(), "migration 3 (broken)") || !strings.Contains(err.Error(), "statement 2") {
+				t.Errorf("got %v, want statement 2 of migration 3 to fail", err)
+			}
+			var tags int
+			if err := conn.QueryRowContext(ctx, "SELECT count(*) FROM sqlite_master WHERE name = 'tags'").Scan(&tags); err != nil || tags != 0 {
+				t.Errorf("got %d, %v, want the failed migration to be rolled back", tags, err)
+			}
+
+			changed := append([]Migration{}, migrations...)
+			changed[0].Up += "\n-- changed"
+			if err := MigrateUp(ctx, conn, changed, opts); err == nil || !strings.Contains(err.Error(), "was changed") {
+				t.Errorf("got %v, want a checksum mismatch", err)
+			}
+			changed = append([]Migration{}, migrations...)
+			changed[1].Down = "DROP TABLE users;"
+			if err := MigrateDown(ctx, conn, changed, 1, opts); err == nil || !strings.Contains(err.Error(), "migration 2 (posts) was changed") {
+				t.Errorf("got %v, want a checksum mismatch of the down script", err)
+			}
+			if err := MigrateUp(ctx, conn, migrations[1:], opts); err == nil || !strings.Contains(err.Error(), "is missing") {
+				t.Errorf("got %v, want migration 1 to be missing", err)
+			}
+
+			if _, err := conn.ExecContext(ctx, "INSERT INTO "+opts.Table+"_lock VALUES (1, 'now')"); err != nil {
+				t.Fatal(err)
+			}
+			if err := MigrateDown(ctx, conn, migrations, 0, opts); !errors.Is(err, ErrMigrationsLocked) {
+				t.Errorf("got %v, want %v", err, ErrMigrationsLocked)
+			}
+			if err := UnlockMigrations(ctx, conn, opts); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := MigrateDown(ctx, conn, migrations, 1, opts); err != nil {
+				t.Fatal(err)
+			}
+			if got := versions(); !reflect.DeepEqual(got, []int64{1}) || tables() != "users" {
+				t.Errorf("got applied %v and tables %s after reverting to 1", got, tables())
+			}
+			if err := MigrateDown(ctx, conn, migrations, 0, opts); err != nil {
+				t.Fatal(err)
+			}
+			if got := versions(); len(got) != 0 || tables() != "" {
+				t.Errorf("got applied %v and tables %s after reverting all", got, tables())
+			}
+		})
+	}
+}
+
+func TestMigrateSchemaDb(t *testing.T) {
+	srv := newTestServer(t)
+	var mu sync.Mutex
+	var batches [][]string
+	srv.SetHook(func(req libsqltest.Request) *libsqltest.Error {
+		if req.Type == "batch" {
+			mu.Lock()
+			batches = append(batches, req.Steps)
+			mu.Unlock()
+		}
+		return nil
+	})
+	migrations, err := LoadMigrations(testMigrations, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, schemaDb := range []bool{false, true} {
+		connector, err := NewConnector(srv.URL, WithSchemaDb(schemaDb))
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := sql.OpenDB(connector)
+		defer client.Close()
+		ctx := context.Background()
+		conn, err := client.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		mu.Lock()
+		batches = nil
+		mu.Unlock()
+		if err := MigrateUp(ctx, conn, migrations, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := MigrateDown(ctx, conn, migrations, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		if len(batches) != 4 {
+			t.Errorf("schemaDb=%v: got %d batches, want one per migration", schemaDb, len(batches))
+		}
+		for _, steps := range batches {
+			if hasBegin := steps[0] == "BEGIN"; hasBegin == schemaDb {
+				t.Errorf("schemaDb=%v: got batch %q", schemaDb, steps)
+			}
+		}
+		mu.Unlock()
+	}
+
+	connector, err := NewConnector(srv.URL, WithSchemaDb(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := sql.OpenDB(connector)
+	defer client.Close()
+	ctx := context.Background()
+	conn, err := client.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	applied := func() string {
+		applied, err := AppliedMigrations(ctx, conn, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res []string
+		for _, a := range applied {
+			res = append(res, fmt.Sprintf("%d:%v", a.Version, a.Dirty))
+		}
+		return strings.Join(res, ",")
+	}
+	if err := MigrateUp(ctx, conn, migrations, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a transaction, the first statement stays applied when the second one fails.
+	broken := Migration{Version: 3, Name: "broken", Up: "CREATE TABLE tags (name TEXT); INSERT INTO missing VALUES (1);"}
+	err = MigrateUp(ctx, conn, append(migrations[:2:2], broken), nil)
+	if err == nil || !strings.Contains(err.Error(), "statement 2") || !strings.Contains(err.Error(), "dirty") {
+		t.Errorf("got %v, want statement 2 to fail and migration 3 to be dirty", err)
+	}
+	if got := applied(); got != "1:false,2:false,3:true" {
+		t.Errorf("got applied %s, want migration 3 to be dirty", got)
+	}
+	if err := MigrateUp(ctx, conn, migrations, nil); err == nil || !strings.Contains(err.Error(), "MarkMigration") {
+		t.Errorf("got %v, want the dirty migration to stop MigrateUp", err)
+	}
+	if err := MigrateDown(ctx, conn, migrations, 0, nil); err == nil || !strings.Contains(err.Error(), "MarkMigration") {
+		t.Errorf("got %v, want the dirty migration to stop MigrateDown", err)
+	}
+	if _, err := conn.ExecContext(ctx, "DROP TABLE tags"); err != nil {
+		t.Fatal(err)
+	}
+	if err := MarkMigration(ctx, conn, broken, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := applied(); got != "1:false,2:false" {
+		t.Errorf("got applied %s after resolving migration 3", got)
+	}
+
+	// If the first statement fails, nothing was changed and nothing is recorded.
+	broken.Up = "INSERT INTO missing VALUES (1); CREATE TABLE tags (name TEXT);"
+	err = MigrateUp(ctx, conn, append(migrations[:2:2], broken), nil)
+	if err == nil || !strings.Contains(err.Error(), "statement 1") || strings.Contains(err.Error(), "dirty") {
+		t.Errorf("got %v, want statement 1 to fail", err)
+	}
+	if got := applied(); got != "1:false,2:false" {
+		t.Errorf("got applied %s after the first statement failed", got)
+	}
+
+	// A failing down script leaves the migration dirty, too. The script is part of the checksum, so
+	// the migration is applied with it first.
+	if err := MigrateDown(ctx, conn, migrations, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	failingDown := append([]Migration{}, migrations...)
+	failingDown[1].Down = "DROP TRIGGER users_deleted; DROP TABLE missing;"
+	if err := MigrateUp(ctx, conn, failingDown, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := MigrateDown(ctx, conn, failingDown, 1, nil); err == nil || !strings.Contains(err.Error(), "dirty") {
+		t.Errorf("got %v, want migration 2 to be dirty", err)
+	}
+	if got := applied(); got != "1:false,2:true" {
+		t.Errorf("got applied %s, want migration 2 to be dirty", got)
+	}
+	if _, err := conn.ExecContext(ctx, "DROP TABLE posts"); err != nil {
+		t.Fatal(err)
+	}
+	if err := MarkMigration(ctx, conn, failingDown[1], false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := MigrateDown(ctx, conn, migrations, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := applied(); got != "" {
+		t.Errorf("got applied %s after reverting all", got)
+	}
+}
+
+func TestMigrateSchemaDbTracking(t *testing.T) {
+	srv := newTestServer(t)
+	var mu sync.Mutex
+	var statements []string
+	srv.SetHook(func(req libsqltest.Request) *libsqltest.Error {
+		mu.Lock()
+		defer mu.Unlock()
+		statements = append(statements, req.SQL)
+		statements = append(statements, req.Steps...)
+		return nil
+	})
+	connector, err := NewConnector(srv.URL, WithSchemaDb(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := sql.OpenDB(connector)
+	defer client.Close()
+	ctx := context.Background()
+	conn, err := client.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	migrations, err := LoadMigrations(testMigrations, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MigrateUp(ctx, conn, migrations, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The tracking and lock tables are written through the schema connection, so they are part of
+	// the schema that is copied to the databases using it. The lock is released again.
+	mu.Lock()
+	sent := strings.Join(statements, "\n")
+	mu.Unlock()
+	for _, want := range []string{
+		`CREATE TABLE IF NOT EXISTS "libsql_migrations" (`,
+		`CREATE TABLE IF NOT EXISTS "libsql_migrations_lock" (`,
+		`INSERT OR IGNORE INTO "libsql_migrations_lock"`,
+		`INSERT INTO "libsql_migrations" (version, name, checksum, applied_at, dirty)`,
+		`DELETE FROM "libsql_migrations_lock"`,
+	} {
+		if !strings.Contains(sent, want) {
+			t.Errorf("got statements\n%s\nwant one starting with %s", sent, want)
+		}
+	}
+	var locks int
+	if err := conn.QueryRowContext(ctx, `SELECT count(*) FROM "libsql_migrations_lock"`).Scan(&locks); err != nil || locks != 0 {
+		t.Errorf("got %d locks, %v, want the lock to be released", locks, err)
+	}
+}
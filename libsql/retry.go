@@ -0,0 +1,17 @@
+package libsql
+
+import (
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http"
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
+)
+
+// RetryPolicy configures how HTTP requests that failed with a transient error are retried. Only
+// reads are retried, and only while the connection has not sent anything but reads on its current
+// stream, so a statement is never replayed inside an open transaction.
+type RetryPolicy = shared.RetryPolicy
+
+// IsTransient reports whether err is a transport error, a 502, 503 or 504 response or an expired
+// stream. It is the classifier used when RetryPolicy.Retryable is nil.
+func IsTransient(err error) bool {
+	return http.IsTransient(err)
+}
@@ -0,0 +1,74 @@
+package libsql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Execer is satisfied by *sql.Tx and *sql.Conn, which send all statements over a single
+// connection. *sql.DB is left out on purpose: it could execute every statement on a different
+// connection of its pool.
+type Execer interface {
+	*sql.Tx | *sql.Conn
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+type savepointDepthKey struct{}
+
+// Savepoint runs fn as a nested unit of work on tx, which is a *sql.Tx or a *sql.Conn.
+// Before fn is called a SAVEPOINT is created, which is released if fn succeeds and rolled back to
+// if fn returns an error or panics, leaving the enclosing transaction intact. Savepoint calls can
+// be nested inside fn. On a *sql.Conn without a transaction the outermost savepoint behaves like
+// BEGIN and its release like COMMIT. fn should pass on the context it receives, which names the
+// savepoints after their nesting depth.
+//
+// All statements are sent over the connection that tx is bound to, so over HTTP they stay on the
+// same server stream.
+func Savepoint[E Execer](ctx context.Context, tx E, fn func(ctx context.Context) error) error {
+	depth, _ := ctx.Value(savepointDepthKey{}).(int)
+	depth++
+	ctx = context.WithValue(ctx, savepointDepthKey{}, depth)
+	// SQLite resolves a savepoint name to the most recent savepoint with that name, so names don't
+	// have to be unique. Numbering them by depth only makes statement logs easier to follow.
+	name := fmt.Sprintf("libsql_savepoint_%d", depth)
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("failed to create savepoint:\n%w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = rollbackToSavepoint(tx, name)
+			panic(p)
+		}
+	}()
+
+	if err := fn(ctx); err != nil {
+		if rollbackErr := rollbackToSavepoint(tx, name); rollbackErr != nil {
+			return errors.Join(err, rollbackErr)
+		}
+		return err
+	}
+	// The savepoint is closed even if ctx was cancelled while fn ran, so that the enclosing
+	// transaction doesn't end up with a savepoint it doesn't know about.
+	if _, err := tx.ExecContext(context.Background(), "RELEASE "+name); err != nil {
+		return fmt.Errorf("failed to release savepoint:\n%w", err)
+	}
+	return nil
+}
+
+// rollbackToSavepoint undoes the changes made since the savepoint name was created and closes it. It
+// doesn't take a context, because it also has to run when fn failed because its context was
+// cancelled.
+func rollbackToSavepoint[E Execer](tx E, name string) error {
+	ctx := context.Background()
+	// ROLLBACK TO keeps the savepoint, so it has to be released afterwards as well.
+	if _, err := tx.ExecContext(ctx, "ROLLBACK TO "+name); err != nil {
+		return fmt.Errorf("failed to roll back to savepoint:\n%w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "RELEASE "+name); err != nil {
+		return fmt.Errorf("failed to release savepoint:\n%w", err)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package libsql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/libsqltest"
+)
+
+// streamStatements returns the SQL of the execute requests srv received since the last call, and
+// fails t unless they were all sent on one stream.
+func streamStatements(t *testing.T, srv *libsqltest.Server) []string {
+	t.Helper()
+	var statements []string
+	stream := -1
+	for _, req := range srv.Requests() {
+		if req.Type != "execute" {
+			continue
+		}
+		if stream >= 0 && req.Stream != stream {
+			t.Errorf("%q was sent on stream %d, want stream %d", req.SQL, req.Stream, stream)
+		}
+		stream = req.Stream
+		statements = append(statements, req.SQL)
+	}
+	return statements
+}
+
+func TestSavepoint(t *testing.T) {
+	srv := newTestServer(t)
+	conn := openConn(t, srv.URL)
+	ctx := context.Background()
+	if _, err := conn.ExecContext(ctx, "CREATE TABLE t (a)"); err != nil {
+		t.Fatal(err)
+	}
+	srv.Requests()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("BeginTx() error = %v", err)
+	}
+	errFailed := errors.New("failed")
+	err = Savepoint(ctx, tx, func(ctx context.Context) error {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO t VALUES (1)"); err != nil {
+			return err
+		}
+		err := Savepoint(ctx, tx, func(ctx context.Context) error {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO t VALUES (2)"); err != nil {
+				return err
+			}
+			return errFailed
+		})
+		if !errors.Is(err, errFailed) {
+			t.Errorf("nested Savepoint() error = %v, want %v", err, errFailed)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Savepoint() error = %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	want := []string{
+		"BEGIN",
+		"SAVEPOINT libsql_savepoint_1",
+		"INSERT INTO t VALUES (1)",
+		"SAVEPOINT libsql_savepoint_2",
+		"INSERT INTO t VALUES (2)",
+		"ROLLBACK TO libsql_savepoint_2",
+		"RELEASE libsql_savepoint_2",
+		"RELEASE libsql_savepoint_1",
+		"COMMIT",
+	}
+	if got := streamStatements(t, srv); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("statements =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	var values string
+	if err := conn.QueryRowContext(ctx, "SELECT group_concat(a) FROM t").Scan(&values); err != nil || values != "1" {
+		t.Errorf("got rows %q, %v, want only the row of the outer savepoint", values, err)
+	}
+}
+
+func TestSavepointConn(t *testing.T) {
+	srv := newTestServer(t)
+	conn := openConn(t, srv.URL)
+	ctx := context.Background()
+	if _, err := conn.ExecContext(ctx, "CREATE TABLE t (a)"); err != nil {
+		t.Fatal(err)
+	}
+	// The savepoint has to start a new stream and keep the statements after it on that stream.
+	if err := conn.Raw(func(driverConn any) error { return driverConn.(driver.SessionResetter).ResetSession(ctx) }); err != nil {
+		t.Fatal(err)
+	}
+	srv.Requests()
+
+	err := Savepoint(ctx, conn, func(ctx context.Context) error {
+		_, err := conn.ExecContext(ctx, "INSERT INTO t VALUES (1)")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Savepoint() error = %v", err)
+	}
+
+	want := []string{
+		"SAVEPOINT libsql_savepoint_1",
+		"INSERT INTO t VALUES (1)",
+		"RELEASE libsql_savepoint_1",
+	}
+	if got := streamStatements(t, srv); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("statements =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSavepointCancelled(t *testing.T) {
+	for name, url := range testURLs(t) {
+		t.Run(name, func(t *testing.T) {
+			conn := openConn(t, url)
+			table := "savepoint_" + name
+			if _, err := conn.ExecContext(context.Background(), "CREATE TABLE "+table+" (v)"); err != nil {
+				t.Fatal(err)
+			}
+			tx, err := conn.BeginTx(context.Background(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			err = Savepoint(ctx, tx, func(ctx context.Context) error {
+				if _, err := tx.ExecContext(ctx, "INSERT INTO "+table+" VALUES ('inner')"); err != nil {
+					return err
+				}
+				cancel()
+				return ctx.Err()
+			})
+			if !errors.Is(err, context.Canceled) || strings.Contains(err.Error(), "savepoint") {
+				t.Fatalf("got %v, want only the cancellation", err)
+			}
+
+			// The savepoint is rolled back and released, so committing keeps only the outer row.
+			if _, err := tx.ExecContext(context.Background(), "INSERT INTO "+table+" VALUES ('outer')"); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			var values string
+			if err := conn.QueryRowContext(context.Background(), "SELECT group_concat(v) FROM "+table).Scan(&values); err != nil {
+				t.Fatal(err)
+			}
+			if values != "outer" {
+				t.Errorf("got rows %q, want only the outer row", values)
+			}
+		})
+	}
+}
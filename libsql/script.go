@@ -0,0 +1,177 @@
+package libsql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/hrana"
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
+	"github.com/tursodatabase/libsql-client-go/sqliteparserutils"
+)
+
+const defaultScriptBatchSize = 4096
+
+// ScriptOptions configures ExecScript.
+type ScriptOptions struct {
+	// BatchSize is the number of statements sent to a remote database in a single request. It
+	// defaults to 4096.
+	BatchSize int
+	// Progress is called after every batch of statements.
+	Progress func(ScriptProgress)
+}
+
+// ScriptProgress tells how far ExecScript got.
+type ScriptProgress struct {
+	// Statements is the number of statements executed so far.
+	Statements int64
+	// Bytes is the number of bytes read from the script so far. The script is read ahead of the
+	// statements that were executed.
+	Bytes int64
+}
+
+// ExecScript executes the SQL statements read from r in a single transaction, which is rolled back
+// if any statement fails. The script is read and split into statements incrementally, so it can be
+// larger than the available memory. Statements that begin or end a transaction are skipped, which
+// makes dumps that wrap themselves in BEGIN and COMMIT work as well. opts can be nil.
+//
+// On remote databases the statements are sent in batches of opts.BatchSize, each batch in a single
+// round trip. Local databases execute them one by one.
+//
+// Schema databases, connected to with WithSchemaDb, don't accept transactions, so there the
+// statements run without one. If a statement fails, the statements before it stay applied.
+func ExecScript(ctx context.Context, conn *sql.Conn, r io.Reader, opts *ScriptOptions) error {
+	var options ScriptOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.BatchSize < 0 {
+		return fmt.Errorf("batch size must not be negative")
+	}
+	if options.BatchSize == 0 {
+		options.BatchSize = defaultScriptBatchSize
+	}
+	var remote, schemaDb bool
+	err := conn.Raw(func(driverConn any) error {
+		_, remote = driverConn.(batchExecutor)
+		if schemaConn, ok := driverConn.(schemaDbConn); ok {
+			schemaDb = schemaConn.SchemaDb()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if schemaDb {
+		return execScript(ctx, conn, r, options, remote)
+	}
+
+	if _, err := conn.ExecContext(ctx, "BEGIN"); err != nil {
+		return fmt.Errorf("failed to begin script transaction:\n%w", err)
+	}
+	if err := execScript(ctx, conn, r, options, remote); err != nil {
+		// The rollback must happen even if ctx is the reason of the failure.
+		if _, rollbackErr := conn.ExecContext(context.Background(), "ROLLBACK"); rollbackErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to roll back script transaction:\n%w", rollbackErr))
+		}
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		return fmt.Errorf("failed to commit script transaction:\n%w", err)
+	}
+	return nil
+}
+
+func execScript(ctx context.Context, conn *sql.Conn, r io.Reader, options ScriptOptions, remote bool) error {
+	counter := &countingReader{reader: r}
+	iterator := sqliteparserutils.CreateStatementIteratorFromReader(counter)
+	var progress ScriptProgress
+	batch := make([]string, 0, options.BatchSize)
+	for isEOF := false; !isEOF; {
+		batch = batch[:0]
+		for !isEOF && len(batch) < options.BatchSize {
+			var stmt string
+			stmt, _, isEOF = iterator.Next()
+			if stmt != "" && !shared.IsTransactionStatement(stmt) {
+				batch = append(batch, stmt)
+			}
+		}
+		if err := iterator.Err(); err != nil {
+			return fmt.Errorf("failed to read script: %w", err)
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		var err error
+		if remote {
+			err = execScriptBatch(ctx, conn, batch, progress.Statements)
+		} else {
+			err = execScriptStatements(ctx, conn, batch, progress.Statements)
+		}
+		if err != nil {
+			return err
+		}
+		progress.Statements += int64(len(batch))
+		progress.Bytes = counter.count
+		if options.Progress != nil {
+			options.Progress(progress)
+		}
+	}
+	return nil
+}
+
+// execScriptBatch executes stmts in a single batch. Every step only runs if the step before it
+// succeeded, so the batch stops at the first failing statement. offset is the number of statements
+// of the script executed before.
+func execScriptBatch(ctx context.Context, conn *sql.Conn, stmts []string, offset int64) error {
+	steps := make([]hrana.BatchStep, len(stmts))
+	for idx := range stmts {
+		steps[idx].Stmt = hrana.Stmt{Sql: &stmts[idx]}
+		if idx > 0 {
+			prev := int32(idx - 1)
+			steps[idx].Condition = &hrana.BatchCondition{Type: "ok", Step: &prev}
+		}
+	}
+	var res *hrana.BatchResult
+	err := conn.Raw(func(driverConn any) error {
+		var err error
+		res, err = driverConn.(batchExecutor).ExecuteBatch(ctx, &hrana.Batch{Steps: steps})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for idx := range stmts {
+		if err := res.StepError(idx); err != nil {
+			return scriptStatementError(offset+int64(idx), stmts[idx], err)
+		}
+	}
+	return nil
+}
+
+func execScriptStatements(ctx context.Context, conn *sql.Conn, stmts []string, offset int64) error {
+	for idx, stmt := range stmts {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return scriptStatementError(offset+int64(idx), stmt, err)
+		}
+	}
+	return nil
+}
+
+func scriptStatementError(index int64, stmt string, err error) error {
+	return fmt.Errorf("failed to execute statement %d of script: %s\n%w", index+1, stmt, err)
+}
+
+// countingReader counts the bytes read from reader.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
@@ -0,0 +1,89 @@
+package libsql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
+	"github.com/tursodatabase/libsql-client-go/libsql/libsqltest"
+)
+
+func TestExecScript(t *testing.T) {
+	for name, url := range testURLs(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			conn := openConn(t, url)
+
+			table := "script_" + name
+			var script strings.Builder
+			fmt.Fprintf(&script, "BEGIN;\nCREATE TABLE %s (a INTEGER);\n", table)
+			for i := 0; i < 9; i++ {
+				fmt.Fprintf(&script, "INSERT INTO %s VALUES (%d);\n", table, i)
+			}
+			script.WriteString("COMMIT;\n")
+			var progress []ScriptProgress
+			err := ExecScript(ctx, conn, strings.NewReader(script.String()), &ScriptOptions{
+				BatchSize: 4,
+				Progress:  func(p ScriptProgress) { progress = append(progress, p) },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(progress) != 3 || progress[2].Statements != 10 || progress[2].Bytes != int64(script.Len()) {
+				t.Errorf("got progress %+v, want 3 batches ending at 10 statements and %d bytes", progress, script.Len())
+			}
+			var count int
+			if err := conn.QueryRowContext(ctx, "SELECT count(*) FROM "+table).Scan(&count); err != nil || count != 9 {
+				t.Errorf("got %d rows, %v, want 9", count, err)
+			}
+
+			failing := fmt.Sprintf("INSERT INTO %s VALUES (100);\nINSERT INTO %s VALUES (101);\nINSERT INTO missing VALUES (1);\nINSERT INTO %s VALUES (102);", table, table, table)
+			err = ExecScript(ctx, conn, strings.NewReader(failing), &ScriptOptions{BatchSize: 2})
+			if err == nil || !strings.Contains(err.Error(), "statement 3 of script") {
+				t.Errorf("got %v, want statement 3 to fail", err)
+			}
+			if err := conn.QueryRowContext(ctx, "SELECT count(*) FROM "+table).Scan(&count); err != nil || count != 9 {
+				t.Errorf("got %d rows, %v, want the failed script to be rolled back", count, err)
+			}
+		})
+	}
+}
+
+func TestExecScriptSchemaDb(t *testing.T) {
+	srv := newTestServer(t)
+	// Like a schema database, the server rejects transactions.
+	srv.SetHook(func(req libsqltest.Request) *libsqltest.Error {
+		for _, stmt := range append(req.Steps, req.SQL) {
+			if shared.IsTransactionStatement(stmt) {
+				return &libsqltest.Error{Message: "transactions are not allowed on schema databases"}
+			}
+		}
+		return nil
+	})
+	connector, err := NewConnector(srv.URL, WithSchemaDb(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	script := "CREATE TABLE script (a INTEGER);\nINSERT INTO script VALUES (1);\nINSERT INTO missing VALUES (2);\nINSERT INTO script VALUES (3);"
+	err = ExecScript(ctx, conn, strings.NewReader(script), nil)
+	if err == nil || !strings.Contains(err.Error(), "statement 3 of script") {
+		t.Errorf("got %v, want statement 3 to fail", err)
+	}
+	// Without a transaction, the statements before the failing one stay applied.
+	var values string
+	if err := conn.QueryRowContext(ctx, "SELECT group_concat(a) FROM script").Scan(&values); err != nil || values != "1" {
+		t.Errorf("got rows %q, %v, want the statements before the failing one to be applied", values, err)
+	}
+}
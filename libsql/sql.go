@@ -2,22 +2,33 @@ package libsql
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
 	"database/sql/driver"
 	"errors"
 	"fmt"
+	net_http "net/http"
 	"net/url"
 	"strings"
 
 	"github.com/tursodatabase/libsql-client-go/libsql/internal/http"
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
 	"github.com/tursodatabase/libsql-client-go/libsql/internal/ws"
 )
 
 type config struct {
-	authToken *string
-	tls       *bool
-	proxy     *string
-	schemaDb  *bool
+	authToken         *string
+	authTokenProvider func(ctx context.Context, refresh bool) (string, error)
+	tls               *bool
+	proxy             *string
+	schemaDb          *bool
+	protobuf          *bool
+	httpClient        *net_http.Client
+	tlsConfig         *tls.Config
+	retryPolicy       *RetryPolicy
+	describe          *bool
+	converters        []ValueConverter
+	timeEncoding      *TimeEncoding
 }
 
 type Option interface {
@@ -43,6 +54,23 @@ func WithAuthToken(authToken string) Option {
 	})
 }
 
+// WithAuthTokenProvider sets a function that returns the auth token. It is called for every HTTP
+// request and WebSocket handshake, so it should cache the token while it is valid. When the server
+// rejects a token, the provider is called with refresh set to true and the request is retried once
+// if it returns a different token.
+func WithAuthTokenProvider(provider func(ctx context.Context, refresh bool) (string, error)) Option {
+	return option(func(o *config) error {
+		if o.authTokenProvider != nil {
+			return fmt.Errorf("authTokenProvider already set")
+		}
+		if provider == nil {
+			return fmt.Errorf("authTokenProvider must not be nil")
+		}
+		o.authTokenProvider = provider
+		return nil
+	})
+}
+
 func WithTls(tls bool) Option {
 	return option(func(o *config) error {
 		if o.tls != nil {
@@ -66,6 +94,9 @@ func WithProxy(proxy string) Option {
 	})
 }
 
+// WithSchemaDb makes the connector target a schema database. Statements sent to it are not wrapped
+// in transactions, because the server applies changes to the schema to all databases that use it.
+// MigrateUp and MigrateDown detect it and apply migrations to the schema.
 func WithSchemaDb(schemaDb bool) Option {
 	return option(func(o *config) error {
 		if o.schemaDb != nil {
@@ -76,6 +107,113 @@ func WithSchemaDb(schemaDb bool) Option {
 	})
 }
 
+// WithProtobuf makes HTTP connections encode Hrana messages with Protocol Buffers instead of JSON,
+// which is more compact for large results. Servers that don't support it are sent JSON. WebSocket
+// connections always use JSON.
+func WithProtobuf(protobuf bool) Option {
+	return option(func(o *config) error {
+		if o.protobuf != nil {
+			return fmt.Errorf("protobuf already set")
+		}
+		o.protobuf = &protobuf
+		return nil
+	})
+}
+
+// WithHTTPClient sets the client that sends HTTP requests and performs WebSocket handshakes, for
+// example to configure timeouts, proxies or tracing on its transport. It defaults to
+// http.DefaultClient.
+func WithHTTPClient(httpClient *net_http.Client) Option {
+	return option(func(o *config) error {
+		if o.httpClient != nil {
+			return fmt.Errorf("httpClient already set")
+		}
+		if httpClient == nil {
+			return fmt.Errorf("httpClient must not be nil")
+		}
+		o.httpClient = httpClient
+		return nil
+	})
+}
+
+// WithTLSConfig sets the TLS configuration of HTTPS requests and WebSocket handshakes, for example
+// to trust a private certificate authority or to present a client certificate. It can't be combined
+// with WithHTTPClient, whose transport has a TLS configuration of its own, and not with URLs that
+// don't use TLS.
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return option(func(o *config) error {
+		if o.tlsConfig != nil {
+			return fmt.Errorf("tlsConfig already set")
+		}
+		if tlsConfig == nil {
+			return fmt.Errorf("tlsConfig must not be nil")
+		}
+		o.tlsConfig = tlsConfig
+		return nil
+	})
+}
+
+// WithRetryPolicy makes HTTP connections send requests that failed with a transient error again,
+// as configured by retryPolicy. A request is only retried while everything sent on its stream was a
+// read, so retries never replay writes. Without this option requests are not retried. It is not
+// supported for ws:// and wss:// URLs.
+func WithRetryPolicy(retryPolicy RetryPolicy) Option {
+	return option(func(o *config) error {
+		if o.retryPolicy != nil {
+			return fmt.Errorf("retryPolicy already set")
+		}
+		if retryPolicy.MaxAttempts < 0 {
+			return fmt.Errorf("retryPolicy.MaxAttempts must not be negative")
+		}
+		if retryPolicy.InitialBackoff < 0 || retryPolicy.MaxBackoff < 0 {
+			return fmt.Errorf("retryPolicy backoff must not be negative")
+		}
+		o.retryPolicy = &retryPolicy
+		return nil
+	})
+}
+
+// WithDescribe makes prepared statements be described by the server when they are prepared. This
+// costs a round trip per statement, but NumInput is accurate and statements that don't compile
+// against the current schema fail in Prepare instead of on first use.
+func WithDescribe(describe bool) Option {
+	return option(func(o *config) error {
+		if o.describe != nil {
+			return fmt.Errorf("describe already set")
+		}
+		o.describe = &describe
+		return nil
+	})
+}
+
+// WithValueConverter adds a converter for arguments of types that the driver doesn't support, such
+// as UUIDs or decimals. Converters are tried in the order they were added, before driver.Valuer and
+// the default conversion.
+func WithValueConverter(converter ValueConverter) Option {
+	return option(func(o *config) error {
+		if converter == nil {
+			return fmt.Errorf("valueConverter must not be nil")
+		}
+		o.converters = append(o.converters, converter)
+		return nil
+	})
+}
+
+// WithTimeEncoding sets how time.Time arguments are written to remote databases and which columns
+// are read back as time.Time. Local databases are left to the SQLite driver.
+func WithTimeEncoding(timeEncoding TimeEncoding) Option {
+	return option(func(o *config) error {
+		if o.timeEncoding != nil {
+			return fmt.Errorf("timeEncoding already set")
+		}
+		if err := timeEncoding.Validate(); err != nil {
+			return fmt.Errorf("invalid timeEncoding: %w", err)
+		}
+		o.timeEncoding = &timeEncoding
+		return nil
+	})
+}
+
 func (c config) connector(dbPath string) (driver.Connector, error) {
 	u, err := url.Parse(dbPath)
 	if err != nil {
@@ -135,9 +273,15 @@ func (c config) connector(dbPath string) (driver.Connector, error) {
 		return nil, fmt.Errorf("%s:// URL cannot opt in to TLS. Only libsql:// can opt in/out of TLS", u.Scheme)
 	}
 
-	authToken := ""
+	token := shared.StaticToken("")
 	if c.authToken != nil {
-		authToken = *c.authToken
+		token = shared.StaticToken(*c.authToken)
+	}
+	if c.authTokenProvider != nil {
+		if c.authToken != nil {
+			return nil, fmt.Errorf("authToken cannot be used together with authTokenProvider")
+		}
+		token = c.authTokenProvider
 	}
 
 	host := u.Host
@@ -155,16 +299,65 @@ func (c config) connector(dbPath string) (driver.Connector, error) {
 		}
 	}
 
+	httpClient := c.httpClient
+	if c.tlsConfig != nil {
+		if u.Scheme == "http" || u.Scheme == "ws" {
+			return nil, fmt.Errorf("%s:// URL cannot use a TLS config. Use https://, wss:// or libsql:// with TLS instead", u.Scheme)
+		}
+		if httpClient != nil {
+			return nil, fmt.Errorf("tlsConfig cannot be used together with httpClient. Please set TLSClientConfig on the transport of the client instead")
+		}
+		transport := net_http.DefaultTransport.(*net_http.Transport).Clone()
+		transport.TLSClientConfig = c.tlsConfig
+		httpClient = &net_http.Client{Transport: transport}
+	}
+
 	schemaDb := false
 	if c.schemaDb != nil {
 		schemaDb = *c.schemaDb
 	}
 
+	protobuf := false
+	if c.protobuf != nil {
+		protobuf = *c.protobuf
+	}
+
+	retryPolicy := RetryPolicy{}
+	if c.retryPolicy != nil {
+		if u.Scheme == "ws" || u.Scheme == "wss" {
+			return nil, fmt.Errorf("retrying of ws:// and wss:// requests is not supported")
+		}
+		retryPolicy = *c.retryPolicy
+	}
+
+	describe := false
+	if c.describe != nil {
+		describe = *c.describe
+	}
+
+	codec := shared.Codec{Converters: c.converters}
+	if c.timeEncoding != nil {
+		codec.Time = *c.timeEncoding
+	}
+
 	if u.Scheme == "wss" || u.Scheme == "ws" {
-		return wsConnector{url: u.String(), authToken: authToken}, nil
+		return wsConnector{ws.NewConnector(u.String(), token, httpClient, describe, codec)}, nil
 	}
 	if u.Scheme == "https" || u.Scheme == "http" {
-		return httpConnector{url: u.String(), authToken: authToken, host: host, schemaDb: schemaDb}, nil
+		return httpConnector{http.Config{
+			URL:         u.String(),
+			Token:       token,
+			Host:        host,
+			SchemaDb:    schemaDb,
+			Protobuf:    protobuf,
+			Client:      httpClient,
+			RetryPolicy: retryPolicy,
+			Describe:    describe,
+			Codec:       codec,
+			// The endpoint cache is shared by all connections, so that the server is probed for the
+			// endpoints it supports only once.
+			Endpoints: &http.EndpointCache{},
+		}}, nil
 	}
 
 	return nil, fmt.Errorf("unsupported URL scheme: %s\nThis driver supports only URLs that start with libsql://, file://, https://, http://, wss:// and ws://", u.Scheme)
@@ -185,14 +378,11 @@ func NewConnector(dbPath string, opts ...Option) (driver.Connector, error) {
 }
 
 type httpConnector struct {
-	url       string
-	authToken string
-	host      string
-	schemaDb  bool
+	config http.Config
 }
 
 func (c httpConnector) Connect(_ctx context.Context) (driver.Conn, error) {
-	return http.Connect(c.url, c.authToken, c.host, c.schemaDb), nil
+	return http.Connect(c.config), nil
 }
 
 func (c httpConnector) Driver() driver.Driver {
@@ -200,12 +390,11 @@ func (c httpConnector) Driver() driver.Driver {
 }
 
 type wsConnector struct {
-	url       string
-	authToken string
+	connector *ws.Connector
 }
 
-func (c wsConnector) Connect(_ctx context.Context) (driver.Conn, error) {
-	return ws.Connect(c.url, c.authToken)
+func (c wsConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.connector.Connect(ctx)
 }
 
 func (c wsConnector) Driver() driver.Driver {
@@ -341,7 +530,7 @@ func (d Driver) Open(dbUrl string) (driver.Conn, error) {
 		return ws.Connect(u.String(), jwt)
 	}
 	if u.Scheme == "https" || u.Scheme == "http" {
-		return http.Connect(u.String(), jwt, u.Host, false), nil
+		return http.Connect(http.Config{URL: u.String(), Token: shared.StaticToken(jwt), Host: u.Host}), nil
 	}
 
 	return nil, fmt.Errorf("unsupported URL scheme: %s\nThis driver supports only URLs that start with libsql://, file://, https://, http://, wss:// and ws://", u.Scheme)
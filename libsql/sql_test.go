@@ -0,0 +1,200 @@
+package libsql
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/libsqltest"
+)
+
+func TestWithRetryPolicy(t *testing.T) {
+	tests := []struct {
+		policy RetryPolicy
+		err    string
+	}{
+		{policy: RetryPolicy{}},
+		{policy: RetryPolicy{MaxAttempts: 1}},
+		{policy: RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Second}},
+		{policy: RetryPolicy{MaxAttempts: -1}, err: "must not be negative"},
+		{policy: RetryPolicy{MaxAttempts: 2, InitialBackoff: -time.Second}, err: "backoff must not be negative"},
+	}
+	for _, tt := range tests {
+		_, err := NewConnector("http://example.com", WithRetryPolicy(tt.policy))
+		if tt.err == "" && err != nil {
+			t.Errorf("%+v: got %v", tt.policy, err)
+		} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%+v: got %v, want an error containing %q", tt.policy, err, tt.err)
+		}
+	}
+	if _, err := NewConnector("ws://example.com", WithRetryPolicy(RetryPolicy{MaxAttempts: 2})); err == nil {
+		t.Errorf("got no error for a ws:// URL")
+	}
+}
+
+func TestWithTLSConfig(t *testing.T) {
+	ts := httptest.NewTLSServer(newTestServer(t))
+	defer ts.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+	tlsConfig := &tls.Config{RootCAs: roots}
+
+	ctx := context.Background()
+	urls := map[string]string{
+		"https": ts.URL,
+		"wss":   "wss" + strings.TrimPrefix(ts.URL, "https"),
+	}
+	for name, url := range urls {
+		t.Run(name, func(t *testing.T) {
+			query := func(opts ...Option) error {
+				connector, err := NewConnector(url, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				db := sql.OpenDB(connector)
+				defer db.Close()
+				var one int
+				return db.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+			}
+			// The certificate of the server is only trusted with tlsConfig.
+			if err := query(); err == nil || !strings.Contains(err.Error(), "certificate") {
+				t.Errorf("got %v without tlsConfig, want a certificate error", err)
+			}
+			if err := query(WithTLSConfig(tlsConfig)); err != nil {
+				t.Errorf("got %v with tlsConfig", err)
+			}
+		})
+	}
+
+	if _, err := NewConnector("http://example.com", WithTLSConfig(tlsConfig)); err == nil {
+		t.Errorf("got no error for an http:// URL")
+	}
+}
+
+func TestWithProtobuf(t *testing.T) {
+	srv := newTestServer(t)
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		protobuf bool
+		want     []string
+	}{
+		{
+			name:     "protobuf",
+			protobuf: true,
+			want: []string{
+				"GET /v3-protobuf 200",
+				"POST /v3-protobuf/pipeline application/x-protobuf application/x-protobuf",
+				"POST /v3-protobuf/cursor application/x-protobuf application/x-protobuf",
+			},
+		},
+		{
+			// The server doesn't answer GET /v3-protobuf, so the client falls back to JSON.
+			name: "json fallback",
+			want: []string{
+				"GET /v3-protobuf 404",
+				"GET /v3 200",
+				"POST /v3/pipeline application/json application/json",
+				"POST /v3/cursor application/json application/json",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv.SetProtobuf(tt.protobuf)
+			var mu sync.Mutex
+			var requests []string
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+				srv.ServeHTTP(sw, r)
+				request := fmt.Sprintf("%s %s %d", r.Method, r.URL.Path, sw.status)
+				if r.Method == http.MethodPost {
+					request = fmt.Sprintf("%s %s %s %s", r.Method, r.URL.Path, r.Header.Get("Content-Type"), w.Header().Get("Content-Type"))
+				}
+				mu.Lock()
+				requests = append(requests, request)
+				mu.Unlock()
+			}))
+			defer proxy.Close()
+			var protobufRequests int
+			srv.SetHook(func(req libsqltest.Request) *libsqltest.Error {
+				mu.Lock()
+				defer mu.Unlock()
+				if req.Protobuf {
+					protobufRequests++
+				}
+				return nil
+			})
+			defer srv.SetHook(nil)
+
+			connector, err := NewConnector(proxy.URL, WithProtobuf(true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			db := sql.OpenDB(connector)
+			defer db.Close()
+			conn, err := db.Conn(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			table := strings.ReplaceAll("protobuf_"+tt.name, " ", "_")
+			if _, err := conn.ExecContext(ctx, "CREATE TABLE "+table+" (i INTEGER, r REAL, t TEXT, b BLOB, n)"); err != nil {
+				t.Fatal(err)
+			}
+			want := []any{int64(-7), 1.5, "text", []byte{0, 1, 2}, nil}
+			if _, err := conn.ExecContext(ctx, "INSERT INTO "+table+" VALUES (?, ?, ?, ?, ?)", want...); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]any, len(want))
+			dest := make([]any, len(got))
+			for idx := range got {
+				dest[idx] = &got[idx]
+			}
+			if err := conn.QueryRowContext(ctx, "SELECT * FROM "+table).Scan(dest...); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got row %#v, want %#v", got, want)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			// The exec requests all go to the pipeline endpoint, so only the distinct requests are
+			// compared.
+			var distinct []string
+			for _, request := range requests {
+				if len(distinct) == 0 || distinct[len(distinct)-1] != request {
+					distinct = append(distinct, request)
+				}
+			}
+			if !reflect.DeepEqual(distinct, tt.want) {
+				t.Errorf("got requests\n%s\nwant\n%s", strings.Join(distinct, "\n"), strings.Join(tt.want, "\n"))
+			}
+			if tt.protobuf != (protobufRequests > 0) {
+				t.Errorf("the server got %d protobuf requests, want protobuf %v", protobufRequests, tt.protobuf)
+			}
+		})
+	}
+}
+
+// statusWriter records the status of the response written to ResponseWriter.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
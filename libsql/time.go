@@ -0,0 +1,27 @@
+package libsql
+
+import (
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
+)
+
+// TimeEncoding configures how time.Time arguments are written to a remote database and which
+// columns are read back as time.Time. The zero value writes text like
+// "2006-01-02 15:04:05.999999999-07:00" and reads TIMESTAMP and DATETIME columns in UTC. Set it
+// with WithTimeEncoding.
+type TimeEncoding = shared.TimeEncoding
+
+// TimeFormat is how time.Time arguments are written.
+type TimeFormat = shared.TimeFormat
+
+const (
+	// TimeFormatSQLite writes text like "2006-01-02 15:04:05.999999999-07:00". It is the default.
+	TimeFormatSQLite = shared.TimeFormatSQLite
+	// TimeFormatRFC3339 writes text in the RFC 3339 format with nanoseconds.
+	TimeFormatRFC3339 = shared.TimeFormatRFC3339
+	// TimeFormatUnixSeconds writes integer seconds since the Unix epoch.
+	TimeFormatUnixSeconds = shared.TimeFormatUnixSeconds
+	// TimeFormatUnixMillis writes integer milliseconds since the Unix epoch.
+	TimeFormatUnixMillis = shared.TimeFormatUnixMillis
+	// TimeFormatJulianDay writes the fractional Julian day number, like the julianday() function.
+	TimeFormatJulianDay = shared.TimeFormatJulianDay
+)
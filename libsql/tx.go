@@ -0,0 +1,26 @@
+package libsql
+
+import (
+	"context"
+
+	"github.com/tursodatabase/libsql-client-go/libsql/internal/http/shared"
+)
+
+// TxMode is the locking mode of a transaction on a remote database.
+type TxMode = shared.TxMode
+
+const (
+	// TxDeferred starts a transaction with a plain BEGIN. It is the default.
+	TxDeferred = shared.TxDeferred
+	// TxImmediate starts a transaction with BEGIN IMMEDIATE.
+	TxImmediate = shared.TxImmediate
+	// TxExclusive starts a transaction with BEGIN EXCLUSIVE.
+	TxExclusive = shared.TxExclusive
+)
+
+// ContextWithTxMode returns a context that makes BeginTx start transactions in mode, so that jobs
+// which write can take the write lock up front. Read-only transactions, started with
+// sql.TxOptions{ReadOnly: true}, can only be deferred.
+func ContextWithTxMode(ctx context.Context, mode TxMode) context.Context {
+	return shared.ContextWithTxMode(ctx, mode)
+}
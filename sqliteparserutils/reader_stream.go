@@ -0,0 +1,130 @@
+package sqliteparserutils
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/antlr4-go/antlr/v4"
+)
+
+// readerCharStream is an antlr.CharStream that reads characters from an io.Reader on demand. Unlike
+// antlr.NewIoStream it doesn't keep the whole input in memory: characters before the position passed
+// to discard are dropped, so only the statement that is being tokenized is buffered.
+type readerCharStream struct {
+	reader *bufio.Reader
+	// data holds the characters from offset on that were read so far.
+	data   []rune
+	offset int
+	index  int
+	eof    bool
+	err    error
+}
+
+func newReaderCharStream(r io.Reader) *readerCharStream {
+	return &readerCharStream{reader: bufio.NewReaderSize(r, 64*1024), data: make([]rune, 0, 4096)}
+}
+
+// fill reads characters until the character at absolute position pos is buffered or the input ends.
+func (s *readerCharStream) fill(pos int) {
+	for !s.eof && pos >= s.offset+len(s.data) {
+		r, _, err := s.reader.ReadRune()
+		if err != nil {
+			s.eof = true
+			if err != io.EOF {
+				s.err = err
+			}
+			return
+		}
+		s.data = append(s.data, r)
+	}
+}
+
+// discard drops the characters before pos. They can't be read anymore.
+func (s *readerCharStream) discard(pos int) {
+	n := pos - s.offset
+	if n <= 0 {
+		return
+	}
+	if n > len(s.data) {
+		n = len(s.data)
+	}
+	s.data = s.data[:copy(s.data, s.data[n:])]
+	s.offset += n
+}
+
+func (s *readerCharStream) Consume() {
+	s.fill(s.index)
+	if s.index >= s.offset+len(s.data) {
+		panic("cannot consume EOF")
+	}
+	s.index++
+}
+
+func (s *readerCharStream) LA(offset int) int {
+	if offset == 0 {
+		return 0
+	}
+	if offset < 0 {
+		offset++
+	}
+	pos := s.index + offset - 1
+	s.fill(pos)
+	if pos < s.offset || pos >= s.offset+len(s.data) {
+		return antlr.TokenEOF
+	}
+	return int(s.data[pos-s.offset])
+}
+
+func (s *readerCharStream) Mark() int {
+	return -1
+}
+
+func (s *readerCharStream) Release(int) {}
+
+func (s *readerCharStream) Index() int {
+	return s.index
+}
+
+func (s *readerCharStream) Seek(index int) {
+	if index > s.index {
+		s.fill(index - 1)
+		if end := s.offset + len(s.data); index > end {
+			index = end
+		}
+	}
+	s.index = index
+}
+
+// Size returns the number of characters read so far, which is the size of the input once it was read
+// to its end.
+func (s *readerCharStream) Size() int {
+	return s.offset + len(s.data)
+}
+
+func (s *readerCharStream) GetSourceName() string {
+	return ""
+}
+
+func (s *readerCharStream) GetText(start int, stop int) string {
+	if start < s.offset {
+		start = s.offset
+	}
+	if end := s.offset + len(s.data); stop >= end {
+		stop = end - 1
+	}
+	if start > stop {
+		return ""
+	}
+	return string(s.data[start-s.offset : stop-s.offset+1])
+}
+
+func (s *readerCharStream) GetTextFromTokens(start, stop antlr.Token) string {
+	if start != nil && stop != nil {
+		return s.GetTextFromInterval(antlr.NewInterval(start.GetTokenIndex(), stop.GetTokenIndex()))
+	}
+	return ""
+}
+
+func (s *readerCharStream) GetTextFromInterval(i antlr.Interval) string {
+	return s.GetText(i.Start, i.Stop)
+}
@@ -1,6 +1,8 @@
 package sqliteparserutils
 
 import (
+	"io"
+
 	"github.com/antlr4-go/antlr/v4"
 
 	"github.com/tursodatabase/libsql-client-go/sqliteparser"
@@ -17,12 +19,32 @@ type SplitStatementExtraInfo struct {
 type StatementIterator struct {
 	tokenizer    *bufferedTokenizer
 	currentToken antlr.Token
+	// stream is the source of the tokenizer if the iterator reads from an io.Reader.
+	stream *readerCharStream
 }
 
 func CreateStatementIterator(statement string) *StatementIterator {
 	return &StatementIterator{tokenizer: createStringTokenizer(statement)}
 }
 
+// CreateStatementIteratorFromReader creates an iterator that reads the statements from r as they are
+// needed, so that scripts of any size can be split without loading them into memory. If reading
+// from r fails, the iterator reports EOF and Err returns the error.
+func CreateStatementIteratorFromReader(r io.Reader) *StatementIterator {
+	stream := newReaderCharStream(r)
+	lexer := sqliteparser.NewSQLiteLexer(stream)
+	return &StatementIterator{tokenizer: createBufferedTokenizer(lexer, 3), stream: stream}
+}
+
+// Err returns the error that stopped reading the input of an iterator created with
+// CreateStatementIteratorFromReader.
+func (iterator *StatementIterator) Err() error {
+	if iterator.stream == nil {
+		return nil
+	}
+	return iterator.stream.err
+}
+
 func (iterator *StatementIterator) Next() (statement string, extraInfo SplitStatementExtraInfo, isEOF bool) {
 	var (
 		insideCreateTriggerStmt = false
@@ -71,6 +93,10 @@ func (iterator *StatementIterator) Next() (statement string, extraInfo SplitStat
 	statement = ""
 	if startPosition != -1 {
 		statement = iterator.tokenizer.source.GetInputStream().GetText(startPosition, previousToken.GetStop())
+		if iterator.stream != nil {
+			// The text of the statement was taken, so it doesn't have to be kept in memory anymore.
+			iterator.stream.discard(previousToken.GetStop() + 1)
+		}
 	}
 	return statement, extraInfo, iterator.tokenizer.IsEOF() || insideMultilineComment
 }
@@ -1,8 +1,14 @@
 package sqliteparserutils_test
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
 	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/antlr4-go/antlr/v4"
 
@@ -204,6 +210,76 @@ func TestSplitStatement(t *testing.T) {
 			if !reflect.DeepEqual(gotExtraInfo, tt.extraInfo) {
 				t.Errorf("got %#v, want %#v", gotExtraInfo, tt.extraInfo)
 			}
+			// Splitting the statements from a reader must give the same results.
+			gotStmts, gotExtraInfo = splitReader(t, strings.NewReader(tt.value))
+			if !reflect.DeepEqual(gotStmts, tt.stmts) {
+				t.Errorf("got %#v from reader, want %#v", gotStmts, tt.stmts)
+			}
+			if !reflect.DeepEqual(gotExtraInfo, tt.extraInfo) {
+				t.Errorf("got %#v from reader, want %#v", gotExtraInfo, tt.extraInfo)
+			}
 		})
 	}
 }
+
+func splitReader(t *testing.T, r io.Reader) ([]string, sqliteparserutils.SplitStatementExtraInfo) {
+	iterator := sqliteparserutils.CreateStatementIteratorFromReader(r)
+	stmts := []string{}
+	for {
+		stmt, extraInfo, isEOF := iterator.Next()
+		if stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+		if isEOF {
+			if err := iterator.Err(); err != nil {
+				t.Fatal(err)
+			}
+			return stmts, extraInfo
+		}
+	}
+}
+
+func TestStatementIteratorFromLargeReader(t *testing.T) {
+	const count = 100000
+	pr, pw := io.Pipe()
+	go func() {
+		w := bufio.NewWriter(pw)
+		for i := 0; i < count; i++ {
+			fmt.Fprintf(w, "INSERT INTO t VALUES (%d, 'héllo; /* not a comment */');\n", i)
+		}
+		w.Flush()
+		pw.Close()
+	}()
+	iterator := sqliteparserutils.CreateStatementIteratorFromReader(pr)
+	got := 0
+	for {
+		stmt, _, isEOF := iterator.Next()
+		if stmt != "" {
+			if want := fmt.Sprintf("INSERT INTO t VALUES (%d, 'héllo; /* not a comment */')", got); stmt != want {
+				t.Fatalf("got %q, want %q", stmt, want)
+			}
+			got++
+		}
+		if isEOF {
+			break
+		}
+	}
+	if got != count {
+		t.Errorf("got %d statements, want %d", got, count)
+	}
+}
+
+func TestStatementIteratorReaderError(t *testing.T) {
+	readErr := errors.New("read failed")
+	iterator := sqliteparserutils.CreateStatementIteratorFromReader(io.MultiReader(strings.NewReader("SELECT 1; SELECT 2"), iotest.ErrReader(readErr)))
+	stmt, _, isEOF := iterator.Next()
+	if stmt != "SELECT 1" || isEOF {
+		t.Fatalf("got %q, %v, want the first statement", stmt, isEOF)
+	}
+	for !isEOF {
+		_, _, isEOF = iterator.Next()
+	}
+	if !errors.Is(iterator.Err(), readErr) {
+		t.Errorf("got %v, want %v", iterator.Err(), readErr)
+	}
+}
@@ -9,11 +9,14 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"runtime/debug"
 	"testing"
 	"time"
 
 	"github.com/tursodatabase/libsql-client-go/libsql"
+	"github.com/tursodatabase/libsql-client-go/libsql/libsqltest"
+	_ "modernc.org/sqlite"
 
 	"golang.org/x/sync/errgroup"
 
@@ -42,8 +45,25 @@ type Database struct {
 	ctx context.Context
 }
 
+// startServer starts an in-process Hrana server for the tests that run without a sqld instance.
+func startServer(t testing.TB) string {
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := libsqltest.NewServer(db)
+	t.Cleanup(func() {
+		srv.Close()
+		db.Close()
+	})
+	return srv.URL
+}
+
 func getDb(t T) Database {
 	dbURL := os.Getenv("LIBSQL_TEST_HTTP_DB_URL")
+	if dbURL == "" {
+		dbURL = startServer(t)
+	}
 	authToken := os.Getenv("LIBSQL_TEST_HTTP_AUTH_TOKEN")
 	var connector driver.Connector
 	var err error
@@ -6,17 +6,42 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/tursodatabase/libsql-client-go/libsql"
+	"github.com/tursodatabase/libsql-client-go/libsql/libsqltest"
+	_ "modernc.org/sqlite"
 
 	_ "github.com/tursodatabase/libsql-client-go/libsql"
 )
 
+// startServer starts an in-process Hrana server for the tests that run without a sqld instance.
+func startServer(t testing.TB) string {
+	return newServer(t).WebSocketURL()
+}
+
+func newServer(t testing.TB) *libsqltest.Server {
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := libsqltest.NewServer(db)
+	t.Cleanup(func() {
+		srv.Close()
+		db.Close()
+	})
+	return srv
+}
+
 // setupDB sets up a test database by connecting to libsql server and creates a `test` table
 func setupDB(ctx context.Context, t *testing.T) *sql.DB {
 	dbURL := os.Getenv("LIBSQL_TEST_WS_DB_URL")
+	if dbURL == "" {
+		dbURL = startServer(t)
+	}
 	authToken := os.Getenv("LIBSQL_TEST_WS_AUTH_TOKEN")
 	var connector driver.Connector
 	var err error
@@ -406,3 +431,90 @@ func TestParameterSyntaxSupport(t *testing.T) {
 		cleanupDB(ctx, t, db)
 	})
 }
+
+func TestStoredStatements(t *testing.T) {
+	ctx := context.Background()
+	srv := newServer(t)
+	db, err := sql.Open("libsql", srv.WebSocketURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if _, err := db.ExecContext(ctx, "CREATE TABLE test (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	srv.Requests()
+
+	stmt, err := db.PrepareContext(ctx, "INSERT INTO test (name) VALUES (?)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := stmt.ExecContext(ctx, "hello world"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stmt.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// A script can't be stored, so it is sent whenever it is executed.
+	script, err := db.PrepareContext(ctx, "SELECT 1; SELECT 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer script.Close()
+
+	// The SQL is sent once and then referred to by its id.
+	want := []string{"store_sql INSERT INTO test (name) VALUES (?)", "execute", "execute", "close_sql"}
+	if got := fmt.Sprintf("%q", srv.Requests()); got != fmt.Sprintf("%q", want) {
+		t.Errorf("got requests %s, want %q", got, want)
+	}
+	assertRows(ctx, t, db)
+}
+
+func TestResetSessionRollsBack(t *testing.T) {
+	ctx := context.Background()
+	srv := newServer(t)
+	db, err := sql.Open("libsql", srv.WebSocketURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if _, err := db.ExecContext(ctx, "CREATE TABLE test (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ExecContext(ctx, "BEGIN"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ExecContext(ctx, "INSERT INTO test (name) VALUES (?)", "hello world"); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	srv.Requests()
+
+	// The connection is reused, without the transaction that was left open on it.
+	var count int
+	if err := db.QueryRowContext(ctx, "SELECT count(*) FROM test").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("got %d rows, want the open transaction to be rolled back", count)
+	}
+	// Once no transaction is open, the server isn't asked again.
+	if err := db.QueryRowContext(ctx, "SELECT count(*) FROM test").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"get_autocommit", "execute ROLLBACK", "execute SELECT count(*) FROM test", "execute SELECT count(*) FROM test"}
+	if got := fmt.Sprintf("%q", srv.Requests()); got != fmt.Sprintf("%q", want) {
+		t.Errorf("got requests %s, want %q", got, want)
+	}
+}